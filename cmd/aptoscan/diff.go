@@ -0,0 +1,68 @@
+package main
+
+import (
+	"apotscan/processor/module"
+	"context"
+	"fmt"
+	"gorm.io/gorm"
+	"io"
+	"strconv"
+	"strings"
+)
+
+//diffModule Runs `diff <address>::<name> [from to]`, comparing the module's publications at versions `from` and `to`,
+//its latest upgrade when no versions are given, and writes the report to `w`. It returns false if the upgrade breaks compatibility
+func diffModule(ctx context.Context, db *gorm.DB, args []string, w io.Writer) (bool, error) {
+	if len(args) != 1 && len(args) != 3 {
+		return false, fmt.Errorf("diff takes a module and optionally two versions, got %d arguments", len(args))
+	}
+	i := strings.LastIndex(args[0], "::")
+	if i <= 0 || i == len(args[0])-2 {
+		return false, fmt.Errorf("invalid module %q, want <address>::<name>", args[0])
+	}
+	address, name := args[0][:i], args[0][i+2:]
+
+	var diff *module.UpgradeDiff
+	var err error
+	if len(args) == 1 {
+		diff, err = module.DiffLatest(ctx, db, address, name)
+	} else {
+		var from, to int64
+		if from, err = strconv.ParseInt(args[1], 10, 64); err != nil {
+			return false, fmt.Errorf("invalid version %q", args[1])
+		}
+		if to, err = strconv.ParseInt(args[2], 10, 64); err != nil {
+			return false, fmt.Errorf("invalid version %q", args[2])
+		}
+		diff, err = module.DiffVersions(ctx, db, address, name, from, to)
+	}
+	if err != nil {
+		return false, err
+	}
+
+	verdict := "compatible"
+	if !diff.Compatible() {
+		verdict = "breaks compatibility"
+	}
+	fmt.Fprintf(w, "%s::%s %d -> %d: %s\n", diff.Address, diff.Name, diff.From, diff.To, verdict)
+	for _, section := range []struct {
+		name    string
+		members []module.MemberDiff
+	}{{"functions", diff.Functions}, {"structs", diff.Structs}, {"friends", diff.Friends}} {
+		if len(section.members) == 0 {
+			continue
+		}
+		fmt.Fprintf(w, "%s:\n", section.name)
+		for _, member := range section.members {
+			fmt.Fprintf(w, "\t%s %s\n", member.Kind, member.Name)
+			for _, change := range member.Changes {
+				if change.Breaking {
+					fmt.Fprintf(w, "\t\t%s, breaking\n", change.Description)
+				} else {
+					fmt.Fprintf(w, "\t\t%s\n", change.Description)
+				}
+			}
+		}
+	}
+	return diff.Compatible(), nil
+}
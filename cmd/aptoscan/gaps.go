@@ -0,0 +1,33 @@
+package main
+
+import (
+	"apotscan/indexer"
+	"context"
+	"fmt"
+	"io"
+)
+
+//reportGaps Writes every processor's gaps to `w`, it returns false if any processor has a hole
+//between genesis and its max version
+func reportGaps(ctx context.Context, tailor *indexer.Tailor, w io.Writer) (bool, error) {
+	reports, err := tailor.GapReports(ctx)
+	if err != nil {
+		return false, err
+	}
+	complete := true
+	for _, report := range reports {
+		if report.Complete() {
+			fmt.Fprintf(w, "%s: covered 0-%d, no gaps\n", report.Name, report.MaxVersion)
+			continue
+		}
+		complete = false
+		fmt.Fprintf(w, "%s: %d gaps up to max version %d\n", report.Name, len(report.Gaps), report.MaxVersion)
+		for _, gap := range report.Gaps {
+			fmt.Fprintf(w, "\t%d-%d\n", gap.StartVersion, gap.EndVersion)
+		}
+		if report.Ordered {
+			fmt.Fprintf(w, "\tapplies versions in order, reprocess from version %d\n", report.Gaps[0].StartVersion)
+		}
+	}
+	return complete, nil
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"apotscan/indexer"
+	"apotscan/logger"
+	"apotscan/metrics"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"net/http"
+	"time"
+)
+
+//httpShutdownTimeout How long requests in flight get once the server is shutting down
+const httpShutdownTimeout = 5 * time.Second
+
+//serveHTTP Serves the metrics, the probes and the status of `tailor` on `addr` in the background until `ctx` is done
+func serveHTTP(ctx context.Context, addr string, tailor *indexer.Tailor, _logger *logger.Logger) {
+	server := &http.Server{
+		Addr:    addr,
+		Handler: newHTTPHandler(tailor, *livenessTimeout),
+	}
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), httpShutdownTimeout)
+		defer cancel()
+		if err := server.Shutdown(shutdownCtx); err != nil {
+			_logger.WithError(err).Error("can not shut down http server")
+		}
+	}()
+	go func() {
+		_logger.WithFields(log.Fields{
+			"addr": addr,
+		}).Info("Serving metrics and status")
+		if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			_logger.WithError(err).Error("can not serve http")
+		}
+	}()
+}
+
+//newHTTPHandler /healthz fails once `tailor` has been idle for longer than `maxIdle`, /readyz until it tails new batches.
+///status reports what it is doing as JSON and /metrics serves the prometheus metrics
+func newHTTPHandler(tailor *indexer.Tailor, maxIdle time.Duration) http.Handler {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", metrics.Handler())
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		if !tailor.Alive(maxIdle) {
+			http.Error(w, fmt.Sprintf("idle for more than %s", maxIdle), http.StatusServiceUnavailable)
+			return
+		}
+		fmt.Fprintln(w, "ok")
+	})
+	mux.HandleFunc("/readyz", func(w http.ResponseWriter, r *http.Request) {
+		if err := tailor.Ready(r.Context()); err != nil {
+			http.Error(w, err.Error(), http.StatusServiceUnavailable)
+			return
+		}
+		fmt.Fprintln(w, "ok")
+	})
+	mux.HandleFunc("/status", func(w http.ResponseWriter, r *http.Request) {
+		status, err := tailor.Status(r.Context())
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		if err = json.NewEncoder(w).Encode(status); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+	})
+	return mux
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"apotscan/fakenode"
+	"apotscan/indexer"
+	"apotscan/logger"
+	"apotscan/types"
+	"context"
+	"encoding/json"
+	"github.com/go-redis/redis/v8"
+	log "github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+	gormlogger "gorm.io/gorm/logger"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestHTTPHandler_ReportsProgress(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	server, err := fakenode.NewServer(4, "../../processor/token/testdata/transactions.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+	db, err := openDB("sqlite", filepath.Join(t.TempDir(), "aptoscan.db"), &gorm.Config{
+		Logger: gormlogger.Default.LogMode(gormlogger.Silent),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = migrate(ctx, db, nil, io.Discard); err != nil {
+		t.Fatal(err)
+	}
+
+	logConf := &logger.Config{Level: log.ErrorLevel, FilePath: filepath.Join(t.TempDir(), "aptoscan")}
+	fetcher := indexer.NewFetcher(indexer.NewNodePoolFromUrls(server.URL), 0)
+	fetcher.PollInterval = 10 * time.Millisecond
+	tailor := indexer.NewTailor(fetcher, db, logConf, nil)
+	if err = tailor.CheckOrUpdateChainId(ctx); err != nil {
+		t.Fatal(err)
+	}
+	_logger, err := logger.New(logConf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tailor.AddProcessor(&indexer.Processor{TransactionProcessor: &noopProcessor{db: db, logger: _logger}})
+	if err = db.Create(&types.ProcessorStatus{Name: "token", StartVersion: 3, EndVersion: 4, Detail: "boom"}).Error; err != nil {
+		t.Fatal(err)
+	}
+	handler := newHTTPHandler(tailor, time.Minute)
+
+	if code, _ := get(t, handler, "/readyz"); code != http.StatusServiceUnavailable {
+		t.Fatalf("got /readyz %d before tailing, want %d", code, http.StatusServiceUnavailable)
+	}
+	status := getStatus(t, handler)
+	if status.ChainId != 4 || status.LedgerVersion != server.LedgerVersion() {
+		t.Fatalf("got chain %d at version %d, want chain 4 at version %d", status.ChainId, status.LedgerVersion, server.LedgerVersion())
+	}
+	report := status.Processors[0]
+	if report.MaxVersion != -1 || report.VersionsBehind != server.LedgerVersion()+1 {
+		t.Fatalf("got %+v before processing anything", report)
+	}
+	if report.LastError == nil || report.LastError.Detail != "boom" || report.LastError.StartVersion != 3 {
+		t.Fatalf("got last error %+v, want the failed range 3-4", report.LastError)
+	}
+
+	if _, err = tailor.SetProcessorCursors(ctx); err != nil {
+		t.Fatal(err)
+	}
+	tailor.TransactionFetcher.Start(ctx)
+	processor := tailor.Processors()[0]
+	for processor.NextVersion() <= server.LedgerVersion() {
+		if _, _, err = tailor.ProcessNextBatch(ctx, processor, 1, 4); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if code, body := get(t, handler, "/readyz"); code != http.StatusOK {
+		t.Fatalf("got /readyz %d while tailing: %s", code, body)
+	}
+	if code, _ := get(t, handler, "/healthz"); code != http.StatusOK {
+		t.Fatalf("got /healthz %d, want %d", code, http.StatusOK)
+	}
+	report = getStatus(t, handler).Processors[0]
+	if report.MaxVersion != server.LedgerVersion() || report.VersionsBehind != 0 || report.ETASeconds == nil || *report.ETASeconds != 0 {
+		t.Fatalf("got %+v after catching up", report)
+	}
+	// 3-4 succeeded as part of 0-3 and 4-7
+	if report.LastError != nil {
+		t.Fatalf("got last error %+v, want none once the failed range succeeded", report.LastError)
+	}
+}
+
+//noopProcessor Processes every transaction without writing anything but its status
+type noopProcessor struct {
+	db     *gorm.DB
+	logger *logger.Logger
+}
+
+func (p *noopProcessor) Name() string {
+	return "token"
+}
+
+func (p *noopProcessor) ChainId() uint8 {
+	return 4
+}
+
+func (p *noopProcessor) ProcessTransactions(ctx context.Context, db *gorm.DB, txs []types.Transaction, startVersion, endVersion int64) (*types.ProcessResult, error) {
+	return types.NewProcessResult(p.Name(), startVersion, endVersion), nil
+}
+
+func (p *noopProcessor) GetDB() *gorm.DB {
+	return p.db
+}
+
+func (p *noopProcessor) GetRedis() *redis.Client {
+	return nil
+}
+
+func (p *noopProcessor) GetLogger() *logger.Logger {
+	return p.logger
+}
+
+func get(t *testing.T, handler http.Handler, path string) (int, string) {
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, path, nil))
+	return recorder.Code, recorder.Body.String()
+}
+
+func getStatus(t *testing.T, handler http.Handler) indexer.Status {
+	code, body := get(t, handler, "/status")
+	if code != http.StatusOK {
+		t.Fatalf("got /status %d: %s", code, body)
+	}
+	var status indexer.Status
+	if err := json.Unmarshal([]byte(body), &status); err != nil {
+		t.Fatal(err)
+	}
+	return status
+}
@@ -0,0 +1,333 @@
+package main
+
+import (
+	"apotscan/indexer"
+	"apotscan/logger"
+	"apotscan/metrics"
+	"apotscan/processor/core"
+	"apotscan/processor/module"
+	"apotscan/processor/token"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/go-redis/redis/v8"
+	aptos "github.com/portto/aptos-go-sdk/client"
+	log "github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+	"io"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+const (
+	CoreProcessorName   = "core_processor"
+	TokenProcessorName  = "token_processor"
+	ModuleProcessorName = "module_processor"
+)
+
+var (
+	nodeUrls        = flag.String("node-url", "https://fullnode.devnet.aptoslabs.com", "comma separated aptos fullnode rest api urls, in order of preference")
+	nodeTimeout     = flag.Duration("node-timeout", 30*time.Second, "timeout of a single request to a fullnode")
+	nodeRetries     = flag.Int("node-retries", indexer.DefaultNodeMaxRetries, "how many times a failed fullnode request is retried")
+	dbDriver        = flag.String("db-driver", "mysql", "database driver, mysql, postgres or sqlite")
+	dsn             = flag.String("dsn", "root:@tcp(127.0.0.1:3306)/aptoscan?charset=utf8&parseTime=True&loc=Local", "data source name in the format of -db-driver, e.g. \"host=localhost user=aptoscan dbname=aptoscan\" for postgres or a file path for sqlite")
+	redisAddr       = flag.String("redis-addr", "", "redis address, optional, processors resume from the cursors in the database and only read the max versions they kept in redis before")
+	redisPassword   = flag.String("redis-password", "", "redis password")
+	redisDB         = flag.Int("redis-db", 0, "redis db")
+	batchSize       = flag.Uint("batch-size", 1, "number of goroutines a fetched batch is split across, ordered processors such as the token processor always use one")
+	fetchSize       = flag.Int("fetch-size", 100, "number of transactions fetched per batch")
+	fetchWorkers    = flag.Int("fetch-workers", indexer.DefaultFetchWorkers, "number of batches fetched from the node concurrently")
+	fetchBuffer     = flag.Int("fetch-buffer", indexer.DefaultFetchBufferSize, "number of fetched batches buffered ahead of processing")
+	cacheSize       = flag.Int("cache-size", indexer.DefaultBatchCacheSize, "number of fetched transactions kept for processors behind the others")
+	cachePrefetch   = flag.Int("cache-prefetch", indexer.DefaultBatchCachePrefetch, "number of batches a processor behind the others fetches ahead of itself")
+	archiveDir      = flag.String("archive-dir", "", "replay transactions from an archive directory instead of fetching them from the fullnodes")
+	recordDir       = flag.String("record-dir", "", "record every fetched transaction into an archive directory")
+	recordGzip      = flag.Bool("record-gzip", true, "gzip recorded archive files")
+	recordFileSize  = flag.Int("record-file-size", indexer.DefaultRecorderFileSize, "number of transactions per recorded archive file")
+	processTimeout  = flag.Duration("processor-timeout", 5*time.Minute, "how long a processor may take for a single batch before it is failed, 0 disables it")
+	deadLetter      = flag.Bool("dead-letter", false, "re-run a failed batch one transaction at a time and skip the transactions which still fail into the dead_letters table, retry them with the retry command. Ordered processors such as the token processor run one transaction at a time and are reprocessed from the first dead-lettered version instead of retried")
+	pollInterval    = flag.Duration("poll-interval", time.Second, "how long to wait for new transactions once the chain tip is reached")
+	emitEvery       = flag.Int("emit-every", 1000, "log progress every time this many versions have been processed, 0 disables it")
+	shutdownWait    = flag.Duration("shutdown-timeout", 30*time.Second, "how long batches in flight may keep running after SIGINT or SIGTERM before they are cancelled")
+	httpAddr        = flag.String("http-addr", ":9090", "address serving /metrics, the /healthz and /readyz probes and /status, empty disables it")
+	livenessTimeout = flag.Duration("liveness-timeout", 5*time.Minute, "how long the indexer may go without starting a batch before /healthz fails")
+	autoMigrate     = flag.Bool("auto-migrate", false, "apply pending migrations on start instead of refusing to start")
+	indexTokenUri   = flag.Bool("index-token-uri", false, "fetch and index token metadata from token uri")
+	logPath         = flag.String("log-path", "./logs/aptoscan", "log file path")
+	logLevel        = flag.String("log-level", "info", "log level")
+	logMaxAge       = flag.Duration("log-max-age", 7*24*time.Hour, "how long rotated log files are kept")
+	logRotateEvery  = flag.Duration("log-rotation-time", 24*time.Hour, "how often log files are rotated")
+)
+
+func main() {
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage: %s [flags] [run|gaps|retry [processor]|diff <address>::<module> [from to]|migrate [up|down [steps]|status]]\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	level, err := log.ParseLevel(*logLevel)
+	if err != nil {
+		panic(err)
+	}
+	if *batchSize == 0 || *batchSize > 255 {
+		panic("batch-size must be between 1 and 255")
+	}
+	logConf := &logger.Config{
+		Level:        level,
+		FilePath:     *logPath,
+		MaxAge:       *logMaxAge,
+		RotationTime: *logRotateEvery,
+	}
+	_logger, err := logger.New(logConf)
+	if err != nil {
+		panic(err)
+	}
+	defer _logger.Close()
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+	db, err := openDB(*dbDriver, *dsn, &gorm.Config{})
+	if err != nil {
+		_logger.WithError(err).Fatal("can not connect to database")
+	}
+	if err = db.Use(metrics.GormPlugin{}); err != nil {
+		_logger.WithError(err).Fatal("can not instrument database")
+	}
+	if flag.Arg(0) == "migrate" {
+		if err = migrate(ctx, db, flag.Args()[1:], os.Stdout); err != nil {
+			_logger.WithError(err).Fatal("can not migrate")
+		}
+		return
+	}
+	if flag.Arg(0) == "diff" {
+		compatible, err := diffModule(ctx, db, flag.Args()[1:], os.Stdout)
+		if err != nil {
+			_logger.WithError(err).Fatal("can not diff module")
+		}
+		if !compatible {
+			os.Exit(1)
+		}
+		return
+	}
+
+	tailor := newTailor(ctx, db, logConf, _logger)
+	switch command := flag.Arg(0); command {
+	case "", "run":
+		if *httpAddr != "" {
+			serveHTTP(ctx, *httpAddr, tailor, _logger)
+		}
+		if err = tailor.HandlePreviousErrors(ctx); err != nil {
+			if ctx.Err() != nil {
+				_logger.Info("Interrupted while handling previous errors, they will be replayed on the next start")
+				return
+			}
+			_logger.WithError(err).Fatal("can not handle previous errors")
+		}
+		if _, err = tailor.SetProcessorCursors(ctx); err != nil {
+			_logger.WithError(err).Fatal("can not set processor cursors")
+		}
+		tailor.TransactionFetcher.Start(ctx)
+		run(ctx, tailor, _logger)
+		stop()
+		if closer, ok := tailor.TransactionFetcher.(io.Closer); ok {
+			if err = closer.Close(); err != nil {
+				_logger.WithError(err).Error("can not close transaction fetcher")
+			}
+		}
+		_logger.Info("Indexer stopped")
+	case "gaps":
+		complete, err := reportGaps(ctx, tailor, os.Stdout)
+		if err != nil {
+			_logger.WithError(err).Fatal("can not detect gaps")
+		}
+		if !complete {
+			os.Exit(1)
+		}
+	case "retry":
+		complete, err := retryDeadLetters(ctx, tailor, flag.Args()[1:], os.Stdout)
+		if err != nil {
+			_logger.WithError(err).Fatal("can not retry dead letters")
+		}
+		if !complete {
+			os.Exit(1)
+		}
+	default:
+		flag.Usage()
+		os.Exit(2)
+	}
+}
+
+//newTailor Connects to redis if configured, makes sure the schema is up to date, then builds a Tailor with every processor registered
+func newTailor(ctx context.Context, db *gorm.DB, logConf *logger.Config, _logger *logger.Logger) *indexer.Tailor {
+	var redisCli *redis.Client
+	if *redisAddr != "" {
+		redisCli = redis.NewClient(&redis.Options{
+			Addr:     *redisAddr,
+			Password: *redisPassword,
+			DB:       *redisDB,
+		})
+	}
+
+	fetcher, err := newFetcher()
+	if err != nil {
+		_logger.WithError(err).Fatal("can not create transaction fetcher")
+	}
+	tailor := indexer.NewTailor(fetcher, db, logConf, redisCli)
+	tailor.CacheSize = *cacheSize
+	tailor.CacheWindowSize = *fetchSize
+	tailor.CachePrefetch = *cachePrefetch
+	if *autoMigrate {
+		if err = tailor.RunMigrations(ctx); err != nil {
+			_logger.WithError(err).Fatal("can not run migrations")
+		}
+	} else if pending, err := tailor.PendingMigrations(ctx); err != nil {
+		_logger.WithError(err).Fatal("can not check migrations")
+	} else if len(pending) > 0 {
+		_logger.WithFields(log.Fields{
+			"pending": len(pending),
+			"first":   fmt.Sprintf("%d %s", pending[0].Version, pending[0].Name),
+		}).Fatal("Database schema is out of date, run the migrate command or pass -auto-migrate")
+	}
+	if err = tailor.CheckOrUpdateChainId(ctx); err != nil {
+		_logger.WithError(err).Fatal("can not check chain id")
+	}
+	ledgerInfo, err := tailor.TransactionFetcher.FetchLedgerInfo(ctx)
+	if err != nil {
+		_logger.WithError(err).Fatal("can not fetch ledger info")
+	}
+	chainId := uint8(ledgerInfo.ChainID)
+
+	coreProcessor, err := core.New(CoreProcessorName, redisCli, db, chainId, logConf)
+	if err != nil {
+		_logger.WithError(err).Fatal("can not create core processor")
+	}
+	tailor.AddProcessor(&indexer.Processor{TransactionProcessor: coreProcessor, Timeout: *processTimeout, DeadLetter: *deadLetter})
+
+	tokenProcessor, err := token.New(TokenProcessorName, redisCli, db, chainId, logConf, *indexTokenUri)
+	if err != nil {
+		_logger.WithError(err).Fatal("can not create token processor")
+	}
+	tailor.AddProcessor(&indexer.Processor{TransactionProcessor: tokenProcessor, Timeout: *processTimeout, DeadLetter: *deadLetter})
+
+	moduleProcessor, err := module.New(ModuleProcessorName, redisCli, db, chainId, logConf)
+	if err != nil {
+		_logger.WithError(err).Fatal("can not create module processor")
+	}
+	tailor.AddProcessor(&indexer.Processor{TransactionProcessor: moduleProcessor, Timeout: *processTimeout, DeadLetter: *deadLetter})
+	return tailor
+}
+
+//run Runs every processor on its own cursor until `ctx` is done, see `runProcessor`.
+//Once `ctx` is done no new batch is started, the batches in flight get `shutdown-timeout` to finish and write their status
+func run(ctx context.Context, tailor *indexer.Tailor, _logger *logger.Logger) {
+	processCtx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		select {
+		case <-processCtx.Done():
+			return
+		case <-ctx.Done():
+		}
+		_logger.WithFields(log.Fields{
+			"timeout": *shutdownWait,
+		}).Info("Shutting down, waiting for the batches in flight")
+		select {
+		case <-processCtx.Done():
+		case <-time.After(*shutdownWait):
+			_logger.Warn("Batches in flight didn't finish in time, cancelling them")
+			cancel()
+		}
+	}()
+
+	var wg sync.WaitGroup
+	for _, processor := range tailor.Processors() {
+		wg.Add(1)
+		go func(processor *indexer.Processor) {
+			defer wg.Done()
+			runProcessor(ctx, processCtx, tailor, processor, _logger)
+		}(processor)
+	}
+	wg.Wait()
+}
+
+//runProcessor Processes batches of a single processor, it sleeps for `poll-interval` only when a batch comes back short,
+//which means the processor has caught up with the chain tip
+func runProcessor(ctx, processCtx context.Context, tailor *indexer.Tailor, processor *indexer.Processor, _logger *logger.Logger) {
+	startVersion := processor.NextVersion()
+	var versionsProcessed, lastEmitted uint64
+	for ctx.Err() == nil {
+		numTxs, results, err := tailor.ProcessNextBatch(processCtx, processor, uint8(*batchSize), *fetchSize)
+		if err != nil {
+			if errors.Is(err, context.Canceled) {
+				return
+			}
+			_logger.WithFields(log.Fields{
+				"processor": processor.Name(),
+				"error":     err,
+			}).Error("can not process next batch")
+			wait(ctx, *pollInterval)
+			continue
+		}
+		failed := false
+		for _, result := range results {
+			if result.Err() != nil {
+				failed = true
+				_logger.WithFields(log.Fields{
+					"processor":     result.Result().Name,
+					"start version": result.Result().StartVersion,
+					"end version":   result.Result().EndVersion,
+					"error":         result.Err(),
+				}).Error("processor failed")
+			}
+		}
+
+		versionsProcessed += numTxs
+		if *emitEvery != 0 && versionsProcessed-lastEmitted >= uint64(*emitEvery) {
+			lastEmitted = versionsProcessed
+			_logger.WithFields(log.Fields{
+				"processor":          processor.Name(),
+				"start version":      startVersion,
+				"versions processed": versionsProcessed,
+			}).Info("Indexer progress")
+		}
+
+		// a failed slice is retried by the next batch, back off instead of hammering the database.
+		// Otherwise only back off at the chain tip, a short batch while catching up is the end of a cached segment
+		if failed || numTxs == 0 || tailor.CaughtUp(processor) {
+			wait(ctx, *pollInterval)
+		}
+	}
+}
+
+//wait Sleeps for `d`, waking up early once `ctx` is done
+func wait(ctx context.Context, d time.Duration) {
+	select {
+	case <-ctx.Done():
+	case <-time.After(d):
+	}
+}
+
+//newFetcher Reads from `archive-dir` when it is set, otherwise from the fullnodes, recording into `record-dir` when it is set
+func newFetcher() (indexer.TransactionFetcher, error) {
+	if *archiveDir != "" {
+		return indexer.NewArchiveFetcher(*archiveDir)
+	}
+	aptos.WithTimeout(*nodeTimeout)
+	nodes := indexer.NewNodePoolFromUrls(strings.Split(*nodeUrls, ",")...)
+	nodes.MaxRetries = *nodeRetries
+	fetcher := indexer.NewFetcher(nodes, 0)
+	fetcher.Workers = *fetchWorkers
+	fetcher.WindowSize = *fetchSize
+	fetcher.BufferSize = *fetchBuffer
+	fetcher.PollInterval = *pollInterval
+	if *recordDir != "" {
+		return indexer.NewRecorder(fetcher, *recordDir, *recordGzip, *recordFileSize)
+	}
+	return fetcher, nil
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"apotscan/migration"
+	"bytes"
+	"context"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestMigrate_AppliesAndRevertsEveryMigration(t *testing.T) {
+	ctx := context.Background()
+	db, err := openDB("sqlite", filepath.Join(t.TempDir(), "aptoscan.db"), &gorm.Config{
+		Logger: logger.Default.LogMode(logger.Silent),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	registered := len(migration.Registered())
+
+	var out bytes.Buffer
+	if err = migrate(ctx, db, nil, &out); err != nil {
+		t.Fatal(err)
+	}
+	if applied := strings.Count(out.String(), "applied "); applied != registered {
+		t.Fatalf("applied %d migrations, want %d:\n%s", applied, registered, out.String())
+	}
+	for _, table := range []string{"processor_statuses", "processor_cursors", "transactions", "ownerships", "modules"} {
+		if !db.Migrator().HasTable(table) {
+			t.Fatalf("table %s wasn't created", table)
+		}
+	}
+
+	out.Reset()
+	if err = migrate(ctx, db, []string{"status"}, &out); err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(out.String(), "pending") {
+		t.Fatalf("migrations still pending:\n%s", out.String())
+	}
+
+	out.Reset()
+	if err = migrate(ctx, db, []string{"down", "100"}, &out); err != nil {
+		t.Fatal(err)
+	}
+	if reverted := strings.Count(out.String(), "reverted "); reverted != registered {
+		t.Fatalf("reverted %d migrations, want %d:\n%s", reverted, registered, out.String())
+	}
+	if db.Migrator().HasTable("ownerships") {
+		t.Fatal("ownerships wasn't dropped")
+	}
+}
+
+func TestOpenDB_RejectsUnknownDriver(t *testing.T) {
+	if _, err := openDB("oracle", "", &gorm.Config{}); err == nil {
+		t.Fatal("expected an unknown driver to be rejected")
+	}
+}
@@ -0,0 +1,35 @@
+package main
+
+import (
+	"apotscan/indexer"
+	"context"
+	"fmt"
+	"io"
+)
+
+//retryDeadLetters Retries the dead-lettered transactions of the processor named in `args`, of every processor without one,
+//and writes how many were retried to `w`. It returns false if any transaction failed again or was skipped
+func retryDeadLetters(ctx context.Context, tailor *indexer.Tailor, args []string, w io.Writer) (bool, error) {
+	var name string
+	if len(args) > 1 {
+		return false, fmt.Errorf("retry takes at most one processor name, got %d arguments", len(args))
+	} else if len(args) == 1 {
+		name = args[0]
+	}
+	retries, err := tailor.RetryDeadLetters(ctx, name)
+	for _, retry := range retries {
+		fmt.Fprintf(w, "%s: %d retried, %d failed again, %d skipped\n", retry.Name, retry.Retried, retry.Failed, retry.Skipped)
+	}
+	if err != nil {
+		return false, err
+	}
+	if name != "" && len(retries) == 0 {
+		return false, fmt.Errorf("unknown processor %s", name)
+	}
+	for _, retry := range retries {
+		if retry.Failed > 0 || retry.Skipped > 0 {
+			return false, nil
+		}
+	}
+	return true, nil
+}
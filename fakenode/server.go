@@ -0,0 +1,242 @@
+//Package fakenode A stand-in for an aptos fullnode's REST api, serving transactions from fixture files,
+//so the fetcher, the Tailor and the processors can be tested without network access
+package fakenode
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const (
+	//DefaultPageSize, MaxPageSize Same as a real fullnode's `/v1/transactions` limit
+	DefaultPageSize = 25
+	MaxPageSize     = 100
+	//BlockTimestamp Timestamp of the versions which have no fixture
+	BlockTimestamp = "1661299999000000"
+)
+
+//Server Serves `/v1`, `/v1/transactions` and `/v1/transactions/by_version/{v}` like a fullnode does.
+//Versions from 0 to the highest fixture which have no fixture are served as state checkpoint transactions,
+//so the chain is always contiguous
+type Server struct {
+	*httptest.Server
+	ChainId uint8
+
+	mu           sync.RWMutex
+	transactions map[int64]json.RawMessage
+	ledger       int64
+	failures     []int
+}
+
+//NewServer Starts a server for `chainId` serving the transactions in `fixtures`, each a json array
+//of transactions as returned by `/v1/transactions`
+func NewServer(chainId uint8, fixtures ...string) (*Server, error) {
+	s := &Server{
+		ChainId:      chainId,
+		transactions: make(map[int64]json.RawMessage),
+		ledger:       -1,
+	}
+	for _, fixture := range fixtures {
+		data, err := os.ReadFile(fixture)
+		if err != nil {
+			return nil, err
+		}
+		var txs []json.RawMessage
+		if err = json.Unmarshal(data, &txs); err != nil {
+			return nil, fmt.Errorf("fixture %s: %v", fixture, err)
+		}
+		if err = s.AddTransactions(txs...); err != nil {
+			return nil, fmt.Errorf("fixture %s: %v", fixture, err)
+		}
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/v1", s.handleLedgerInfo)
+	mux.HandleFunc("/v1/transactions", s.handleTransactions)
+	mux.HandleFunc("/v1/transactions/by_version/", s.handleTransactionByVersion)
+	s.Server = httptest.NewServer(s.failing(mux))
+	return s, nil
+}
+
+//AddTransactions Appends transactions to the chain, each one needs its `version`
+func (s *Server) AddTransactions(txs ...json.RawMessage) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, tx := range txs {
+		var header struct {
+			Version string `json:"version"`
+		}
+		if err := json.Unmarshal(tx, &header); err != nil {
+			return err
+		}
+		version, err := strconv.ParseInt(header.Version, 10, 64)
+		if err != nil {
+			return fmt.Errorf("transaction has no valid version: %v", err)
+		}
+		s.transactions[version] = tx
+		if version > s.ledger {
+			s.ledger = version
+		}
+	}
+	return nil
+}
+
+//FailNext Answers the next requests with the given status codes, one request each, to exercise retries
+func (s *Server) FailNext(statusCodes ...int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.failures = append(s.failures, statusCodes...)
+}
+
+//LedgerVersion The highest version being served
+func (s *Server) LedgerVersion() int64 {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.ledger
+}
+
+func (s *Server) failing(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.mu.Lock()
+		if len(s.failures) > 0 {
+			statusCode := s.failures[0]
+			s.failures = s.failures[1:]
+			s.mu.Unlock()
+			writeError(w, statusCode, "injected failure", "internal_error")
+			return
+		}
+		s.mu.Unlock()
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (s *Server) handleLedgerInfo(w http.ResponseWriter, r *http.Request) {
+	ledger := s.LedgerVersion()
+	s.writeJSON(w, map[string]interface{}{
+		"chain_id":              s.ChainId,
+		"epoch":                 "1",
+		"ledger_version":        strconv.FormatInt(ledger, 10),
+		"oldest_ledger_version": "0",
+		"ledger_timestamp":      BlockTimestamp,
+		"node_role":             "full_node",
+		"oldest_block_height":   "0",
+		"block_height":          strconv.FormatInt(ledger, 10),
+	})
+}
+
+func (s *Server) handleTransactions(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "only GET is supported", "web_framework_error")
+		return
+	}
+	query := r.URL.Query()
+	start, err := parseQuery(query.Get("start"), 0)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid start", "invalid_input")
+		return
+	}
+	limit, err := parseQuery(query.Get("limit"), DefaultPageSize)
+	if err != nil || limit <= 0 {
+		writeError(w, http.StatusBadRequest, "invalid limit", "invalid_input")
+		return
+	}
+	if limit > MaxPageSize {
+		limit = MaxPageSize
+	}
+	ledger := s.LedgerVersion()
+	if start > ledger {
+		writeError(w, http.StatusNotFound, fmt.Sprintf("Ledger version(%d) not found, latest ledger version is %d", start, ledger), "version_not_found")
+		return
+	}
+	txs := []json.RawMessage{}
+	for version := start; version < start+limit && version <= ledger; version++ {
+		txs = append(txs, s.transaction(version))
+	}
+	s.writeJSON(w, txs)
+}
+
+func (s *Server) handleTransactionByVersion(w http.ResponseWriter, r *http.Request) {
+	version, err := strconv.ParseInt(strings.TrimPrefix(r.URL.Path, "/v1/transactions/by_version/"), 10, 64)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid version", "invalid_input")
+		return
+	}
+	if ledger := s.LedgerVersion(); version < 0 || version > ledger {
+		writeError(w, http.StatusNotFound, fmt.Sprintf("Transaction not found by Transaction version(%d)", version), "transaction_not_found")
+		return
+	}
+	s.writeJSON(w, s.transaction(version))
+}
+
+func (s *Server) transaction(version int64) json.RawMessage {
+	s.mu.RLock()
+	tx, ok := s.transactions[version]
+	s.mu.RUnlock()
+	if ok {
+		return tx
+	}
+	checkpoint, _ := json.Marshal(map[string]interface{}{
+		"type":                  "state_checkpoint_transaction",
+		"version":               strconv.FormatInt(version, 10),
+		"hash":                  fmt.Sprintf("0x%064x", version),
+		"state_root_hash":       fmt.Sprintf("0x%064x", version),
+		"event_root_hash":       fmt.Sprintf("0x%064x", 0),
+		"gas_used":              "0",
+		"success":               true,
+		"vm_status":             "Executed successfully",
+		"accumulator_root_hash": fmt.Sprintf("0x%064x", version),
+		"changes":               []interface{}{},
+		"timestamp":             BlockTimestamp,
+	})
+	return checkpoint
+}
+
+//Versions Every version with a fixture, in order
+func (s *Server) Versions() []int64 {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	var versions []int64
+	for version := range s.transactions {
+		versions = append(versions, version)
+	}
+	sort.Slice(versions, func(i, j int) bool {
+		return versions[i] < versions[j]
+	})
+	return versions
+}
+
+func (s *Server) writeJSON(w http.ResponseWriter, body interface{}) {
+	ledger := s.LedgerVersion()
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Aptos-Chain-Id", strconv.Itoa(int(s.ChainId)))
+	w.Header().Set("X-Aptos-Ledger-Version", strconv.FormatInt(ledger, 10))
+	w.Header().Set("X-Aptos-Ledger-Oldest-Version", "0")
+	w.Header().Set("X-Aptos-Ledger-Timestampusec", BlockTimestamp)
+	w.Header().Set("X-Aptos-Epoch", "1")
+	w.Header().Set("X-Aptos-Block-Height", strconv.FormatInt(ledger, 10))
+	w.Header().Set("X-Aptos-Oldest-Block-Height", "0")
+	_ = json.NewEncoder(w).Encode(body)
+}
+
+func writeError(w http.ResponseWriter, statusCode int, message, errorCode string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(statusCode)
+	_ = json.NewEncoder(w).Encode(map[string]interface{}{
+		"message":    message,
+		"error_code": errorCode,
+	})
+}
+
+func parseQuery(value string, defaultValue int64) (int64, error) {
+	if value == "" {
+		return defaultValue, nil
+	}
+	return strconv.ParseInt(value, 10, 64)
+}
@@ -0,0 +1,74 @@
+package fakenode
+
+import (
+	"encoding/json"
+	aptos "github.com/portto/aptos-go-sdk/client"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func newTestServer(t *testing.T) *Server {
+	server, err := NewServer(4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(server.Close)
+	err = server.AddTransactions(
+		json.RawMessage(`{"type":"user_transaction","version":"3","hash":"0x3","success":true,"sender":"0x1","sequence_number":"0","timestamp":"1661300000000000","payload":{"type":"entry_function_payload","function":"0x1::coin::transfer","type_arguments":[],"arguments":[]},"events":[]}`),
+		json.RawMessage(`{"type":"user_transaction","version":"5","hash":"0x5","success":false,"sender":"0x1","sequence_number":"1","timestamp":"1661300001000000","payload":{"type":"entry_function_payload","function":"0x1::coin::transfer","type_arguments":[],"arguments":[]},"events":[]}`),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return server
+}
+
+func TestServer_CompatibleWithAptosClient(t *testing.T) {
+	server := newTestServer(t)
+	client := aptos.New(server.URL)
+
+	info, err := client.LedgerInformation()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.ChainID != 4 || info.LedgerVersion != "5" {
+		t.Fatalf("unexpected ledger info %+v", info)
+	}
+
+	txs, err := client.GetTransactions(2, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(txs) != 4 {
+		t.Fatalf("got %d transactions, want 4", len(txs))
+	}
+	if txs[0].Type != "state_checkpoint_transaction" || txs[1].Hash != "0x3" || txs[3].Success {
+		t.Fatalf("unexpected transactions %+v", txs)
+	}
+
+	tx, err := client.GetTransactionByVersion(3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tx.Payload.Function != "0x1::coin::transfer" {
+		t.Fatalf("unexpected transaction %+v", tx)
+	}
+
+	if _, err = client.GetTransactions(6, 10); err == nil || !strings.Contains(err.Error(), "response(404)") {
+		t.Fatalf("got %v, want a 404 past the ledger version", err)
+	}
+}
+
+func TestServer_FailNext(t *testing.T) {
+	server := newTestServer(t)
+	client := aptos.New(server.URL)
+	server.FailNext(http.StatusServiceUnavailable)
+
+	if _, err := client.LedgerInformation(); err == nil || !strings.Contains(err.Error(), "response(503)") {
+		t.Fatalf("got %v, want an injected 503", err)
+	}
+	if _, err := client.LedgerInformation(); err != nil {
+		t.Fatal(err)
+	}
+}
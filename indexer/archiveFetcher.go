@@ -0,0 +1,274 @@
+package indexer
+
+import (
+	"apotscan/types"
+	"bufio"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"sync"
+)
+
+const (
+	//ArchiveLedgerInfoFile Ledger info of the chain an archive was recorded from, it tells the chain id
+	ArchiveLedgerInfoFile = "ledger_info.json"
+	archiveExtension      = ".ndjson"
+	archiveGzipExtension  = ".ndjson.gz"
+)
+
+//archiveFileName `<start>-<end>.ndjson`, zero padded so that files list in version order
+var archiveFileName = regexp.MustCompile(`^(\d+)-(\d+)\.ndjson(\.gz)?$`)
+
+type archiveFile struct {
+	path       string
+	start, end int64
+	gzip       bool
+}
+
+func (f archiveFile) open() (*archiveReader, error) {
+	file, err := os.Open(f.path)
+	if err != nil {
+		return nil, err
+	}
+	reader := &archiveReader{file: file}
+	var r io.Reader = file
+	if f.gzip {
+		if reader.gzip, err = gzip.NewReader(file); err != nil {
+			_ = file.Close()
+			return nil, err
+		}
+		r = reader.gzip
+	}
+	reader.decoder = json.NewDecoder(bufio.NewReader(r))
+	return reader, nil
+}
+
+type archiveReader struct {
+	file    *os.File
+	gzip    *gzip.Reader
+	decoder *json.Decoder
+}
+
+func (r *archiveReader) next() (*types.Transaction, error) {
+	var tx types.Transaction
+	if err := r.decoder.Decode(&tx); err != nil {
+		return nil, err
+	}
+	return &tx, nil
+}
+
+func (r *archiveReader) Close() error {
+	if r.gzip != nil {
+		_ = r.gzip.Close()
+	}
+	return r.file.Close()
+}
+
+//ArchiveFetcher A `TransactionFetcher` reading transactions from a directory of newline delimited json files,
+//as written by `Recorder`, so processors can run on a frozen dataset without network access
+type ArchiveFetcher struct {
+	Dir             string
+	StartingVersion int64
+	CurrentVersion  int64
+
+	ledgerInfo types.LedgerInfo
+	files      []archiveFile
+
+	//mu Guards `CurrentVersion` and the reader, the batch cache fetches windows ahead while the head is being read
+	mu sync.Mutex
+	//reader Sequential reads of `FetchNextBatch` keep the current file open
+	reader     *archiveReader
+	readerFile int
+	readerNext int64
+}
+
+func NewArchiveFetcher(dir string) (*ArchiveFetcher, error) {
+	f := &ArchiveFetcher{
+		Dir:             dir,
+		StartingVersion: math.MaxInt64,
+		readerFile:      -1,
+	}
+	data, err := os.ReadFile(filepath.Join(dir, ArchiveLedgerInfoFile))
+	if err != nil {
+		return nil, fmt.Errorf("archive %s has no %s: %v", dir, ArchiveLedgerInfoFile, err)
+	}
+	if err = json.Unmarshal(data, &f.ledgerInfo); err != nil {
+		return nil, err
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	for _, entry := range entries {
+		match := archiveFileName.FindStringSubmatch(entry.Name())
+		if entry.IsDir() || match == nil {
+			continue
+		}
+		start, _ := strconv.ParseInt(match[1], 10, 64)
+		end, _ := strconv.ParseInt(match[2], 10, 64)
+		f.files = append(f.files, archiveFile{
+			path:  filepath.Join(dir, entry.Name()),
+			start: start,
+			end:   end,
+			gzip:  match[3] != "",
+		})
+	}
+	sort.Slice(f.files, func(i, j int) bool {
+		return f.files[i].start < f.files[j].start
+	})
+	return f, nil
+}
+
+//HighestVersion The last version in the archive, -1 if it is empty
+func (f *ArchiveFetcher) HighestVersion() int64 {
+	highest := int64(-1)
+	for _, file := range f.files {
+		if file.end > highest {
+			highest = file.end
+		}
+	}
+	return highest
+}
+
+//GetHighestKnownVersion Same as `HighestVersion`, nothing is ever added to an archive being read
+func (f *ArchiveFetcher) GetHighestKnownVersion() int64 {
+	return f.HighestVersion()
+}
+
+//fileOf Index of the first file holding `version`, -1 if no file does
+func (f *ArchiveFetcher) fileOf(version int64) int {
+	for i, file := range f.files {
+		if file.start <= version && version <= file.end {
+			return i
+		}
+	}
+	return -1
+}
+
+//FetchNextBatch Reads up to `batch` transactions from the current version, an empty batch means the end of the archive.
+//A version missing from the archive is an error rather than skipped, processors would otherwise mark it processed
+func (f *ArchiveFetcher) FetchNextBatch(ctx context.Context, batch int) ([]types.Transaction, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	var transactions []types.Transaction
+	for len(transactions) < batch && f.CurrentVersion <= f.HighestVersion() {
+		tx, err := f.read(f.CurrentVersion)
+		if err != nil {
+			return nil, err
+		}
+		transactions = append(transactions, *tx)
+		f.CurrentVersion++
+	}
+	return transactions, nil
+}
+
+//read Reads `version` through the sequential reader, reopening it when `version` isn't the next one in the open file.
+//`mu` must be held
+func (f *ArchiveFetcher) read(version int64) (*types.Transaction, error) {
+	if f.reader == nil || f.readerNext != version || version > f.files[f.readerFile].end {
+		f.closeReader()
+		i := f.fileOf(version)
+		if i < 0 {
+			return nil, fmt.Errorf("archive %s has no version %d", f.Dir, version)
+		}
+		reader, err := f.files[i].open()
+		if err != nil {
+			return nil, err
+		}
+		f.reader, f.readerFile, f.readerNext = reader, i, version
+	}
+	for {
+		tx, err := f.reader.next()
+		if err == io.EOF {
+			f.closeReader()
+			return nil, fmt.Errorf("archive file %s ends before version %d", f.files[f.readerFile].path, version)
+		} else if err != nil {
+			f.closeReader()
+			return nil, err
+		}
+		if tx.Version < version {
+			continue
+		}
+		if tx.Version > version {
+			f.closeReader()
+			return nil, fmt.Errorf("archive %s has no version %d", f.Dir, version)
+		}
+		f.readerNext = version + 1
+		return tx, nil
+	}
+}
+
+func (f *ArchiveFetcher) closeReader() {
+	if f.reader != nil {
+		_ = f.reader.Close()
+		f.reader = nil
+	}
+}
+
+//FetchTransactions Reads up to `limit` transactions starting from `start`, it doesn't touch the cursor
+func (f *ArchiveFetcher) FetchTransactions(ctx context.Context, start int64, limit int) ([]types.Transaction, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	var transactions []types.Transaction
+	for version := start; len(transactions) < limit && version <= f.HighestVersion(); version++ {
+		tx, err := f.read(version)
+		if err != nil {
+			return nil, err
+		}
+		transactions = append(transactions, *tx)
+	}
+	return transactions, nil
+}
+
+func (f *ArchiveFetcher) FetchVersion(ctx context.Context, version uint64) (*types.Transaction, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return f.read(int64(version))
+}
+
+//FetchLedgerInfo The recorded ledger info, with the ledger version moved to the last version in the archive
+func (f *ArchiveFetcher) FetchLedgerInfo(ctx context.Context) (*types.LedgerInfo, error) {
+	ledgerInfo := f.ledgerInfo
+	ledgerInfo.LedgerVersion = strconv.FormatInt(f.HighestVersion(), 10)
+	return &ledgerInfo, nil
+}
+
+func (f *ArchiveFetcher) SetVersion(version int64) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.StartingVersion != math.MaxInt64 {
+		panic("TransactionFetcher already started!")
+	}
+	f.StartingVersion = version
+	f.CurrentVersion = version
+}
+
+func (f *ArchiveFetcher) GetChainId() uint8 {
+	return uint8(f.ledgerInfo.ChainID)
+}
+
+func (f *ArchiveFetcher) Start(ctx context.Context) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.StartingVersion != math.MaxInt64 {
+		f.CurrentVersion = f.StartingVersion
+	}
+}
@@ -0,0 +1,195 @@
+package indexer
+
+import (
+	"apotscan/types"
+	"context"
+	"sync"
+	"testing"
+	"time"
+)
+
+func recordArchive(t *testing.T, dir string, gzip bool) {
+	client := &stubClient{ledgerVersion: 249, pageSize: 100}
+	fetcher := NewFetcher(NewNodePool(NewNode("stub", client)), 0)
+	fetcher.PollInterval = 50 * time.Millisecond
+	recorder, err := NewRecorder(fetcher, dir, gzip, 40)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	if _, err = recorder.FetchLedgerInfo(ctx); err != nil {
+		t.Fatal(err)
+	}
+	recorder.SetVersion(0)
+	recorder.Start(ctx)
+	for {
+		txs, err := recorder.FetchNextBatch(ctx, 30)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(txs) == 0 {
+			break
+		}
+	}
+	if err = recorder.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestArchiveFetcher_ReplaysRecording(t *testing.T) {
+	ctx := context.Background()
+	for _, gzip := range []bool{false, true} {
+		dir := t.TempDir()
+		recordArchive(t, dir, gzip)
+
+		archive, err := NewArchiveFetcher(dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if archive.GetChainId() != 4 {
+			t.Fatalf("got chain id %d, want 4", archive.GetChainId())
+		}
+		if len(archive.files) != 7 {
+			t.Fatalf("got %d files, want 7", len(archive.files))
+		}
+
+		archive.SetVersion(35)
+		archive.Start(ctx)
+		var txs []types.Transaction
+		for {
+			batch, err := archive.FetchNextBatch(ctx, 17)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(batch) == 0 {
+				break
+			}
+			txs = append(txs, batch...)
+		}
+		if len(txs) != 215 {
+			t.Fatalf("got %d transactions, want 215", len(txs))
+		}
+		for i, tx := range txs {
+			if tx.Version != int64(35+i) || !tx.Success {
+				t.Fatalf("got %+v at %d", tx, i)
+			}
+		}
+
+		tx, err := archive.FetchVersion(ctx, 81)
+		if err != nil || tx.Version != 81 {
+			t.Fatalf("got %v, %v", tx, err)
+		}
+		if _, err = archive.FetchVersion(ctx, 250); err == nil {
+			t.Fatal("expected an error past the end of the archive")
+		}
+	}
+}
+
+func TestArchiveFetcher_ConcurrentReads(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	recordArchive(t, dir, true)
+	archive, err := NewArchiveFetcher(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	archive.SetVersion(0)
+	archive.Start(ctx)
+
+	// windows fetched ahead by the batch cache while the head is read
+	var wg sync.WaitGroup
+	for _, start := range []int64{100, 150, 200} {
+		wg.Add(1)
+		go func(start int64) {
+			defer wg.Done()
+			txs, err := archive.FetchTransactions(ctx, start, 50)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			for i, tx := range txs {
+				if tx.Version != start+int64(i) {
+					t.Errorf("got version %d at %d of the window from %d", tx.Version, i, start)
+					return
+				}
+			}
+		}(start)
+	}
+	var head int64
+	for {
+		txs, err := archive.FetchNextBatch(ctx, 13)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(txs) == 0 {
+			break
+		}
+		for _, tx := range txs {
+			if tx.Version != head {
+				t.Fatalf("got version %d, want %d", tx.Version, head)
+			}
+			head++
+		}
+	}
+	wg.Wait()
+	if head != 250 {
+		t.Fatalf("read up to %d, want 250", head)
+	}
+}
+
+func TestRecorder_RecordsVersionsBehindTheHead(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	dir := t.TempDir()
+	fetcher := NewFetcher(NewNodePool(NewNode("stub", &stubClient{ledgerVersion: 149, pageSize: 100})), 0)
+	recorder, err := NewRecorder(fetcher, dir, true, 40)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = recorder.FetchLedgerInfo(ctx); err != nil {
+		t.Fatal(err)
+	}
+	recorder.SetVersion(100)
+	// a processor behind the head fetches windows concurrently while the head is read
+	var wg sync.WaitGroup
+	for _, start := range []int64{0, 50} {
+		wg.Add(1)
+		go func(start int64) {
+			defer wg.Done()
+			if _, err := recorder.FetchTransactions(ctx, start, 50); err != nil {
+				t.Error(err)
+			}
+		}(start)
+	}
+	for {
+		txs, err := recorder.FetchNextBatch(ctx, 30)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(txs) == 0 {
+			break
+		}
+	}
+	wg.Wait()
+	if err = recorder.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	archive, err := NewArchiveFetcher(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	txs, err := archive.FetchTransactions(ctx, 0, 150)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(txs) != 150 {
+		t.Fatalf("got %d transactions, want every version from genesis", len(txs))
+	}
+	for i, tx := range txs {
+		if tx.Version != int64(i) {
+			t.Fatalf("got version %d at %d", tx.Version, i)
+		}
+	}
+}
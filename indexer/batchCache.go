@@ -0,0 +1,210 @@
+package indexer
+
+import (
+	"apotscan/types"
+	"context"
+	"fmt"
+	"sync"
+)
+
+const (
+	DefaultBatchCacheSize     = 10000
+	DefaultBatchCachePrefetch = 4
+)
+
+//BatchCache Shares fetched transactions between processors which each advance on their own cursor.
+//The head, every version from the fetcher's starting version on, is read in order through `FetchNextBatch`,
+//so the fetcher's prefetching workers and a recorder keep working. Processors behind the head fetch what they miss
+//directly, in windows aligned to `WindowSize` so processors catching up together share them
+type BatchCache struct {
+	fetcher TransactionFetcher
+	//Size How many transactions are kept, the oldest fetched are dropped first
+	Size int
+	//WindowSize How many versions are fetched at once for processors behind the head
+	WindowSize int
+	//Prefetch How many windows after the one asked for are fetched in the background for processors behind the head
+	Prefetch int
+
+	headMu sync.Mutex
+	mu     sync.Mutex
+	//head The next version `FetchNextBatch` returns
+	head     int64
+	segments []segment
+	cached   int
+	inflight map[int64]*windowCall
+}
+
+type segment struct {
+	start int64
+	txs   []types.Transaction
+}
+
+//windowCall A window being fetched, every processor asking for it waits for the same call
+type windowCall struct {
+	done chan struct{}
+	txs  []types.Transaction
+	err  error
+}
+
+//NewBatchCache `head` is the version the fetcher has been set to start from
+func NewBatchCache(fetcher TransactionFetcher, head int64) *BatchCache {
+	return &BatchCache{
+		fetcher:    fetcher,
+		Size:       DefaultBatchCacheSize,
+		WindowSize: DefaultFetchWindowSize,
+		Prefetch:   DefaultBatchCachePrefetch,
+		head:       head,
+		inflight:   make(map[int64]*windowCall),
+	}
+}
+
+//Get Returns up to `limit` consecutive transactions from `start`, fewer if that's all there is in one piece.
+//An empty result means `start` is past the chain tip
+func (c *BatchCache) Get(ctx context.Context, start int64, limit int) ([]types.Transaction, error) {
+	if txs := c.lookup(start, limit); len(txs) > 0 {
+		return txs, nil
+	}
+	if start >= c.headVersion() {
+		return c.readHead(ctx, start, limit)
+	}
+	return c.fetchBehind(ctx, start, limit)
+}
+
+func (c *BatchCache) headVersion() int64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.head
+}
+
+//readHead Reads the next batch of the head, one processor at a time. Whoever waited for the lock may find
+//the versions it asked for already read by the processor before it
+func (c *BatchCache) readHead(ctx context.Context, start int64, limit int) ([]types.Transaction, error) {
+	c.headMu.Lock()
+	defer c.headMu.Unlock()
+	if txs := c.lookup(start, limit); len(txs) > 0 {
+		return txs, nil
+	}
+	head := c.headVersion()
+	if start < head {
+		return c.fetchBehind(ctx, start, limit)
+	}
+	if start > head {
+		return nil, fmt.Errorf("version %d is ahead of the next version fetched %d", start, head)
+	}
+	txs, err := c.fetcher.FetchNextBatch(ctx, limit)
+	if err != nil || len(txs) == 0 {
+		return nil, err
+	}
+	c.mu.Lock()
+	c.head += int64(len(txs))
+	c.insert(start, txs)
+	c.mu.Unlock()
+	return txs, nil
+}
+
+//fetchBehind Fetches the window holding `start` and starts prefetching the windows after it
+func (c *BatchCache) fetchBehind(ctx context.Context, start int64, limit int) ([]types.Transaction, error) {
+	window := start - start%int64(c.WindowSize)
+	txs, err := c.fetchWindow(ctx, window)
+	if err != nil {
+		return nil, err
+	}
+	for i := 1; i <= c.Prefetch; i++ {
+		next := window + int64(i*c.WindowSize)
+		if next >= c.headVersion() {
+			break
+		}
+		go c.fetchWindow(ctx, next)
+	}
+	if offset := start - window; offset < int64(len(txs)) {
+		txs = txs[offset:]
+	} else {
+		return nil, fmt.Errorf("no transactions returned from version %d", start)
+	}
+	if len(txs) > limit {
+		txs = txs[:limit]
+	}
+	return txs, nil
+}
+
+//fetchWindow Fetches the versions from `window` up to the next window or the head, whichever comes first.
+//The node may return less than asked for, it keeps going until the window is full
+func (c *BatchCache) fetchWindow(ctx context.Context, window int64) ([]types.Transaction, error) {
+	c.mu.Lock()
+	end := window + int64(c.WindowSize)
+	if end > c.head {
+		end = c.head
+	}
+	if txs := c.lookupLocked(window, c.WindowSize); int64(len(txs)) >= end-window {
+		c.mu.Unlock()
+		return txs, nil
+	}
+	if call, ok := c.inflight[window]; ok {
+		c.mu.Unlock()
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-call.done:
+			return call.txs, call.err
+		}
+	}
+	call := &windowCall{done: make(chan struct{})}
+	c.inflight[window] = call
+	c.mu.Unlock()
+
+	var txs []types.Transaction
+	for version := window; version < end; {
+		fetched, err := c.fetcher.FetchTransactions(ctx, version, int(end-version))
+		if err != nil {
+			call.err = err
+			break
+		}
+		if len(fetched) == 0 {
+			break
+		}
+		txs = append(txs, fetched...)
+		version += int64(len(fetched))
+	}
+	call.txs = txs
+
+	c.mu.Lock()
+	delete(c.inflight, window)
+	if call.err == nil && len(txs) > 0 {
+		c.insert(window, txs)
+	}
+	c.mu.Unlock()
+	close(call.done)
+	return call.txs, call.err
+}
+
+func (c *BatchCache) lookup(start int64, limit int) []types.Transaction {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.lookupLocked(start, limit)
+}
+
+//lookupLocked Finds the cached transactions from `start`, `mu` has to be locked
+func (c *BatchCache) lookupLocked(start int64, limit int) []types.Transaction {
+	for i := len(c.segments) - 1; i >= 0; i-- {
+		s := c.segments[i]
+		if start < s.start || start >= s.start+int64(len(s.txs)) {
+			continue
+		}
+		txs := s.txs[start-s.start:]
+		if len(txs) > limit {
+			txs = txs[:limit]
+		}
+		return txs
+	}
+	return nil
+}
+
+//insert Caches `txs`, dropping the oldest segments beyond `Size`. `mu` has to be locked
+func (c *BatchCache) insert(start int64, txs []types.Transaction) {
+	c.segments = append(c.segments, segment{start: start, txs: txs})
+	c.cached += len(txs)
+	for len(c.segments) > 1 && c.cached > c.Size {
+		c.cached -= len(c.segments[0].txs)
+		c.segments = c.segments[1:]
+	}
+}
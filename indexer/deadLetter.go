@@ -0,0 +1,187 @@
+package indexer
+
+import (
+	"apotscan/metrics"
+	"apotscan/types"
+	"context"
+	"encoding/json"
+	"errors"
+	log "github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"sort"
+)
+
+//invokeBatch Calls the processor on the whole batch. With `DeadLetter` set a failed batch is rolled back to a savepoint
+//and called again one transaction at a time, transactions which still fail are dead-lettered and the batch succeeds.
+//An `OrderedProcessor` is called one transaction at a time in version order right away, so a dead-lettered transaction
+//is skipped before any later version is applied. Nothing is dead-lettered once `ctx` is done
+func (p *Processor) invokeBatch(ctx context.Context, db *gorm.DB, txns []types.Transaction, startVersion, endVersion int64) (*types.ProcessResult, error) {
+	if !p.DeadLetter {
+		return p.invoke(ctx, db, txns, startVersion, endVersion)
+	}
+	if p.ordered() {
+		return p.invokeEach(ctx, db, sortedByVersion(txns), startVersion, endVersion)
+	}
+	var result *types.ProcessResult
+	err := db.Transaction(func(db *gorm.DB) (err error) {
+		result, err = p.invoke(ctx, db, txns, startVersion, endVersion)
+		return err
+	})
+	if err == nil || ctx.Err() != nil {
+		return result, err
+	}
+	p.GetLogger().WithFields(log.Fields{
+		"name":          p.Name(),
+		"start version": startVersion,
+		"end version":   endVersion,
+		"error":         err,
+	}).Warn("Batch failed, processing its transactions one at a time")
+	return p.invokeEach(ctx, db, txns, startVersion, endVersion)
+}
+
+//invokeEach Calls the processor once per transaction, each in its own savepoint, and dead-letters the ones which fail
+func (p *Processor) invokeEach(ctx context.Context, db *gorm.DB, txns []types.Transaction, startVersion, endVersion int64) (*types.ProcessResult, error) {
+	for _, tx := range txns {
+		err := db.Transaction(func(db *gorm.DB) error {
+			_, err := p.invoke(ctx, db, []types.Transaction{tx}, tx.Version, tx.Version)
+			return err
+		})
+		if err == nil {
+			continue
+		}
+		if ctx.Err() != nil {
+			return nil, err
+		}
+		if err = p.deadLetter(db, tx, err); err != nil {
+			return nil, err
+		}
+	}
+	return types.NewProcessResult(p.Name(), startVersion, endVersion), nil
+}
+
+//sortedByVersion A copy of `txns` in version order
+func sortedByVersion(txns []types.Transaction) []types.Transaction {
+	sorted := append([]types.Transaction(nil), txns...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].Version < sorted[j].Version
+	})
+	return sorted
+}
+
+//deadLetter Writes a failed transaction to `dead_letters` through `db`, a transaction failing again counts another attempt
+func (p *Processor) deadLetter(db *gorm.DB, tx types.Transaction, err error) error {
+	data, marshalErr := json.Marshal(tx)
+	if marshalErr != nil {
+		return marshalErr
+	}
+	eventIndex := -1
+	var eventErr *types.EventError
+	if errors.As(err, &eventErr) {
+		eventIndex = eventErr.Index
+	}
+	p.GetLogger().WithFields(log.Fields{
+		"name":        p.Name(),
+		"version":     tx.Version,
+		"event index": eventIndex,
+		"error":       err,
+	}).Error("Dead-lettering transaction")
+	metrics.DeadLetters.WithLabelValues(p.Name()).Inc()
+	return db.Clauses(clause.OnConflict{
+		Columns: []clause.Column{{Name: "processor"}, {Name: "version"}},
+		DoUpdates: append(clause.AssignmentColumns([]string{"event_index", "transaction", "error", "updated_at"}),
+			clause.Assignment{Column: clause.Column{Name: "attempts"}, Value: gorm.Expr("dead_letters.attempts + 1")}),
+	}).Create(&types.DeadLetter{
+		Processor:   p.Name(),
+		Version:     tx.Version,
+		EventIndex:  eventIndex,
+		Transaction: string(data),
+		Error:       err.Error(),
+		Attempts:    1,
+	}).Error
+}
+
+//DeadLetterRetry The outcome of retrying a processor's dead-lettered transactions
+type DeadLetterRetry struct {
+	Name string
+	//Retried Transactions which have been processed and removed from `dead_letters`
+	Retried int
+	//Failed Transactions which failed again and stay dead-lettered
+	Failed int
+	//Skipped Transactions left dead-lettered because the processor applies versions in order, retrying them
+	//after later versions would apply none of their changes, reprocess from the first dead-lettered version instead
+	Skipped int
+}
+
+//RetryDeadLetters Runs every dead-lettered transaction through its processor again, in version order.
+//Only processors named `name` are retried, every processor when it is empty.
+//A transaction which succeeds is removed in the same database transaction as its writes
+func (t *Tailor) RetryDeadLetters(ctx context.Context, name string) ([]DeadLetterRetry, error) {
+	var retries []DeadLetterRetry
+	for _, processor := range t.processors {
+		if name != "" && processor.Name() != name {
+			continue
+		}
+		retry, err := processor.retryDeadLetters(ctx)
+		if err != nil {
+			return retries, err
+		}
+		retries = append(retries, *retry)
+	}
+	return retries, nil
+}
+
+func (p *Processor) retryDeadLetters(ctx context.Context) (*DeadLetterRetry, error) {
+	var deadLetters []types.DeadLetter
+	if err := p.GetDB().WithContext(ctx).
+		Where("processor = ?", p.Name()).
+		Order("version").
+		Find(&deadLetters).Error; err != nil {
+		return nil, err
+	}
+	retry := &DeadLetterRetry{Name: p.Name()}
+	if p.ordered() {
+		if retry.Skipped = len(deadLetters); retry.Skipped > 0 {
+			p.GetLogger().WithFields(log.Fields{
+				"name":          p.Name(),
+				"dead letters":  retry.Skipped,
+				"first version": deadLetters[0].Version,
+			}).Warn("Processor applies versions in order, reprocess from the first dead-lettered version instead of retrying")
+		}
+		return retry, nil
+	}
+	for _, deadLetter := range deadLetters {
+		var tx types.Transaction
+		if err := json.Unmarshal([]byte(deadLetter.Transaction), &tx); err != nil {
+			return retry, err
+		}
+		callCtx, cancel := p.callContext(ctx)
+		err := p.GetDB().WithContext(callCtx).Transaction(func(db *gorm.DB) error {
+			if _, err := p.invoke(callCtx, db, []types.Transaction{tx}, tx.Version, tx.Version); err != nil {
+				return err
+			}
+			return db.Delete(&deadLetter).Error
+		})
+		cancel()
+		if err == nil {
+			retry.Retried++
+			continue
+		}
+		if ctx.Err() != nil {
+			return retry, err
+		}
+		retry.Failed++
+		p.GetLogger().WithFields(log.Fields{
+			"name":    p.Name(),
+			"version": tx.Version,
+			"error":   err,
+		}).Error("Dead-lettered transaction failed again")
+		if err = p.GetDB().WithContext(ctx).Model(&deadLetter).Updates(map[string]interface{}{
+			"error":    err.Error(),
+			"attempts": gorm.Expr("attempts + 1"),
+		}).Error; err != nil {
+			return retry, err
+		}
+	}
+	return retry, nil
+}
@@ -0,0 +1,151 @@
+package indexer
+
+import (
+	"apotscan/logger"
+	"apotscan/types"
+	"context"
+	"encoding/json"
+	"errors"
+	log "github.com/sirupsen/logrus"
+	"path/filepath"
+	"testing"
+)
+
+func TestProcessor_DeadLettersFailedTransactions(t *testing.T) {
+	ctx := context.Background()
+	db := newTestDB(t)
+	logConf := &logger.Config{Level: log.FatalLevel, FilePath: filepath.Join(t.TempDir(), "aptoscan")}
+	_logger, err := logger.New(logConf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fixed := false
+	picky := &recordingProcessor{name: "picky", db: db, logger: _logger, before: func(ctx context.Context, txs []types.Transaction) error {
+		for _, tx := range txs {
+			if tx.Version == 1 && !fixed {
+				return &types.EventError{Version: 1, Index: 2, Key: "0x1", Err: errors.New("malformed")}
+			}
+		}
+		return nil
+	}}
+	tailor := NewTailor(NewFetcher(newTestNodePool(), 0), db, logConf, nil)
+	tailor.AddProcessor(&Processor{TransactionProcessor: picky, DeadLetter: true})
+
+	results := tailor.ProcessTransactions(ctx, []types.Transaction{
+		{Version: 0, Success: true}, {Version: 1, Success: true}, {Version: 2, Success: true},
+	})
+	if len(results) != 1 || results[0].Err() != nil {
+		t.Fatalf("got %+v, want the batch to succeed", results)
+	}
+	if len(picky.versions) != 2 || picky.versions[0] != 0 || picky.versions[1] != 2 {
+		t.Fatalf("got versions %v processed, want 0 and 2", picky.versions)
+	}
+	var status types.ProcessorStatus
+	if err = db.Where("name = ?", "picky").First(&status).Error; err != nil {
+		t.Fatal(err)
+	}
+	if !status.Success || status.StartVersion != 0 || status.EndVersion != 2 {
+		t.Fatalf("got status %+v, want 0-2 to succeed", status)
+	}
+	deadLetter := func() *types.DeadLetter {
+		var deadLetters []types.DeadLetter
+		if err := db.Find(&deadLetters).Error; err != nil {
+			t.Fatal(err)
+		}
+		if len(deadLetters) == 0 {
+			return nil
+		}
+		return &deadLetters[0]
+	}
+	letter := deadLetter()
+	if letter == nil || letter.Processor != "picky" || letter.Version != 1 || letter.EventIndex != 2 || letter.Attempts != 1 {
+		t.Fatalf("got dead letter %+v, want version 1 event 2", letter)
+	}
+	var tx types.Transaction
+	if err = json.Unmarshal([]byte(letter.Transaction), &tx); err != nil || tx.Version != 1 {
+		t.Fatalf("got transaction %s, want version 1: %v", letter.Transaction, err)
+	}
+
+	retries, err := tailor.RetryDeadLetters(ctx, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(retries) != 1 || retries[0].Retried != 0 || retries[0].Failed != 1 {
+		t.Fatalf("got %+v retrying before the fix", retries)
+	}
+	if letter = deadLetter(); letter == nil || letter.Attempts != 2 {
+		t.Fatalf("got dead letter %+v, want a second attempt", letter)
+	}
+
+	fixed = true
+	if retries, err = tailor.RetryDeadLetters(ctx, "picky"); err != nil {
+		t.Fatal(err)
+	}
+	if len(retries) != 1 || retries[0].Retried != 1 || retries[0].Failed != 0 {
+		t.Fatalf("got %+v retrying after the fix", retries)
+	}
+	if letter = deadLetter(); letter != nil {
+		t.Fatalf("got dead letter %+v left after a successful retry", letter)
+	}
+	if picky.versions[len(picky.versions)-1] != 1 {
+		t.Fatalf("got versions %v, want 1 retried last", picky.versions)
+	}
+}
+
+func TestProcessor_DeadLettersOrderedProcessorsInline(t *testing.T) {
+	ctx := context.Background()
+	db := newTestDB(t)
+	logConf := &logger.Config{Level: log.FatalLevel, FilePath: filepath.Join(t.TempDir(), "aptoscan")}
+	_logger, err := logger.New(logConf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var calls [][]int64
+	ordered := &orderedProcessor{recordingProcessor: &recordingProcessor{name: "ordered", db: db, logger: _logger, before: func(ctx context.Context, txs []types.Transaction) error {
+		var versions []int64
+		for _, tx := range txs {
+			versions = append(versions, tx.Version)
+		}
+		calls = append(calls, versions)
+		for _, tx := range txs {
+			if tx.Version == 1 {
+				return &types.EventError{Version: 1, Index: 0, Key: "0x1", Err: errors.New("malformed")}
+			}
+		}
+		return nil
+	}}}
+	tailor := NewTailor(NewFetcher(newTestNodePool(), 0), db, logConf, nil)
+	tailor.AddProcessor(&Processor{TransactionProcessor: ordered, DeadLetter: true})
+
+	results := tailor.ProcessTransactions(ctx, []types.Transaction{
+		{Version: 2, Success: true}, {Version: 0, Success: true}, {Version: 1, Success: true},
+	})
+	if len(results) != 1 || results[0].Err() != nil {
+		t.Fatalf("got %+v, want the batch to succeed past the poison transaction", results)
+	}
+	if len(calls) != 3 || calls[0][0] != 0 || calls[1][0] != 1 || calls[2][0] != 2 {
+		t.Fatalf("got calls %v, want one call per transaction in version order", calls)
+	}
+	if len(ordered.versions) != 2 || ordered.versions[0] != 0 || ordered.versions[1] != 2 {
+		t.Fatalf("got versions %v processed, want 0 and 2", ordered.versions)
+	}
+	var deadLetters []types.DeadLetter
+	if err = db.Find(&deadLetters).Error; err != nil {
+		t.Fatal(err)
+	}
+	if len(deadLetters) != 1 || deadLetters[0].Processor != "ordered" || deadLetters[0].Version != 1 || deadLetters[0].EventIndex != 0 {
+		t.Fatalf("got dead letters %+v, want version 1 event 0", deadLetters)
+	}
+
+	retries, err := tailor.RetryDeadLetters(ctx, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(retries) != 1 || retries[0].Retried != 0 || retries[0].Skipped != 1 {
+		t.Fatalf("got %+v, want the dead letter skipped", retries)
+	}
+	var count int64
+	if err = db.Model(&types.DeadLetter{}).Count(&count).Error; err != nil || count != 1 {
+		t.Fatalf("got %d dead letters, want it kept: %v", count, err)
+	}
+}
@@ -1,35 +1,67 @@
 package indexer
 
 import (
+	"apotscan/metrics"
 	"apotscan/types"
+	"context"
 	aptos "github.com/portto/aptos-go-sdk/client"
 	"math"
 	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	DefaultFetchWorkers      = 5
+	DefaultFetchWindowSize   = 100
+	DefaultFetchBufferSize   = 10
+	DefaultFetchPollInterval = time.Second
 )
 
 type Fetcher struct {
-	Client              aptos.API
+	Nodes               *NodePool
 	ChainId             uint8
 	StartingVersion     int64
 	CurrentVersion      int64
 	HighestKnownVersion int64
-	//todo:
-	//TransactionsSenders []
+
+	//Workers How many windows are fetched from the node concurrently once started
+	Workers int
+	//WindowSize How many versions a single worker fetches at once
+	WindowSize int
+	//BufferSize How many fetched windows may wait for the consumer before the workers stop
+	BufferSize int
+	//PollInterval How long the workers wait for new versions at the chain tip, or after an error
+	PollInterval time.Duration
+
+	//mu Guards `HighestKnownVersion` and `ChainId`, which the dispatching goroutine updates
+	mu sync.RWMutex
+	//consumer Serializes the consumer's calls, it guards `started`, `pending` and `CurrentVersion`
+	consumer sync.Mutex
+	started  bool
+	batches  chan fetchedWindow
+	pending  []types.Transaction
 }
 
-func NewFetcher(client aptos.API, currentVersion int64) *Fetcher {
-	//todo:
-	//TransactionsSenders []
+func NewFetcher(nodes *NodePool, currentVersion int64) *Fetcher {
 	return &Fetcher{
-		Client:              client,
+		Nodes:               nodes,
 		CurrentVersion:      currentVersion,
 		HighestKnownVersion: currentVersion,
-		StartingVersion:     math.MaxUint64,
+		StartingVersion:     math.MaxInt64,
+		Workers:             DefaultFetchWorkers,
+		WindowSize:          DefaultFetchWindowSize,
+		BufferSize:          DefaultFetchBufferSize,
+		PollInterval:        DefaultFetchPollInterval,
 	}
 }
 
-func (f *Fetcher) setHighestKnownVersion() error {
-	res, err := f.Client.LedgerInformation()
+func (f *Fetcher) setHighestKnownVersion(ctx context.Context) error {
+	var res *aptos.LedgerInfo
+	err := f.Nodes.Do(ctx, func(client aptos.API) (err error) {
+		res, err = client.LedgerInformation()
+		return err
+	})
 	if err != nil {
 		return err
 	}
@@ -37,16 +69,91 @@ func (f *Fetcher) setHighestKnownVersion() error {
 	if err != nil {
 		return err
 	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
 	f.HighestKnownVersion = int64(version)
 	f.ChainId = uint8(res.ChainID)
+	metrics.SetLedgerVersion(f.HighestKnownVersion)
 	return nil
 }
 
-func (f *Fetcher) FetchNextBatch(batch int) ([]types.Transaction, error) {
-	txs, err := f.Client.GetTransactions(int(f.CurrentVersion), batch)
+//GetHighestKnownVersion The latest ledger version the fetcher has seen on the node
+func (f *Fetcher) GetHighestKnownVersion() int64 {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	return f.HighestKnownVersion
+}
+
+//FetchNextBatch Fetches up to `batch` transactions from the current version and moves the cursor past them.
+//An empty batch means the fetcher has caught up with the chain tip.
+//Once started, batches are taken from the prefetching workers instead of going to the node
+func (f *Fetcher) FetchNextBatch(ctx context.Context, batch int) ([]types.Transaction, error) {
+	f.consumer.Lock()
+	defer f.consumer.Unlock()
+	if f.started {
+		return f.receiveNextBatch(ctx, batch)
+	}
+	if f.CurrentVersion > f.GetHighestKnownVersion() {
+		if err := f.setHighestKnownVersion(ctx); err != nil {
+			return nil, err
+		}
+		if f.CurrentVersion > f.GetHighestKnownVersion() {
+			return nil, nil
+		}
+	}
+	if remaining := f.GetHighestKnownVersion() - f.CurrentVersion + 1; remaining < int64(batch) {
+		batch = int(remaining)
+	}
+	transactions, err := f.FetchTransactions(ctx, f.CurrentVersion, batch)
 	if err != nil {
 		return nil, err
 	}
+	f.CurrentVersion += int64(len(transactions))
+	return transactions, nil
+}
+
+//receiveNextBatch Takes the next window from the workers, anything beyond `batch` is kept for the next call.
+//It gives up with an empty batch if no window arrives within `PollInterval`
+func (f *Fetcher) receiveNextBatch(ctx context.Context, batch int) ([]types.Transaction, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	if len(f.pending) == 0 {
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case window := <-f.batches:
+			if window.err != nil {
+				return nil, window.err
+			}
+			f.pending = window.txs
+		case <-time.After(f.PollInterval):
+			// nothing fetched for a whole poll interval, the workers are waiting at the chain tip
+			return nil, nil
+		}
+	}
+	if batch > len(f.pending) {
+		batch = len(f.pending)
+	}
+	transactions := f.pending[:batch]
+	f.pending = f.pending[batch:]
+	f.CurrentVersion += int64(len(transactions))
+	return transactions, nil
+}
+
+//FetchTransactions Fetches up to `limit` transactions starting from `start`, it doesn't touch the cursor
+func (f *Fetcher) FetchTransactions(ctx context.Context, start int64, limit int) ([]types.Transaction, error) {
+	var txs []aptos.TransactionResp
+	started := time.Now()
+	err := f.Nodes.Do(ctx, func(client aptos.API) (err error) {
+		txs, err = client.GetTransactions(int(start), limit)
+		return err
+	})
+	metrics.FetchDuration.Observe(time.Since(started).Seconds())
+	if err != nil {
+		metrics.FetchErrors.Inc()
+		return nil, err
+	}
 	var transactions []types.Transaction
 	for _, tx := range txs {
 		transaction := new(types.Transaction)
@@ -58,8 +165,12 @@ func (f *Fetcher) FetchNextBatch(batch int) ([]types.Transaction, error) {
 	return transactions, nil
 }
 
-func (f *Fetcher) FetchVersion(v uint64) (*types.Transaction, error) {
-	tx, err := f.Client.GetTransactionByVersion(v)
+func (f *Fetcher) FetchVersion(ctx context.Context, v uint64) (*types.Transaction, error) {
+	var tx *aptos.TransactionResp
+	err := f.Nodes.Do(ctx, func(client aptos.API) (err error) {
+		tx, err = client.GetTransactionByVersion(v)
+		return err
+	})
 	if err != nil {
 		return nil, err
 	}
@@ -70,8 +181,12 @@ func (f *Fetcher) FetchVersion(v uint64) (*types.Transaction, error) {
 	return transaction, nil
 }
 
-func (f *Fetcher) FetchLedgerInfo() (*types.LedgerInfo, error) {
-	info, err := f.Client.LedgerInformation()
+func (f *Fetcher) FetchLedgerInfo(ctx context.Context) (*types.LedgerInfo, error) {
+	var info *aptos.LedgerInfo
+	err := f.Nodes.Do(ctx, func(client aptos.API) (err error) {
+		info, err = client.LedgerInformation()
+		return err
+	})
 	if err != nil {
 		return nil, err
 	}
@@ -81,26 +196,182 @@ func (f *Fetcher) FetchLedgerInfo() (*types.LedgerInfo, error) {
 }
 
 func (f *Fetcher) SetVersion(version int64) {
+	f.consumer.Lock()
+	defer f.consumer.Unlock()
 	if f.StartingVersion != math.MaxInt64 {
 		panic("TransactionFetcher already started!")
 	}
 	f.StartingVersion = version
+	f.CurrentVersion = version
 }
 
 func (f *Fetcher) GetChainId() uint8 {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
 	return f.ChainId
 }
 
-//todo:
-func (f *Fetcher) Start() {
+//Start Moves the cursor to the starting version set by `SetVersion` and launches `Workers` goroutines
+//fetching consecutive windows ahead of the consumer. Windows are handed out in version order
+//through a channel of `BufferSize` windows, so fetching overlaps with processing. Every goroutine exits once `ctx` is done
+func (f *Fetcher) Start(ctx context.Context) {
+	f.consumer.Lock()
+	defer f.consumer.Unlock()
+	if f.started {
+		return
+	}
+	if f.StartingVersion != math.MaxInt64 {
+		f.CurrentVersion = f.StartingVersion
+	}
+	f.started = true
+	f.batches = make(chan fetchedWindow, f.BufferSize)
+
+	windows := make(chan fetchWindow)
+	results := make(chan fetchedWindow, f.Workers)
+	// every window in flight holds a slot until it has been handed to the consumer,
+	// this bounds how far ahead of the slowest window the workers can run
+	slots := make(chan struct{}, f.Workers+f.BufferSize)
+	for i := 0; i < f.Workers; i++ {
+		go f.fetchWindows(ctx, windows, results)
+	}
+	go f.dispatchWindows(ctx, f.CurrentVersion, windows, slots)
+	go f.reorderWindows(ctx, results, slots)
+}
+
+type fetchWindow struct {
+	seq   int64
+	start int64
+	limit int
+}
+
+type fetchedWindow struct {
+	fetchWindow
+	txs []types.Transaction
+	err error
+}
+
+//dispatchWindows Splits the versions from `next` up to the chain tip into consecutive windows,
+//waiting for new versions once it reaches the tip. Closing `windows` on exit stops the workers
+func (f *Fetcher) dispatchWindows(ctx context.Context, next int64, windows chan<- fetchWindow, slots chan<- struct{}) {
+	defer close(windows)
+	var seq int64
+	for {
+		highest := f.GetHighestKnownVersion()
+		if next > highest {
+			err := f.setHighestKnownVersion(ctx)
+			if err != nil && !f.send(ctx, f.batches, fetchedWindow{err: err}) {
+				return
+			}
+			if (err != nil || next > f.GetHighestKnownVersion()) && !sleep(ctx, f.PollInterval) {
+				return
+			}
+			continue
+		}
+		limit := f.WindowSize
+		if remaining := highest - next + 1; remaining < int64(limit) {
+			limit = int(remaining)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case slots <- struct{}{}:
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case windows <- fetchWindow{seq: seq, start: next, limit: limit}:
+		}
+		seq++
+		next += int64(limit)
+	}
+}
 
+//fetchWindows Fetches every version of a window, the node may return less than asked for so it keeps
+//going until the window is full. Errors are reported to the consumer and the window is retried
+func (f *Fetcher) fetchWindows(ctx context.Context, windows <-chan fetchWindow, results chan<- fetchedWindow) {
+	for window := range windows {
+		var txs []types.Transaction
+		for len(txs) < window.limit {
+			fetched, err := f.FetchTransactions(ctx, window.start+int64(len(txs)), window.limit-len(txs))
+			if ctx.Err() != nil {
+				return
+			}
+			if err != nil && !f.send(ctx, results, fetchedWindow{fetchWindow: window, err: err}) {
+				return
+			}
+			if (err != nil || len(fetched) == 0) && !sleep(ctx, f.PollInterval) {
+				return
+			}
+			txs = append(txs, fetched...)
+		}
+		if !f.send(ctx, results, fetchedWindow{fetchWindow: window, txs: txs}) {
+			return
+		}
+	}
+}
+
+//reorderWindows Holds back windows which finished early until every window before them has been handed out
+func (f *Fetcher) reorderWindows(ctx context.Context, results <-chan fetchedWindow, slots <-chan struct{}) {
+	var next int64
+	finished := make(map[int64]fetchedWindow)
+	for {
+		var result fetchedWindow
+		select {
+		case <-ctx.Done():
+			return
+		case result = <-results:
+		}
+		if result.err != nil {
+			if !f.send(ctx, f.batches, result) {
+				return
+			}
+			continue
+		}
+		finished[result.seq] = result
+		for {
+			window, ok := finished[next]
+			if !ok {
+				break
+			}
+			delete(finished, next)
+			if !f.send(ctx, f.batches, window) {
+				return
+			}
+			<-slots
+			next++
+		}
+	}
+}
+
+//send Sends `window` unless `ctx` is done first, it tells whether the window was sent
+func (f *Fetcher) send(ctx context.Context, ch chan<- fetchedWindow, window fetchedWindow) bool {
+	select {
+	case <-ctx.Done():
+		return false
+	case ch <- window:
+		return true
+	}
+}
+
+//sleep Waits for `d` unless `ctx` is done first, it tells whether the whole duration has passed
+func sleep(ctx context.Context, d time.Duration) bool {
+	select {
+	case <-ctx.Done():
+		return false
+	case <-time.After(d):
+		return true
+	}
 }
 
 type TransactionFetcher interface {
-	FetchNextBatch(batch int) ([]types.Transaction, error)
-	FetchVersion(version uint64) (*types.Transaction, error)
-	FetchLedgerInfo() (*types.LedgerInfo, error)
+	FetchNextBatch(ctx context.Context, batch int) ([]types.Transaction, error)
+	FetchTransactions(ctx context.Context, start int64, limit int) ([]types.Transaction, error)
+	FetchVersion(ctx context.Context, version uint64) (*types.Transaction, error)
+	FetchLedgerInfo(ctx context.Context) (*types.LedgerInfo, error)
 	SetVersion(version int64)
 	GetChainId() uint8
-	Start()
+	//GetHighestKnownVersion The latest ledger version the fetcher knows of without asking the node
+	GetHighestKnownVersion() int64
+	//Start Begins fetching from the version set by `SetVersion`, background work stops once `ctx` is done
+	Start(ctx context.Context)
 }
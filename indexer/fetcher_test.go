@@ -0,0 +1,190 @@
+package indexer
+
+import (
+	"apotscan/fakenode"
+	"apotscan/types"
+	"context"
+	"errors"
+	aptos "github.com/portto/aptos-go-sdk/client"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+//stubClient Serves versions 0 to `ledgerVersion` with random latency, the rest of `aptos.API` is left unimplemented
+type stubClient struct {
+	aptos.API
+	ledgerVersion int
+	pageSize      int
+
+	mu       sync.Mutex
+	failures int
+}
+
+func (c *stubClient) LedgerInformation(opts ...interface{}) (*aptos.LedgerInfo, error) {
+	return &aptos.LedgerInfo{ChainID: 4, LedgerVersion: strconv.Itoa(c.ledgerVersion)}, nil
+}
+
+func (c *stubClient) GetTransactions(start, limit int, opts ...interface{}) ([]aptos.TransactionResp, error) {
+	time.Sleep(time.Duration(rand.Intn(5)) * time.Millisecond)
+	c.mu.Lock()
+	if c.failures > 0 {
+		c.failures--
+		c.mu.Unlock()
+		return nil, errors.New("response(503): unavailable")
+	}
+	c.mu.Unlock()
+	if limit > c.pageSize {
+		limit = c.pageSize
+	}
+	var txs []aptos.TransactionResp
+	for v := start; v < start+limit && v <= c.ledgerVersion; v++ {
+		txs = append(txs, aptos.TransactionResp{Version: strconv.Itoa(v), Success: true})
+	}
+	return txs, nil
+}
+
+func TestFetcher_StartDeliversWindowsInOrder(t *testing.T) {
+	client := &stubClient{ledgerVersion: 999, pageSize: 7, failures: 3}
+	nodes := NewNodePool(NewNode("stub", client))
+	nodes.MaxRetries = 0
+	fetcher := NewFetcher(nodes, 0)
+	fetcher.Workers = 4
+	fetcher.WindowSize = 10
+	fetcher.PollInterval = time.Millisecond
+	fetcher.SetVersion(100)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	fetcher.Start(ctx)
+
+	next := int64(100)
+	errs := 0
+	for next <= 999 {
+		txs, err := fetcher.FetchNextBatch(ctx, 10)
+		if err != nil {
+			errs++
+			continue
+		}
+		for _, tx := range txs {
+			if tx.Version != next {
+				t.Fatalf("got version %d, want %d", tx.Version, next)
+			}
+			next++
+		}
+	}
+	if errs != 3 {
+		t.Fatalf("got %d errors, want 3", errs)
+	}
+	if fetcher.CurrentVersion != 1000 {
+		t.Fatalf("got current version %d, want 1000", fetcher.CurrentVersion)
+	}
+}
+
+func TestFetcher_FetchesFromFakeNode(t *testing.T) {
+	server, err := fakenode.NewServer(4, "../processor/token/testdata/transactions.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+	server.FailNext(http.StatusServiceUnavailable, http.StatusTooManyRequests)
+
+	nodes := NewNodePoolFromUrls(server.URL)
+	nodes.BaseBackoff = time.Millisecond
+	fetcher := NewFetcher(nodes, 0)
+	fetcher.WindowSize = 4
+	fetcher.PollInterval = 50 * time.Millisecond
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	ledgerInfo, err := fetcher.FetchLedgerInfo(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ledgerInfo.ChainID != 4 {
+		t.Fatalf("got chain id %d, want 4", ledgerInfo.ChainID)
+	}
+	fetcher.SetVersion(0)
+	fetcher.Start(ctx)
+
+	var users int
+	for fetcher.CurrentVersion <= server.LedgerVersion() {
+		txs, err := fetcher.FetchNextBatch(ctx, 4)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, tx := range txs {
+			if tx.Type == types.UserTransaction {
+				users++
+			}
+		}
+	}
+	if users != len(server.Versions()) {
+		t.Fatalf("got %d user transactions, want %d", users, len(server.Versions()))
+	}
+}
+
+func TestFetcher_StopsWhenCancelled(t *testing.T) {
+	client := &stubClient{ledgerVersion: 999, pageSize: 100}
+	fetcher := NewFetcher(NewNodePool(NewNode("stub", client)), 0)
+	fetcher.PollInterval = time.Minute
+	fetcher.SetVersion(0)
+	ctx, cancel := context.WithCancel(context.Background())
+	fetcher.Start(ctx)
+	if _, err := fetcher.FetchNextBatch(ctx, 10); err != nil {
+		t.Fatal(err)
+	}
+	cancel()
+
+	if _, err := fetcher.FetchNextBatch(ctx, 10); !errors.Is(err, context.Canceled) {
+		t.Fatalf("got %v, want %v", err, context.Canceled)
+	}
+}
+
+func TestFetcher_ConcurrentConsumers(t *testing.T) {
+	client := &stubClient{ledgerVersion: 999, pageSize: 50}
+	fetcher := NewFetcher(NewNodePool(NewNode("stub", client)), 0)
+	fetcher.WindowSize = 50
+	fetcher.PollInterval = time.Millisecond
+	fetcher.SetVersion(0)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	fetcher.Start(ctx)
+
+	var mu sync.Mutex
+	seen := make(map[int64]bool)
+	var wg sync.WaitGroup
+	for i := 0; i < 3; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				if fetcher.GetChainId() != 0 && fetcher.GetChainId() != 4 {
+					t.Errorf("got chain id %d", fetcher.GetChainId())
+				}
+				txs, err := fetcher.FetchNextBatch(ctx, 30)
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				mu.Lock()
+				for _, tx := range txs {
+					if seen[tx.Version] {
+						t.Errorf("version %d handed out twice", tx.Version)
+					}
+					seen[tx.Version] = true
+				}
+				done := len(seen) == 1000
+				mu.Unlock()
+				if done {
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	if len(seen) != 1000 {
+		t.Fatalf("got %d versions, want 1000", len(seen))
+	}
+}
@@ -0,0 +1,117 @@
+package indexer
+
+import (
+	"apotscan/types"
+	"context"
+	"gorm.io/gorm"
+)
+
+type VersionRange struct {
+	StartVersion int64
+	EndVersion   int64
+}
+
+//Split Splits the range into consecutive ranges of at most `size` versions
+func (r VersionRange) Split(size int64) []VersionRange {
+	var ranges []VersionRange
+	for start := r.StartVersion; start <= r.EndVersion; start += size {
+		end := start + size - 1
+		if end > r.EndVersion {
+			end = r.EndVersion
+		}
+		ranges = append(ranges, VersionRange{StartVersion: start, EndVersion: end})
+	}
+	return ranges
+}
+
+//GapReport Versions between genesis and the max version of a processor which have no `success=true` row
+type GapReport struct {
+	Name       string
+	MaxVersion int64
+	Gaps       []VersionRange
+	//Ordered The processor applies versions in order, its gaps are never replayed since later versions have already
+	//been applied, it has to be reprocessed from its first gap instead
+	Ordered bool
+}
+
+//Complete Whether the processor has covered genesis through its max version with no holes
+func (r GapReport) Complete() bool {
+	return len(r.Gaps) == 0
+}
+
+//GapReports Reports the gaps of every processor
+func (t *Tailor) GapReports(ctx context.Context) ([]GapReport, error) {
+	var reports []GapReport
+	for _, processor := range t.processors {
+		report, err := t.detectGaps(ctx, processor)
+		if err != nil {
+			return nil, err
+		}
+		reports = append(reports, *report)
+	}
+	return reports, nil
+}
+
+func (t *Tailor) detectGaps(ctx context.Context, processor *Processor) (*GapReport, error) {
+	maxVersion, err := processor.getMaxVersion(ctx)
+	if err != nil {
+		return nil, err
+	}
+	gaps, err := processor.findGaps(ctx, 0, maxVersion, false)
+	if err != nil {
+		return nil, err
+	}
+	return &GapReport{
+		Name:       processor.Name(),
+		MaxVersion: maxVersion,
+		Gaps:       gaps,
+		Ordered:    processor.ordered(),
+	}, nil
+}
+
+//findGaps Finds the versions between `from` and `to` which no `processor_statuses` row of this processor covers.
+//Only `success=true` rows count unless `attempted` is set, then failed and started rows count as well.
+//The gaps are found in the database, each row is compared with the highest end version of the rows starting before it
+func (p *Processor) findGaps(ctx context.Context, from, to int64, attempted bool) ([]VersionRange, error) {
+	if from > to {
+		return nil, nil
+	}
+	statuses := func() *gorm.DB {
+		db := p.GetDB().WithContext(ctx).Model(&types.ProcessorStatus{}).Where("name = ?", p.Name())
+		if !attempted {
+			db = db.Where("success = ?", true)
+		}
+		return db
+	}
+	bounds := statuses().Select("start_version, " +
+		"MAX(end_version) OVER (ORDER BY start_version, end_version ROWS BETWEEN UNBOUNDED PRECEDING AND 1 PRECEDING) AS covered")
+	var holes []VersionRange
+	if err := p.GetDB().WithContext(ctx).Table("(?) AS bounds", bounds).
+		Select("COALESCE(covered, ?) + 1 AS start_version, start_version - 1 AS end_version", from-1).
+		Where("start_version > COALESCE(covered, ?) + 1 AND COALESCE(covered, ?) < ?", from-1, from-1, to).
+		Order("bounds.start_version").
+		Find(&holes).Error; err != nil {
+		return nil, err
+	}
+	var covered int64
+	if err := statuses().Select("COALESCE(MAX(end_version), ?)", from-1).Scan(&covered).Error; err != nil {
+		return nil, err
+	}
+	if covered < to {
+		holes = append(holes, VersionRange{StartVersion: covered + 1, EndVersion: to})
+	}
+
+	var gaps []VersionRange
+	for _, hole := range holes {
+		if hole.StartVersion < from {
+			hole.StartVersion = from
+		}
+		if hole.EndVersion > to {
+			hole.EndVersion = to
+		}
+		if hole.StartVersion <= hole.EndVersion {
+			gaps = append(gaps, hole)
+		}
+	}
+	return gaps, nil
+}
@@ -0,0 +1,70 @@
+package indexer
+
+import (
+	"apotscan/logger"
+	"apotscan/types"
+	"context"
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestProcessor_FindGaps(t *testing.T) {
+	ctx := context.Background()
+	db := newTestDB(t)
+	_logger, err := logger.New(&logger.Config{Level: log.FatalLevel, FilePath: filepath.Join(t.TempDir(), "aptoscan")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	status := func(start, end int64) types.ProcessorStatus {
+		return types.ProcessorStatus{StartVersion: start, EndVersion: end, Success: true}
+	}
+	failed := func(start, end int64) types.ProcessorStatus {
+		return types.ProcessorStatus{StartVersion: start, EndVersion: end, Detail: "deadlock"}
+	}
+	cases := []struct {
+		name      string
+		statuses  []types.ProcessorStatus
+		from, to  int64
+		attempted bool
+		gaps      []VersionRange
+	}{
+		{"nothing processed", nil, 0, -1, false, nil},
+		{"no statuses", nil, 0, 99, false, []VersionRange{{0, 99}}},
+		{"fully covered", []types.ProcessorStatus{status(0, 49), status(50, 99)}, 0, 99, false, nil},
+		{"hole in the middle", []types.ProcessorStatus{status(0, 9), status(20, 29)}, 0, 29, false, []VersionRange{{10, 19}}},
+		{"missing genesis and tip", []types.ProcessorStatus{status(10, 19)}, 0, 29, false, []VersionRange{{0, 9}, {20, 29}}},
+		{"unordered and overlapping", []types.ProcessorStatus{status(15, 29), status(0, 9), status(5, 19)}, 0, 29, false, nil},
+		{"nested", []types.ProcessorStatus{status(0, 49), status(10, 19), status(60, 69)}, 0, 69, false, []VersionRange{{50, 59}}},
+		{"beyond max version", []types.ProcessorStatus{status(0, 9), status(40, 49)}, 0, 29, false, []VersionRange{{10, 29}}},
+		{"failed rows are gaps", []types.ProcessorStatus{status(0, 9), failed(10, 19), status(20, 29)}, 0, 29, false, []VersionRange{{10, 19}}},
+		{"failed rows were attempted", []types.ProcessorStatus{status(0, 9), failed(10, 19), failed(25, 29)}, 0, 29, true, []VersionRange{{20, 24}}},
+		{"from after genesis", []types.ProcessorStatus{status(0, 9), status(30, 39)}, 15, 35, false, []VersionRange{{15, 29}}},
+	}
+	for i, c := range cases {
+		processor := &Processor{TransactionProcessor: &recordingProcessor{name: fmt.Sprintf("gaps%d", i), db: db, logger: _logger}}
+		for _, status := range c.statuses {
+			status.Name = processor.Name()
+			if err = db.Create(&status).Error; err != nil {
+				t.Fatal(err)
+			}
+		}
+		gaps, err := processor.findGaps(ctx, c.from, c.to, c.attempted)
+		if err != nil {
+			t.Fatalf("%s: %v", c.name, err)
+		}
+		if !reflect.DeepEqual(gaps, c.gaps) {
+			t.Errorf("%s: got %v, want %v", c.name, gaps, c.gaps)
+		}
+	}
+}
+
+func TestVersionRange_Split(t *testing.T) {
+	ranges := VersionRange{StartVersion: 10, EndVersion: 34}.Split(10)
+	want := []VersionRange{{10, 19}, {20, 29}, {30, 34}}
+	if !reflect.DeepEqual(ranges, want) {
+		t.Fatalf("got %v, want %v", ranges, want)
+	}
+}
@@ -0,0 +1,136 @@
+package indexer
+
+import (
+	"apotscan/migration"
+	"gorm.io/gorm"
+	"time"
+)
+
+func init() {
+	migration.Register(
+		migration.Migration{
+			Version: 202209010000,
+			Name:    "create processor_statuses",
+			Up: func(db *gorm.DB) error {
+				return migration.CreateTables(db, &processorStatusV1{})
+			},
+			Down: func(db *gorm.DB) error {
+				return migration.DropTables(db, &processorStatusV1{})
+			},
+		},
+		migration.Migration{
+			Version: 202209200000,
+			Name:    "create processor_cursors",
+			Up: func(db *gorm.DB) error {
+				return migration.CreateTables(db, &processorCursorV1{})
+			},
+			Down: func(db *gorm.DB) error {
+				return migration.DropTables(db, &processorCursorV1{})
+			},
+		},
+		migration.Migration{
+			Version: 202209250000,
+			Name:    "add processor_statuses key",
+			Up: func(db *gorm.DB) error {
+				return migration.RebuildTables(db, &processorStatusV2{})
+			},
+			Down: func(db *gorm.DB) error {
+				return migration.RebuildTables(db, &processorStatusV1{})
+			},
+		},
+		migration.Migration{
+			Version: 202210010000,
+			Name:    "add processor_statuses error_kind",
+			Up: func(db *gorm.DB) error {
+				return db.Migrator().AddColumn(&processorStatusV3{}, "ErrorKind")
+			},
+			Down: func(db *gorm.DB) error {
+				return db.Migrator().DropColumn(&processorStatusV3{}, "ErrorKind")
+			},
+		},
+		migration.Migration{
+			Version: 202210020000,
+			Name:    "create dead_letters",
+			Up: func(db *gorm.DB) error {
+				return migration.CreateTables(db, &deadLetterV1{})
+			},
+			Down: func(db *gorm.DB) error {
+				return migration.DropTables(db, &deadLetterV1{})
+			},
+		},
+	)
+}
+
+type processorStatusV1 struct {
+	Name         string
+	StartVersion int64
+	EndVersion   int64
+	Success      bool
+	Detail       string
+
+	CreatedAt *time.Time `gorm:"autoCreateTime"`
+	UpdatedAt *time.Time `gorm:"autoUpdateTime;not null"`
+}
+
+func (processorStatusV1) TableName() string {
+	return "processor_statuses"
+}
+
+type processorCursorV1 struct {
+	Name       string `gorm:"primaryKey;size:64"`
+	ChainId    uint8  `gorm:"primaryKey;autoIncrement:false"`
+	MaxVersion int64  `gorm:"not null"`
+
+	UpdatedAt *time.Time `gorm:"autoUpdateTime;not null"`
+}
+
+func (processorCursorV1) TableName() string {
+	return "processor_cursors"
+}
+
+type processorStatusV2 struct {
+	Name         string `gorm:"primaryKey;size:64"`
+	StartVersion int64  `gorm:"primaryKey;autoIncrement:false"`
+	EndVersion   int64  `gorm:"primaryKey;autoIncrement:false"`
+	Success      bool
+	Detail       string
+
+	CreatedAt *time.Time `gorm:"autoCreateTime"`
+	UpdatedAt *time.Time `gorm:"autoUpdateTime;not null"`
+}
+
+func (processorStatusV2) TableName() string {
+	return "processor_statuses"
+}
+
+type processorStatusV3 struct {
+	Name         string `gorm:"primaryKey;size:64"`
+	StartVersion int64  `gorm:"primaryKey;autoIncrement:false"`
+	EndVersion   int64  `gorm:"primaryKey;autoIncrement:false"`
+	Success      bool
+	Detail       string
+	ErrorKind    string `gorm:"size:16"`
+
+	CreatedAt *time.Time `gorm:"autoCreateTime"`
+	UpdatedAt *time.Time `gorm:"autoUpdateTime;not null"`
+}
+
+func (processorStatusV3) TableName() string {
+	return "processor_statuses"
+}
+
+type deadLetterV1 struct {
+	Processor   string `gorm:"primaryKey;size:64"`
+	Version     int64  `gorm:"primaryKey;autoIncrement:false"`
+	EventIndex  int
+	Transaction string
+	Error       string
+	Attempts    int
+
+	CreatedAt *time.Time `gorm:"autoCreateTime"`
+	UpdatedAt *time.Time `gorm:"autoUpdateTime;not null"`
+}
+
+func (deadLetterV1) TableName() string {
+	return "dead_letters"
+}
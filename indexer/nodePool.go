@@ -0,0 +1,220 @@
+package indexer
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	aptos "github.com/portto/aptos-go-sdk/client"
+	"math/rand"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	DefaultNodeMaxRetries  = 5
+	DefaultNodeBaseBackoff = 200 * time.Millisecond
+	DefaultNodeMaxBackoff  = 10 * time.Second
+	DefaultNodeCooldown    = 5 * time.Second
+)
+
+type Node struct {
+	Url    string
+	Client aptos.API
+
+	failures       int
+	unhealthyUntil time.Time
+}
+
+func NewNode(url string, client aptos.API) *Node {
+	return &Node{
+		Url:    url,
+		Client: client,
+	}
+}
+
+//NodePool Sends every request to the first healthy fullnode, in the order they were given.
+//A node which fails with a retryable error is skipped for a cooldown growing with its consecutive failures,
+//requests are retried on the next node, backing off with jitter once no healthy node is left
+type NodePool struct {
+	//MaxRetries How many times a retryable request is retried before giving up
+	MaxRetries int
+	//BaseBackoff, MaxBackoff Bounds of the exponential backoff between retries
+	BaseBackoff time.Duration
+	MaxBackoff  time.Duration
+	//Cooldown How long a node is skipped after its first failure, doubled on every consecutive failure
+	Cooldown time.Duration
+
+	mu    sync.Mutex
+	nodes []*Node
+}
+
+func NewNodePool(nodes ...*Node) *NodePool {
+	return &NodePool{
+		MaxRetries:  DefaultNodeMaxRetries,
+		BaseBackoff: DefaultNodeBaseBackoff,
+		MaxBackoff:  DefaultNodeMaxBackoff,
+		Cooldown:    DefaultNodeCooldown,
+		nodes:       nodes,
+	}
+}
+
+//NewNodePoolFromUrls Builds a pool talking to every url through the aptos client
+func NewNodePoolFromUrls(urls ...string) *NodePool {
+	var nodes []*Node
+	for _, url := range urls {
+		nodes = append(nodes, NewNode(url, aptos.New(url)))
+	}
+	return NewNodePool(nodes...)
+}
+
+//Do Calls `call` with a node's client until it succeeds, fails with an error which is not retryable,
+//runs out of retries or `ctx` is done. The aptos client can't cancel a request in flight, so `ctx` is checked
+//between attempts. The returned error is always a *NodeError
+func (p *NodePool) Do(ctx context.Context, call func(client aptos.API) error) error {
+	if len(p.nodes) == 0 {
+		return &NodeError{Err: errors.New("no fullnode configured")}
+	}
+	for attempt := 0; ; attempt++ {
+		if err := ctx.Err(); err != nil {
+			return &NodeError{Err: err}
+		}
+		node, healthy := p.pick()
+		err := call(node.Client)
+		if err == nil {
+			p.markHealthy(node)
+			return nil
+		}
+		nodeErr := newNodeError(node.Url, err)
+		if !nodeErr.Retryable {
+			return nodeErr
+		}
+		p.markUnhealthy(node)
+		if attempt >= p.MaxRetries {
+			return nodeErr
+		}
+		if _, healthy = p.pick(); !healthy {
+			select {
+			case <-ctx.Done():
+				return nodeErr
+			case <-time.After(p.backoff(attempt)):
+			}
+		}
+	}
+}
+
+//pick Returns the first healthy node, or the one which recovers the soonest if none is
+func (p *NodePool) pick() (*Node, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	now := time.Now()
+	soonest := p.nodes[0]
+	for _, node := range p.nodes {
+		if !node.unhealthyUntil.After(now) {
+			return node, true
+		}
+		if node.unhealthyUntil.Before(soonest.unhealthyUntil) {
+			soonest = node
+		}
+	}
+	return soonest, false
+}
+
+func (p *NodePool) markHealthy(node *Node) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	node.failures = 0
+	node.unhealthyUntil = time.Time{}
+}
+
+func (p *NodePool) markUnhealthy(node *Node) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	cooldown := p.Cooldown << node.failures
+	if cooldown > p.MaxBackoff || cooldown <= 0 {
+		cooldown = p.MaxBackoff
+	}
+	node.failures++
+	node.unhealthyUntil = time.Now().Add(cooldown)
+}
+
+//backoff Exponential backoff with equal jitter, half of the delay is fixed and the other half random
+func (p *NodePool) backoff(attempt int) time.Duration {
+	delay := p.BaseBackoff << attempt
+	if delay > p.MaxBackoff || delay <= 0 {
+		delay = p.MaxBackoff
+	}
+	half := delay / 2
+	return half + time.Duration(rand.Int63n(int64(half)+1))
+}
+
+//NodeStatus Health of a single node, as seen by the pool
+type NodeStatus struct {
+	Url            string
+	Healthy        bool
+	Failures       int
+	UnhealthyUntil time.Time
+}
+
+func (p *NodePool) Status() []NodeStatus {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	now := time.Now()
+	var statuses []NodeStatus
+	for _, node := range p.nodes {
+		statuses = append(statuses, NodeStatus{
+			Url:            node.Url,
+			Healthy:        !node.unhealthyUntil.After(now),
+			Failures:       node.failures,
+			UnhealthyUntil: node.unhealthyUntil,
+		})
+	}
+	return statuses
+}
+
+//NodeError An error returned by a fullnode, `Retryable` tells whether the same request may succeed later
+type NodeError struct {
+	Url        string
+	StatusCode int
+	Retryable  bool
+	Err        error
+}
+
+func newNodeError(url string, err error) *NodeError {
+	nodeErr := &NodeError{
+		Url: url,
+		Err: err,
+	}
+	var syntaxErr *json.SyntaxError
+	var typeErr *json.UnmarshalTypeError
+	if _, scanErr := fmt.Sscanf(err.Error(), "response(%d)", &nodeErr.StatusCode); scanErr == nil {
+		// the aptos client formats every non 200 response this way
+		nodeErr.Retryable = nodeErr.StatusCode == http.StatusTooManyRequests ||
+			nodeErr.StatusCode == http.StatusRequestTimeout ||
+			nodeErr.StatusCode >= http.StatusInternalServerError
+	} else if errors.As(err, &syntaxErr) || errors.As(err, &typeErr) {
+		nodeErr.Retryable = false
+	} else {
+		// timeouts, refused and reset connections
+		nodeErr.Retryable = true
+	}
+	return nodeErr
+}
+
+func (e *NodeError) Error() string {
+	if e.Url == "" {
+		return e.Err.Error()
+	}
+	return fmt.Sprintf("node %s: %v", e.Url, e.Err)
+}
+
+func (e *NodeError) Unwrap() error {
+	return e.Err
+}
+
+//IsRetryable Whether `err` came from a fullnode and the same request may succeed later
+func IsRetryable(err error) bool {
+	var nodeErr *NodeError
+	return errors.As(err, &nodeErr) && nodeErr.Retryable
+}
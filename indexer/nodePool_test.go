@@ -0,0 +1,99 @@
+package indexer
+
+import (
+	"context"
+	"errors"
+	aptos "github.com/portto/aptos-go-sdk/client"
+	"net"
+	"testing"
+	"time"
+)
+
+//failingClient Fails every call with `err`
+type failingClient struct {
+	aptos.API
+	err   error
+	calls int
+}
+
+func (c *failingClient) LedgerInformation(opts ...interface{}) (*aptos.LedgerInfo, error) {
+	c.calls++
+	return nil, c.err
+}
+
+func newTestNodePool(nodes ...*Node) *NodePool {
+	pool := NewNodePool(nodes...)
+	pool.BaseBackoff = time.Millisecond
+	pool.MaxBackoff = 5 * time.Millisecond
+	pool.Cooldown = time.Minute
+	return pool
+}
+
+func TestNodePool_FailsOver(t *testing.T) {
+	down := &failingClient{err: errors.New("response(503): node is restarting")}
+	up := &stubClient{ledgerVersion: 10}
+	pool := newTestNodePool(NewNode("down", down), NewNode("up", up))
+
+	for i := 0; i < 3; i++ {
+		err := pool.Do(context.Background(), func(client aptos.API) error {
+			_, err := client.LedgerInformation()
+			return err
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if down.calls != 1 {
+		t.Fatalf("unhealthy node called %d times, want 1", down.calls)
+	}
+	status := pool.Status()
+	if status[0].Healthy || !status[1].Healthy {
+		t.Fatalf("unexpected health %+v", status)
+	}
+}
+
+func TestNodePool_DoesNotRetry(t *testing.T) {
+	notFound := &failingClient{err: errors.New("response(404): version not found")}
+	pool := newTestNodePool(NewNode("node", notFound))
+	err := pool.Do(context.Background(), func(client aptos.API) error {
+		_, err := client.LedgerInformation()
+		return err
+	})
+	if err == nil || IsRetryable(err) {
+		t.Fatalf("got %v, want an error which is not retryable", err)
+	}
+	if notFound.calls != 1 {
+		t.Fatalf("called %d times, want 1", notFound.calls)
+	}
+}
+
+func TestNodePool_GivesUp(t *testing.T) {
+	refused := &failingClient{err: &net.OpError{Op: "dial", Err: errors.New("connection refused")}}
+	pool := newTestNodePool(NewNode("node", refused))
+	pool.MaxRetries = 3
+	err := pool.Do(context.Background(), func(client aptos.API) error {
+		_, err := client.LedgerInformation()
+		return err
+	})
+	if !IsRetryable(err) {
+		t.Fatalf("got %v, want a retryable error", err)
+	}
+	if refused.calls != 4 {
+		t.Fatalf("called %d times, want 4", refused.calls)
+	}
+}
+
+func TestNewNodeError(t *testing.T) {
+	cases := map[string]bool{
+		"response(429): too many requests": true,
+		"response(500): internal error":    true,
+		"response(504): gateway timeout":   true,
+		"response(400): bad request":       false,
+		"response(410): pruned":            false,
+	}
+	for message, retryable := range cases {
+		if got := newNodeError("node", errors.New(message)).Retryable; got != retryable {
+			t.Errorf("%s: got retryable %v, want %v", message, got, retryable)
+		}
+	}
+}
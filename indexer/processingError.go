@@ -0,0 +1,44 @@
+package indexer
+
+import (
+	"fmt"
+)
+
+//ErrorKind How a processor failed a version range, recorded next to the detail in `processor_statuses`
+type ErrorKind string
+
+const (
+	//ErrorKindFailed The processor or its writes returned an error
+	ErrorKindFailed ErrorKind = "error"
+	//ErrorKindPanic The processor panicked, the panic was recovered so the other processors keep running
+	ErrorKindPanic ErrorKind = "panic"
+	//ErrorKindTimeout The processor didn't finish within its `Timeout`
+	ErrorKindTimeout ErrorKind = "timeout"
+)
+
+//ProcessingError Why a processor failed a version range
+type ProcessingError struct {
+	Processor    string
+	StartVersion int64
+	EndVersion   int64
+	Kind         ErrorKind
+	Err          error
+	//Stack Where the processor panicked, empty unless `Kind` is `ErrorKindPanic`
+	Stack string
+}
+
+func (e *ProcessingError) Error() string {
+	return fmt.Sprintf("%s %s on versions %d-%d: %v", e.Processor, e.Kind, e.StartVersion, e.EndVersion, e.Err)
+}
+
+func (e *ProcessingError) Unwrap() error {
+	return e.Err
+}
+
+//Detail What is recorded in `processor_statuses`, the stack of a panic included
+func (e *ProcessingError) Detail() string {
+	if e.Stack == "" {
+		return e.Err.Error()
+	}
+	return fmt.Sprintf("%v\n%s", e.Err, e.Stack)
+}
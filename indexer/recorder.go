@@ -0,0 +1,220 @@
+package indexer
+
+import (
+	"apotscan/types"
+	"bufio"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+const DefaultRecorderFileSize = 100000
+
+//Recorder Wraps a `TransactionFetcher` and writes every transaction it fetches into an archive, which `ArchiveFetcher` can replay.
+//The head, read through `FetchNextBatch`, is written as `<start>.ndjson.partial` and renamed to `<start>-<end>.ndjson` once it
+//holds `FileSize` transactions, or the next batch doesn't continue it. Transactions fetched through `FetchTransactions`,
+//by processors behind the head and by replays, are written to files of their own right away
+type Recorder struct {
+	TransactionFetcher
+	Dir      string
+	Gzip     bool
+	FileSize int
+
+	//mu Guards `head`, the batch cache fetches windows behind the head while the head is being read
+	mu   sync.Mutex
+	head *archiveWriter
+}
+
+func NewRecorder(fetcher TransactionFetcher, dir string, gzip bool, fileSize int) (*Recorder, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	return &Recorder{
+		TransactionFetcher: fetcher,
+		Dir:                dir,
+		Gzip:               gzip,
+		FileSize:           fileSize,
+	}, nil
+}
+
+func (r *Recorder) FetchNextBatch(ctx context.Context, batch int) ([]types.Transaction, error) {
+	transactions, err := r.TransactionFetcher.FetchNextBatch(ctx, batch)
+	if err != nil {
+		return nil, err
+	}
+	if err = r.write(transactions); err != nil {
+		return nil, err
+	}
+	return transactions, nil
+}
+
+//FetchTransactions Also records the transactions, so versions fetched behind the head leave no holes in the archive
+func (r *Recorder) FetchTransactions(ctx context.Context, start int64, limit int) ([]types.Transaction, error) {
+	transactions, err := r.TransactionFetcher.FetchTransactions(ctx, start, limit)
+	if err != nil {
+		return nil, err
+	}
+	if err = r.writeRange(transactions); err != nil {
+		return nil, err
+	}
+	return transactions, nil
+}
+
+//FetchLedgerInfo Also saves the ledger info into the archive, so the archive knows which chain it came from
+func (r *Recorder) FetchLedgerInfo(ctx context.Context) (*types.LedgerInfo, error) {
+	ledgerInfo, err := r.TransactionFetcher.FetchLedgerInfo(ctx)
+	if err != nil {
+		return nil, err
+	}
+	data, err := json.Marshal(ledgerInfo)
+	if err != nil {
+		return nil, err
+	}
+	if err = os.WriteFile(filepath.Join(r.Dir, ArchiveLedgerInfoFile), data, 0644); err != nil {
+		return nil, err
+	}
+	return ledgerInfo, nil
+}
+
+func (r *Recorder) write(transactions []types.Transaction) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for i := range transactions {
+		tx := &transactions[i]
+		if r.head != nil && tx.Version != r.head.end+1 {
+			if err := r.finish(); err != nil {
+				return err
+			}
+		}
+		if r.head == nil {
+			head, err := r.create(fmt.Sprintf("%020d%s.partial", tx.Version, archiveExtension), tx.Version)
+			if err != nil {
+				return err
+			}
+			r.head = head
+		}
+		if err := r.head.write(tx); err != nil {
+			return err
+		}
+		if r.head.count >= r.FileSize {
+			if err := r.finish(); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+//writeRange Writes every run of consecutive versions in `transactions` to a file of its own
+func (r *Recorder) writeRange(transactions []types.Transaction) error {
+	for start := 0; start < len(transactions); {
+		end := start + 1
+		for end < len(transactions) && transactions[end].Version == transactions[end-1].Version+1 {
+			end++
+		}
+		first, last := transactions[start].Version, transactions[end-1].Version
+		w, err := r.create(fmt.Sprintf("%020d-%020d%s.partial", first, last, archiveExtension), first)
+		if err != nil {
+			return err
+		}
+		for i := start; i < end; i++ {
+			if err = w.write(&transactions[i]); err != nil {
+				_ = w.close()
+				return err
+			}
+		}
+		if err = r.rename(w); err != nil {
+			return err
+		}
+		start = end
+	}
+	return nil
+}
+
+func (r *Recorder) create(name string, start int64) (*archiveWriter, error) {
+	path := filepath.Join(r.Dir, name)
+	file, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	w := &archiveWriter{path: path, file: file, start: start, end: start - 1}
+	var out io.Writer = file
+	if r.Gzip {
+		w.gzip = gzip.NewWriter(file)
+		out = w.gzip
+	}
+	w.buffer = bufio.NewWriter(out)
+	w.encoder = json.NewEncoder(w.buffer)
+	return w, nil
+}
+
+//finish Closes the head file and gives it its final name, `mu` must be held
+func (r *Recorder) finish() error {
+	if r.head == nil {
+		return nil
+	}
+	head := r.head
+	r.head = nil
+	return r.rename(head)
+}
+
+//rename Closes a file, then gives it its final name
+func (r *Recorder) rename(w *archiveWriter) error {
+	if err := w.close(); err != nil {
+		return err
+	}
+	extension := archiveExtension
+	if r.Gzip {
+		extension = archiveGzipExtension
+	}
+	name := fmt.Sprintf("%020d-%020d%s", w.start, w.end, extension)
+	return os.Rename(w.path, filepath.Join(r.Dir, name))
+}
+
+//Close Finishes the file being written, transactions recorded so far become readable by `ArchiveFetcher`
+func (r *Recorder) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.finish()
+}
+
+//archiveWriter A file of consecutive transactions being written
+type archiveWriter struct {
+	path    string
+	file    *os.File
+	gzip    *gzip.Writer
+	buffer  *bufio.Writer
+	encoder *json.Encoder
+	start   int64
+	end     int64
+	count   int
+}
+
+func (w *archiveWriter) write(tx *types.Transaction) error {
+	if err := w.encoder.Encode(tx); err != nil {
+		return err
+	}
+	w.end = tx.Version
+	w.count++
+	return nil
+}
+
+//close Flushes and closes the file
+func (w *archiveWriter) close() error {
+	if err := w.buffer.Flush(); err != nil {
+		_ = w.file.Close()
+		return err
+	}
+	if w.gzip != nil {
+		if err := w.gzip.Close(); err != nil {
+			_ = w.file.Close()
+			return err
+		}
+	}
+	return w.file.Close()
+}
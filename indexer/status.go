@@ -0,0 +1,192 @@
+package indexer
+
+import (
+	"context"
+	"errors"
+	"math"
+	"strconv"
+	"sync"
+	"time"
+)
+
+//throughputWindow Roughly how far back the processing rate behind the ETA looks
+const throughputWindow = time.Minute
+
+//Status What a running Tailor is doing
+type Status struct {
+	ChainId uint8 `json:"chain_id"`
+	//LedgerVersion The latest ledger version known, from the fetcher or the chain id check
+	LedgerVersion int64 `json:"ledger_version"`
+	//Tailing Whether the Tailor has started processing new batches, it replays previous errors before that
+	Tailing    bool              `json:"tailing"`
+	LastActive *time.Time        `json:"last_active,omitempty"`
+	Processors []ProcessorReport `json:"processors"`
+}
+
+//ProcessorReport Progress of a single processor. `ETASeconds` is left out while the processing rate is unknown
+type ProcessorReport struct {
+	Name              string          `json:"name"`
+	MaxVersion        int64           `json:"max_version"`
+	VersionsBehind    int64           `json:"versions_behind"`
+	VersionsPerSecond float64         `json:"versions_per_second"`
+	ETASeconds        *float64        `json:"eta_seconds,omitempty"`
+	LastError         *ProcessorError `json:"last_error,omitempty"`
+}
+
+//ProcessorError The latest range of a processor which failed and hasn't succeeded since
+type ProcessorError struct {
+	StartVersion int64      `json:"start_version"`
+	EndVersion   int64      `json:"end_version"`
+	Kind         string     `json:"kind,omitempty"`
+	Detail       string     `json:"detail"`
+	At           *time.Time `json:"at,omitempty"`
+}
+
+//tailorState What the Tailor has been doing, read by the status endpoint while the Tailor runs
+type tailorState struct {
+	mu            sync.Mutex
+	chainId       uint8
+	ledgerVersion int64
+	tailing       bool
+	lastActive    time.Time
+}
+
+//setLedgerInfo Remembers the chain and ledger version once the chain id has been checked
+func (s *tailorState) setLedgerInfo(chainId uint8, ledgerVersion string) {
+	version, err := strconv.ParseInt(ledgerVersion, 10, 64)
+	if err != nil {
+		version = -1
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.chainId = chainId
+	if version > s.ledgerVersion {
+		s.ledgerVersion = version
+	}
+}
+
+//touch Records that the Tailor is making progress, `tailing` once it processes new batches
+func (s *tailorState) touch(tailing bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.lastActive = time.Now()
+	s.tailing = s.tailing || tailing
+}
+
+//Alive Whether the Tailor has been active within `maxIdle`, a Tailor which hasn't started yet is alive
+func (t *Tailor) Alive(maxIdle time.Duration) bool {
+	t.state.mu.Lock()
+	defer t.state.mu.Unlock()
+	return t.state.lastActive.IsZero() || time.Since(t.state.lastActive) <= maxIdle
+}
+
+//Ready Returns an error unless the Tailor is tailing new batches and the database can be reached
+func (t *Tailor) Ready(ctx context.Context) error {
+	t.state.mu.Lock()
+	tailing := t.state.tailing
+	t.state.mu.Unlock()
+	if !tailing {
+		return errors.New("not tailing yet")
+	}
+	sqlDB, err := t.db.DB()
+	if err != nil {
+		return err
+	}
+	return sqlDB.PingContext(ctx)
+}
+
+//Status Reports the chain, the ledger version and every processor's progress. The max version of a processor
+//which is tailing comes from its watermark, the others are read from their cursors
+func (t *Tailor) Status(ctx context.Context) (*Status, error) {
+	t.state.mu.Lock()
+	status := &Status{
+		ChainId:       t.state.chainId,
+		LedgerVersion: t.state.ledgerVersion,
+		Tailing:       t.state.tailing,
+	}
+	if !t.state.lastActive.IsZero() {
+		lastActive := t.state.lastActive
+		status.LastActive = &lastActive
+	}
+	t.state.mu.Unlock()
+	if known := t.TransactionFetcher.GetHighestKnownVersion(); known > status.LedgerVersion {
+		status.LedgerVersion = known
+	}
+
+	for _, processor := range t.processors {
+		report := ProcessorReport{
+			Name: processor.Name(),
+		}
+		if watermark := processor.getWatermark(); watermark != nil {
+			report.MaxVersion = watermark.Version()
+		} else {
+			maxVersion, err := processor.getMaxVersion(ctx)
+			if err != nil {
+				return nil, err
+			}
+			report.MaxVersion = maxVersion
+		}
+		if behind := status.LedgerVersion - report.MaxVersion; behind > 0 {
+			report.VersionsBehind = behind
+		}
+		report.VersionsPerSecond = processor.throughput.Rate()
+		if report.VersionsBehind == 0 {
+			eta := 0.0
+			report.ETASeconds = &eta
+		} else if report.VersionsPerSecond > 0 {
+			eta := float64(report.VersionsBehind) / report.VersionsPerSecond
+			report.ETASeconds = &eta
+		}
+
+		lastError, err := processor.getLastError(ctx)
+		if err != nil {
+			return nil, err
+		}
+		if lastError != nil {
+			report.LastError = &ProcessorError{
+				StartVersion: lastError.StartVersion,
+				EndVersion:   lastError.EndVersion,
+				Kind:         lastError.ErrorKind,
+				Detail:       lastError.Detail,
+				At:           lastError.UpdatedAt,
+			}
+		}
+		status.Processors = append(status.Processors, report)
+	}
+	return status, nil
+}
+
+//throughput Versions per second a processor commits, an exponentially weighted average over about `throughputWindow`
+type throughput struct {
+	mu      sync.Mutex
+	version int64
+	at      time.Time
+	rate    float64
+}
+
+//observe Records that every version up to `version` has been committed at `now`
+func (t *throughput) observe(version int64, now time.Time) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.at.IsZero() {
+		t.version, t.at = version, now
+		return
+	}
+	elapsed := now.Sub(t.at).Seconds()
+	if elapsed <= 0 || version <= t.version {
+		return
+	}
+	instant := float64(version-t.version) / elapsed
+	if t.rate == 0 {
+		t.rate = instant
+	} else {
+		t.rate += (1 - math.Exp(-elapsed/throughputWindow.Seconds())) * (instant - t.rate)
+	}
+	t.version, t.at = version, now
+}
+
+func (t *throughput) Rate() float64 {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.rate
+}
@@ -2,20 +2,23 @@ package indexer
 
 import (
 	"apotscan/logger"
+	"apotscan/metrics"
+	"apotscan/migration"
 	"apotscan/types"
 	"context"
 	"encoding/json"
 	"fmt"
 	"github.com/go-redis/redis/v8"
-	aptos "github.com/portto/aptos-go-sdk/client"
 	log "github.com/sirupsen/logrus"
 	"gorm.io/gorm"
-	"math"
-	"sync"
+	"time"
 )
 
-var (
-	ctx = context.Background()
+const (
+	//replayFetchSize How many transactions are fetched per request when replaying failed versions
+	replayFetchSize = 100
+	//gapReplaySize How many versions of a gap are re-run through a processor at once
+	gapReplaySize = 1000
 )
 
 type Tailor struct {
@@ -24,35 +27,79 @@ type Tailor struct {
 	db                 *gorm.DB
 	redisCli           *redis.Client
 	logger             *logger.Logger
+	state              tailorState
+	cache              *BatchCache
+
+	//CacheSize How many fetched transactions are kept for processors behind the others, 0 keeps the default
+	CacheSize int
+	//CacheWindowSize How many versions a processor behind the fetcher fetches at once, 0 keeps the default
+	CacheWindowSize int
+	//CachePrefetch How many windows ahead a processor behind the fetcher prefetches, negative keeps the default
+	CachePrefetch int
 }
 
-func NewTailor(nodeUrl string, db *gorm.DB, config *logger.Config, redisCli *redis.Client) *Tailor {
+func NewTailor(transactionFetcher TransactionFetcher, db *gorm.DB, config *logger.Config, redisCli *redis.Client) *Tailor {
 	_logger, err := logger.New(config)
 	if err != nil {
 		panic(err)
 	}
-	transactionFetcher := NewFetcher(aptos.New(nodeUrl), 0)
 	return &Tailor{
 		TransactionFetcher: transactionFetcher,
 		db:                 db,
 		logger:             _logger,
 		processors:         []*Processor{},
 		redisCli:           redisCli,
+		state: tailorState{
+			ledgerVersion: -1,
+		},
+		CachePrefetch: -1,
 	}
 }
 
-func (t *Tailor) RunMigrations() {
+//RunMigrations Applies every registered migration which hasn't been applied yet, in version order
+func (t *Tailor) RunMigrations(ctx context.Context) error {
+	applied, err := migration.New(t.db, migration.Registered()...).Up(ctx)
+	for _, m := range applied {
+		t.logger.WithFields(log.Fields{
+			"version": m.Version,
+			"name":    m.Name,
+		}).Info("Applied migration")
+	}
+	return err
+}
 
+//PendingMigrations Registered migrations which haven't been applied yet
+func (t *Tailor) PendingMigrations(ctx context.Context) ([]migration.Migration, error) {
+	return migration.New(t.db, migration.Registered()...).Pending(ctx)
 }
 
-//CheckOrUpdateChainId If chain id doesn't exist, save it. Otherwise make sure that we're indexing the same chain
-func (t *Tailor) CheckOrUpdateChainId() error {
+//CheckOrUpdateChainId If chain id doesn't exist, save it. Otherwise make sure that we're indexing the same chain.
+//Processor cursors of another chain mean the database belongs to that chain, the ledger info in redis is checked too when redis is configured
+func (t *Tailor) CheckOrUpdateChainId(ctx context.Context) error {
 	t.logger.Info("Checking if chain id is correct")
-	newLedgerInfo, err := t.TransactionFetcher.FetchLedgerInfo()
+	newLedgerInfo, err := t.TransactionFetcher.FetchLedgerInfo(ctx)
 	if err != nil {
 		return err
 	}
 
+	var chainIds []uint8
+	if err = t.db.WithContext(ctx).Model(&types.ProcessorCursor{}).
+		Where("chain_id <> ?", newLedgerInfo.ChainID).
+		Distinct().
+		Pluck("chain_id", &chainIds).Error; err != nil {
+		return err
+	}
+	if len(chainIds) > 0 {
+		t.logger.WithFields(log.Fields{
+			"try to index chain": newLedgerInfo.ChainID,
+			"exist chain":        chainIds,
+		}).Panic("Wrong chain detected!")
+	}
+	t.state.setLedgerInfo(uint8(newLedgerInfo.ChainID), newLedgerInfo.LedgerVersion)
+	if t.redisCli == nil {
+		return nil
+	}
+
 	result, err := t.redisCli.Get(ctx, types.LedgerInfoKey).Result()
 	if err != nil && err != redis.Nil {
 		return err
@@ -92,32 +139,140 @@ func (t *Tailor) AddProcessor(processor *Processor) {
 	t.processors = append(t.processors, processor)
 }
 
-//HandlePreviousErrors For all versions which have an `success=false` in the `processor_status` table, re-run them
-//TODO: also handle gaps in sequence numbers (pg query for this is super easy)
-func (t *Tailor) HandlePreviousErrors() error {
-	//todo:
+//HandlePreviousErrors For all versions which have an `success=false` in the `processor_status` table, re-run them.
+//Gaps, versions up to the processor's max version without any `processor_status` row, are re-run as well.
+//Gaps of an `OrderedProcessor` are only reported, re-running them after later versions would apply none of their changes
+func (t *Tailor) HandlePreviousErrors(ctx context.Context) error {
+	t.logger.Info("Handling previous errors")
+	for _, processor := range t.processors {
+		ranges, err := processor.getFailedRanges(ctx)
+		if err != nil {
+			return err
+		}
+		for _, r := range ranges {
+			if err = t.replayRange(ctx, processor, r.StartVersion, r.EndVersion); err != nil {
+				return err
+			}
+		}
+
+		maxVersion, err := processor.getMaxVersion(ctx)
+		if err != nil {
+			return err
+		}
+		// failed ranges were just replayed above, only schedule versions nobody has ever looked at
+		gaps, err := processor.findGaps(ctx, 0, maxVersion, true)
+		if err != nil {
+			return err
+		}
+		if len(gaps) > 0 && processor.ordered() {
+			t.logger.WithFields(log.Fields{
+				"processor":     processor.Name(),
+				"gaps":          len(gaps),
+				"first version": gaps[0].StartVersion,
+				"max version":   maxVersion,
+			}).Warn("Processor applies versions in order, reprocess from its first gap instead of replaying gaps")
+			continue
+		}
+		for _, gap := range gaps {
+			for _, r := range gap.Split(gapReplaySize) {
+				if err = t.replayRange(ctx, processor, r.StartVersion, r.EndVersion); err != nil {
+					return err
+				}
+			}
+		}
+	}
 	return nil
 }
 
-//SetFetcherToLowestProcessorVersion Sets the version of the fetcher to the lowest version among all processors
-func (t *Tailor) SetFetcherToLowestProcessorVersion() (int64, error) {
-	var lowest int64
-	lowest = math.MaxInt64
+//replayRange Re-fetches the versions from `startVersion` to `endVersion` and re-runs them through a single processor.
+//A failed re-run is recorded in `processor_statuses` and logged, only fetch errors are returned
+func (t *Tailor) replayRange(ctx context.Context, processor *Processor, startVersion, endVersion int64) error {
+	t.logger.WithFields(log.Fields{
+		"processor":     processor.Name(),
+		"start version": startVersion,
+		"end version":   endVersion,
+	}).Info("Replaying failed versions")
+	t.state.touch(false)
+	var txs []types.Transaction
+	for version := startVersion; version <= endVersion; {
+		limit := replayFetchSize
+		if remaining := endVersion - version + 1; remaining < int64(limit) {
+			limit = int(remaining)
+		}
+		fetched, err := t.TransactionFetcher.FetchTransactions(ctx, version, limit)
+		if err != nil {
+			return err
+		}
+		if len(fetched) == 0 {
+			return fmt.Errorf("no transactions returned from version %d while replaying %d-%d", version, startVersion, endVersion)
+		}
+		txs = append(txs, fetched...)
+		version += int64(len(fetched))
+	}
+
+	if _, err := processor.processTransactionsWithStatus(ctx, txs, startVersion, endVersion); err != nil {
+		if ctx.Err() != nil {
+			return err
+		}
+		t.logger.WithFields(log.Fields{
+			"processor":     processor.Name(),
+			"start version": startVersion,
+			"end version":   endVersion,
+			"error":         err,
+		}).Error("Replaying failed versions failed again")
+		return nil
+	}
+	t.logger.WithFields(log.Fields{
+		"processor":     processor.Name(),
+		"start version": startVersion,
+		"end version":   endVersion,
+	}).Info("Replayed failed versions")
+	return nil
+}
+
+//SetProcessorCursors Starts every processor from the version after its own max version.
+//The fetcher starts after the highest of them, processors behind it catch up through the batch cache
+//without making the others re-process what they already have
+func (t *Tailor) SetProcessorCursors(ctx context.Context) (int64, error) {
+	var highest int64 = -1
 	for _, processor := range t.processors {
-		maxVersion, err := processor.getMaxVersion()
+		maxVersion, err := processor.getMaxVersion(ctx)
 		if err != nil {
-			return lowest, err
+			return highest, err
 		}
 		t.logger.WithFields(log.Fields{
 			"chain id":    t.TransactionFetcher.GetChainId(),
 			"processor":   processor.TransactionProcessor.Name(),
 			"max version": maxVersion,
-		}).Debug("Process max version")
-		if lowest > maxVersion {
-			lowest = maxVersion
+		}).Info(fmt.Sprintf("Will start processor from version %d", maxVersion+1))
+		processor.setWatermark(NewWatermark(maxVersion))
+		processor.setNextVersion(maxVersion + 1)
+		processor.throughput.observe(maxVersion, time.Now())
+		metrics.SetProcessorVersion(processor.Name(), maxVersion)
+		if highest < maxVersion {
+			highest = maxVersion
 		}
 	}
-	return t.SetFetcherVersion(lowest)
+	version, err := t.SetFetcherVersion(highest + 1)
+	if err != nil {
+		return version, err
+	}
+	t.cache = NewBatchCache(t.TransactionFetcher, version)
+	if t.CacheSize > 0 {
+		t.cache.Size = t.CacheSize
+	}
+	if t.CacheWindowSize > 0 {
+		t.cache.WindowSize = t.CacheWindowSize
+	}
+	if t.CachePrefetch >= 0 {
+		t.cache.Prefetch = t.CachePrefetch
+	}
+	return version, nil
+}
+
+//Processors Every processor added, in the order they were added
+func (t *Tailor) Processors() []*Processor {
+	return t.processors
 }
 
 func (t *Tailor) SetFetcherVersion(version int64) (int64, error) {
@@ -126,74 +281,96 @@ func (t *Tailor) SetFetcherVersion(version int64) (int64, error) {
 	return version, nil
 }
 
-func (t *Tailor) ProcessVersion(version uint64) ([]processResult, error) {
-	tx, err := t.GetTxn(version)
+func (t *Tailor) ProcessVersion(ctx context.Context, version uint64) ([]processResult, error) {
+	tx, err := t.GetTxn(ctx, version)
 	if err != nil {
 		return nil, err
 	}
-	return t.ProcessTransactions([]types.Transaction{*tx}), nil
+	return t.ProcessTransactions(ctx, []types.Transaction{*tx}), nil
 }
 
-//ProcessNextBatch todo: 这他吗的能并行执行么
-func (t *Tailor) ProcessNextBatch(batchSize uint8, singleFetchTxs int) (uint64, []processResult, error) {
-	txs, err := t.TransactionFetcher.FetchNextBatch(singleFetchTxs)
+//ProcessNextBatch Fetches the next batch of `processor` from its own cursor and splits it across `batchSize` goroutines,
+//a single one for an `OrderedProcessor`. Slices may finish in any order, the processor's watermark makes sure the max version
+//never skips a failed slice. The processor's cursor stops at the first failed slice, so the next batch retries it
+//and every version after it
+func (t *Tailor) ProcessNextBatch(ctx context.Context, processor *Processor, batchSize uint8, singleFetchTxs int) (uint64, []processResult, error) {
+	t.state.touch(true)
+	txs, err := t.cache.Get(ctx, processor.NextVersion(), singleFetchTxs)
 	if err != nil {
 		return 0, nil, err
 	}
 	txsAmount := len(txs)
-	singleGoroutineTxAmount := txsAmount / int(batchSize)
-	if singleGoroutineTxAmount < 0 {
-		results := t.ProcessTransactions(txs)
-		return uint64(txsAmount), results, nil
+	if txsAmount == 0 {
+		return 0, nil, nil
 	}
-	var remainBarch = batchSize
-	resultCh := make(chan []processResult)
-	var wg sync.WaitGroup
-	var results []processResult
-	for i := 0; i < int(batchSize); i++ {
-		wg.Add(1)
-		var txs2Process []types.Transaction
-		if i != int(batchSize)-1 {
-			txs2Process = txs[i*singleGoroutineTxAmount : (i+1)*singleGoroutineTxAmount]
-		} else {
-			txs2Process = txs[i*singleGoroutineTxAmount:]
-		}
-		go func(i int) {
-			defer wg.Done()
-			resultCh <- t.ProcessTransactions(txs2Process)
-		}(i)
+	metrics.BatchSize.Observe(float64(txsAmount))
+	if processor.ordered() {
+		batchSize = 1
 	}
-	for {
-		select {
-		case singleBatchResult := <-resultCh:
-			remainBarch--
-			results = append(results, singleBatchResult...)
-			if remainBarch == 0 {
-				return uint64(txsAmount), results, nil
+	var results []processResult
+	singleGoroutineTxAmount := txsAmount / int(batchSize)
+	if singleGoroutineTxAmount == 0 || batchSize == 1 {
+		results = append(results, t.processSlice(ctx, processor, txs))
+	} else {
+		resultCh := make(chan processResult)
+		for i := 0; i < int(batchSize); i++ {
+			var txs2Process []types.Transaction
+			if i != int(batchSize)-1 {
+				txs2Process = txs[i*singleGoroutineTxAmount : (i+1)*singleGoroutineTxAmount]
+			} else {
+				txs2Process = txs[i*singleGoroutineTxAmount:]
 			}
+			go func() {
+				resultCh <- t.processSlice(ctx, processor, txs2Process)
+			}()
+		}
+		for i := 0; i < int(batchSize); i++ {
+			results = append(results, <-resultCh)
 		}
 	}
+	next := txs[txsAmount-1].Version + 1
+	for _, result := range results {
+		if result.Err() != nil && result.Result().StartVersion < next {
+			next = result.Result().StartVersion
+		}
+	}
+	processor.setNextVersion(next)
+	return uint64(txsAmount), results, nil
 }
 
-func (t *Tailor) ProcessTransactions(transactions []types.Transaction) []processResult {
-	var txs []types.Transaction
-	for _, tx := range transactions {
-		if tx.Success == true {
-			txs = append(txs, tx)
-		}
+//CaughtUp Whether `processor` has processed every version the fetcher knows of. A batch shorter than asked for doesn't tell,
+//the batch cache returns whatever it holds in one piece
+func (t *Tailor) CaughtUp(processor *Processor) bool {
+	return processor.NextVersion() > t.TransactionFetcher.GetHighestKnownVersion()
+}
+
+//processSlice Runs consecutive transactions through a single processor, a failed call has no result of its own
+//so the range it was given is reported with the error
+func (t *Tailor) processSlice(ctx context.Context, processor *Processor, transactions []types.Transaction) processResult {
+	startVersion := transactions[0].Version
+	endVersion := transactions[len(transactions)-1].Version
+	result, err := processor.processTransactionsWithStatus(ctx, transactions, startVersion, endVersion)
+	if result == nil {
+		result = types.NewProcessResult(processor.Name(), startVersion, endVersion)
+	}
+	return processResult{
+		result: *result,
+		error:  err,
+	}
+}
+
+func (t *Tailor) ProcessTransactions(ctx context.Context, transactions []types.Transaction) []processResult {
+	if len(transactions) == 0 || len(t.processors) == 0 {
+		return nil
 	}
 
 	var results []processResult
 	resultCh := make(chan processResult)
 	var remainingTasks = len(t.processors)
 	for _, processor := range t.processors {
-		go func(processor *Processor, txs []types.Transaction) {
-			result, err := processor.processTransactionsWithStatus(txs)
-			resultCh <- processResult{
-				result: *result,
-				error:  err,
-			}
-		}(processor, txs)
+		go func(processor *Processor) {
+			resultCh <- t.processSlice(ctx, processor, transactions)
+		}(processor)
 	}
 
 	for {
@@ -208,11 +385,29 @@ func (t *Tailor) ProcessTransactions(transactions []types.Transaction) []process
 	}
 }
 
-func (t *Tailor) GetTxn(version uint64) (*types.Transaction, error) {
-	return t.TransactionFetcher.FetchVersion(version)
+func successfulTransactions(transactions []types.Transaction) []types.Transaction {
+	var txs []types.Transaction
+	for _, tx := range transactions {
+		if tx.Success == true {
+			txs = append(txs, tx)
+		}
+	}
+	return txs
+}
+
+func (t *Tailor) GetTxn(ctx context.Context, version uint64) (*types.Transaction, error) {
+	return t.TransactionFetcher.FetchVersion(ctx, version)
 }
 
 type processResult struct {
 	result types.ProcessResult
 	error  error
 }
+
+func (r processResult) Result() types.ProcessResult {
+	return r.result
+}
+
+func (r processResult) Err() error {
+	return r.error
+}
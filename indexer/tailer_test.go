@@ -0,0 +1,513 @@
+package indexer
+
+import (
+	"apotscan/logger"
+	"apotscan/migration"
+	"apotscan/types"
+	"context"
+	"errors"
+	"github.com/go-redis/redis/v8"
+	aptos "github.com/portto/aptos-go-sdk/client"
+	log "github.com/sirupsen/logrus"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	gormlogger "gorm.io/gorm/logger"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+//countingClient Counts the requests for transactions starting at each version
+type countingClient struct {
+	*stubClient
+	mu     sync.Mutex
+	starts map[int]int
+}
+
+func (c *countingClient) GetTransactions(start, limit int, opts ...interface{}) ([]aptos.TransactionResp, error) {
+	c.mu.Lock()
+	c.starts[start]++
+	c.mu.Unlock()
+	return c.stubClient.GetTransactions(start, limit, opts...)
+}
+
+//recordingProcessor Remembers every version it has been handed, after calling `before` if it is set
+type recordingProcessor struct {
+	name   string
+	db     *gorm.DB
+	logger *logger.Logger
+	before func(ctx context.Context, txs []types.Transaction) error
+
+	mu       sync.Mutex
+	versions []int64
+}
+
+func (p *recordingProcessor) Name() string {
+	return p.name
+}
+
+func (p *recordingProcessor) ChainId() uint8 {
+	return 4
+}
+
+func (p *recordingProcessor) ProcessTransactions(ctx context.Context, db *gorm.DB, txs []types.Transaction, startVersion, endVersion int64) (*types.ProcessResult, error) {
+	if p.before != nil {
+		if err := p.before(ctx, txs); err != nil {
+			return nil, err
+		}
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, tx := range txs {
+		p.versions = append(p.versions, tx.Version)
+	}
+	return types.NewProcessResult(p.name, startVersion, endVersion), nil
+}
+
+func (p *recordingProcessor) GetDB() *gorm.DB {
+	return p.db
+}
+
+func (p *recordingProcessor) GetRedis() *redis.Client {
+	return nil
+}
+
+func (p *recordingProcessor) GetLogger() *logger.Logger {
+	return p.logger
+}
+
+//newTestDB A migrated sqlite database in a temporary directory
+func newTestDB(t *testing.T) *gorm.DB {
+	db, err := gorm.Open(sqlite.Open(filepath.Join(t.TempDir(), "aptoscan.db")), &gorm.Config{
+		Logger: gormlogger.Default.LogMode(gormlogger.Silent),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sqlDB, err := db.DB()
+	if err != nil {
+		t.Fatal(err)
+	}
+	sqlDB.SetMaxOpenConns(1)
+	if _, err = migration.New(db, migration.Registered()...).Up(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+func TestTailor_ProcessorsAdvanceOnTheirOwnCursors(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	db := newTestDB(t)
+	if err := db.Create(&types.ProcessorCursor{Name: "ahead", ChainId: 4, MaxVersion: 199}).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	client := &countingClient{stubClient: &stubClient{ledgerVersion: 299, pageSize: 100}, starts: make(map[int]int)}
+	fetcher := NewFetcher(newTestNodePool(NewNode("stub", client)), 0)
+	fetcher.WindowSize = 50
+	fetcher.PollInterval = time.Millisecond
+	logConf := &logger.Config{Level: log.ErrorLevel, FilePath: filepath.Join(t.TempDir(), "aptoscan")}
+	_logger, err := logger.New(logConf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tailor := NewTailor(fetcher, db, logConf, nil)
+	tailor.CacheWindowSize = 50
+	ahead := &recordingProcessor{name: "ahead", db: db, logger: _logger}
+	genesis := &recordingProcessor{name: "genesis", db: db, logger: _logger}
+	tailor.AddProcessor(&Processor{TransactionProcessor: ahead})
+	tailor.AddProcessor(&Processor{TransactionProcessor: genesis})
+
+	head, err := tailor.SetProcessorCursors(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if head != 200 {
+		t.Fatalf("got fetcher starting at %d, want 200", head)
+	}
+	tailor.TransactionFetcher.Start(ctx)
+	var wg sync.WaitGroup
+	for _, processor := range tailor.Processors() {
+		wg.Add(1)
+		go func(processor *Processor) {
+			defer wg.Done()
+			for processor.NextVersion() <= 299 {
+				if _, _, err := tailor.ProcessNextBatch(ctx, processor, 2, 30); err != nil {
+					t.Error(err)
+					return
+				}
+			}
+		}(processor)
+	}
+	wg.Wait()
+	for _, processor := range tailor.Processors() {
+		if !tailor.CaughtUp(processor) {
+			t.Fatalf("%s isn't caught up at %d", processor.Name(), processor.NextVersion())
+		}
+	}
+
+	for _, c := range []struct {
+		processor *recordingProcessor
+		from      int64
+	}{{ahead, 200}, {genesis, 0}} {
+		if len(c.processor.versions) != int(300-c.from) {
+			t.Fatalf("%s processed %d versions, want %d", c.processor.name, len(c.processor.versions), 300-c.from)
+		}
+		seen := make(map[int64]bool)
+		for _, version := range c.processor.versions {
+			if version < c.from || seen[version] {
+				t.Fatalf("%s processed version %d again", c.processor.name, version)
+			}
+			seen[version] = true
+		}
+	}
+	for _, window := range []int{0, 50, 100, 150} {
+		if calls := client.starts[window]; calls != 1 {
+			t.Fatalf("window %d fetched %d times, want once", window, calls)
+		}
+	}
+}
+
+//orderedProcessor A `recordingProcessor` whose batches must not be split
+type orderedProcessor struct {
+	*recordingProcessor
+}
+
+func (p *orderedProcessor) Ordered() bool {
+	return true
+}
+
+func TestTailor_ProcessNextBatchRetriesFailedSlices(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	db := newTestDB(t)
+	fetcher := NewFetcher(newTestNodePool(NewNode("stub", &stubClient{ledgerVersion: 99, pageSize: 100})), 0)
+	fetcher.WindowSize = 50
+	fetcher.PollInterval = time.Millisecond
+	logConf := &logger.Config{Level: log.FatalLevel, FilePath: filepath.Join(t.TempDir(), "aptoscan")}
+	_logger, err := logger.New(logConf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tailor := NewTailor(fetcher, db, logConf, nil)
+	tailor.CacheWindowSize = 50
+	var failedOnce bool
+	flaky := &recordingProcessor{name: "flaky", db: db, logger: _logger, before: func(ctx context.Context, txs []types.Transaction) error {
+		if txs[0].Version == 0 && !failedOnce {
+			failedOnce = true
+			return errors.New("deadlock")
+		}
+		return nil
+	}}
+	var sliceSizes []int
+	ordered := &orderedProcessor{recordingProcessor: &recordingProcessor{name: "ordered", db: db, logger: _logger, before: func(ctx context.Context, txs []types.Transaction) error {
+		sliceSizes = append(sliceSizes, len(txs))
+		return nil
+	}}}
+	tailor.AddProcessor(&Processor{TransactionProcessor: flaky})
+	tailor.AddProcessor(&Processor{TransactionProcessor: ordered})
+	if _, err = tailor.SetProcessorCursors(ctx); err != nil {
+		t.Fatal(err)
+	}
+	tailor.TransactionFetcher.Start(ctx)
+
+	var orderedBatches int
+	for _, processor := range tailor.Processors() {
+		for batches := 0; processor.NextVersion() <= 99; batches++ {
+			if batches > 10 {
+				t.Fatalf("%s stuck at %d", processor.Name(), processor.NextVersion())
+			}
+			numTxs, results, err := tailor.ProcessNextBatch(ctx, processor, 2, 30)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if numTxs == 0 {
+				batches--
+				continue
+			}
+			if processor.Name() == "ordered" {
+				orderedBatches++
+			}
+			for _, result := range results {
+				if result.Err() != nil && processor.NextVersion() != 0 {
+					t.Fatalf("got next version %d after slice 0 failed, want 0", processor.NextVersion())
+				}
+			}
+		}
+	}
+
+	if !failedOnce {
+		t.Fatal("slice 0 never failed")
+	}
+	seen := make(map[int64]bool)
+	for _, version := range flaky.versions {
+		seen[version] = true
+	}
+	if len(seen) != 100 {
+		t.Fatalf("flaky processed %d distinct versions, want 100", len(seen))
+	}
+	if len(sliceSizes) != orderedBatches {
+		t.Fatalf("got ordered slices of %v versions over %d batches, want whole batches", sliceSizes, orderedBatches)
+	}
+	var cursors []types.ProcessorCursor
+	if err = db.Order("name").Find(&cursors).Error; err != nil {
+		t.Fatal(err)
+	}
+	if len(cursors) != 2 || cursors[0].MaxVersion != 99 || cursors[1].MaxVersion != 99 {
+		t.Fatalf("got cursors %+v, want both at 99", cursors)
+	}
+}
+
+func TestTailor_ProcessTransactionsIsolatesFailures(t *testing.T) {
+	ctx := context.Background()
+	db := newTestDB(t)
+	logConf := &logger.Config{Level: log.ErrorLevel, FilePath: filepath.Join(t.TempDir(), "aptoscan")}
+	_logger, err := logger.New(logConf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tailor := NewTailor(NewFetcher(newTestNodePool(), 0), db, logConf, nil)
+	healthy := &recordingProcessor{name: "healthy", db: db, logger: _logger}
+	tailor.AddProcessor(&Processor{TransactionProcessor: healthy})
+	tailor.AddProcessor(&Processor{TransactionProcessor: &recordingProcessor{name: "panicking", db: db, logger: _logger, before: func(ctx context.Context, txs []types.Transaction) error {
+		panic("boom")
+	}}})
+	tailor.AddProcessor(&Processor{TransactionProcessor: &recordingProcessor{name: "slow", db: db, logger: _logger, before: func(ctx context.Context, txs []types.Transaction) error {
+		<-ctx.Done()
+		return ctx.Err()
+	}}, Timeout: 10 * time.Millisecond})
+
+	results := tailor.ProcessTransactions(ctx, []types.Transaction{{Version: 0, Success: true}, {Version: 1, Success: true}})
+	if len(results) != 3 {
+		t.Fatalf("got %d results, want 3", len(results))
+	}
+	kinds := make(map[string]ErrorKind)
+	for _, result := range results {
+		if result.Result().StartVersion != 0 || result.Result().EndVersion != 1 {
+			t.Fatalf("got range %d-%d from %s, want 0-1", result.Result().StartVersion, result.Result().EndVersion, result.Result().Name)
+		}
+		var processingErr *ProcessingError
+		if errors.As(result.Err(), &processingErr) {
+			kinds[result.Result().Name] = processingErr.Kind
+		} else if result.Err() != nil {
+			t.Fatalf("got %v from %s, want a ProcessingError", result.Err(), result.Result().Name)
+		}
+	}
+	if _, failed := kinds["healthy"]; failed || len(healthy.versions) != 2 {
+		t.Fatalf("healthy processor failed or missed versions: %v", healthy.versions)
+	}
+	if kinds["panicking"] != ErrorKindPanic || kinds["slow"] != ErrorKindTimeout {
+		t.Fatalf("got error kinds %v", kinds)
+	}
+
+	var statuses []types.ProcessorStatus
+	if err = db.Order("name").Find(&statuses).Error; err != nil {
+		t.Fatal(err)
+	}
+	if len(statuses) != 3 {
+		t.Fatalf("got %d status rows, want 3", len(statuses))
+	}
+	for _, status := range statuses {
+		switch status.Name {
+		case "healthy":
+			if !status.Success || status.ErrorKind != "" {
+				t.Fatalf("got %+v for the healthy processor", status)
+			}
+		case "panicking":
+			if status.Success || status.ErrorKind != string(ErrorKindPanic) || !strings.Contains(status.Detail, "boom") {
+				t.Fatalf("got %+v for the panicking processor", status)
+			}
+		case "slow":
+			if status.Success || status.ErrorKind != string(ErrorKindTimeout) {
+				t.Fatalf("got %+v for the slow processor", status)
+			}
+		}
+	}
+}
+
+//filteringProcessor A `recordingProcessor` declaring a filter
+type filteringProcessor struct {
+	*recordingProcessor
+	filter *types.TransactionFilter
+}
+
+func (p *filteringProcessor) Filter() *types.TransactionFilter {
+	return p.filter
+}
+
+//failedProcessor A `recordingProcessor` handed failed transactions as well
+type failedProcessor struct {
+	*recordingProcessor
+}
+
+func (p *failedProcessor) ProcessesFailed() bool {
+	return true
+}
+
+func TestTailor_ProcessTransactionsAppliesFilters(t *testing.T) {
+	ctx := context.Background()
+	db := newTestDB(t)
+	logConf := &logger.Config{Level: log.ErrorLevel, FilePath: filepath.Join(t.TempDir(), "aptoscan")}
+	_logger, err := logger.New(logConf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tailor := NewTailor(NewFetcher(newTestNodePool(), 0), db, logConf, nil)
+	everything := &recordingProcessor{name: "everything", db: db, logger: _logger}
+	tokens := &recordingProcessor{name: "tokens", db: db, logger: _logger}
+	calls := &recordingProcessor{name: "calls", db: db, logger: _logger}
+	failed := &recordingProcessor{name: "failed", db: db, logger: _logger}
+	tailor.AddProcessor(&Processor{TransactionProcessor: everything})
+	tailor.AddProcessor(&Processor{TransactionProcessor: &failedProcessor{recordingProcessor: failed}})
+	tailor.AddProcessor(&Processor{TransactionProcessor: &filteringProcessor{recordingProcessor: tokens, filter: &types.TransactionFilter{
+		TransactionTypes:  []string{types.UserTransaction},
+		EventTypePrefixes: []string{"0x3::token::"},
+		Senders:           []string{"0xABC"},
+	}}})
+	tailor.AddProcessor(&Processor{TransactionProcessor: &filteringProcessor{recordingProcessor: calls, filter: &types.TransactionFilter{
+		EntryFunctions: []string{"0x1::coin::transfer"},
+	}}})
+
+	txs := []types.Transaction{
+		{Version: 0, Success: true, Type: types.GenesisTransaction, Events: []types.Event{{Type: "0x3::token::DepositEvent"}}},
+		{Version: 1, Success: true, Type: types.UserTransaction, Sender: "0xabc", Events: []types.Event{{Type: "0x1::coin::DepositEvent"}, {Type: "0x3::token::DepositEvent"}}},
+		{Version: 2, Success: true, Type: types.UserTransaction, Sender: "0xdef", Events: []types.Event{{Type: "0x3::token::DepositEvent"}}},
+		{Version: 3, Success: true, Type: types.UserTransaction, Sender: "0xabc", Payload: types.JSONPayload{Type: types.EntryFunctionPayload, Function: "0x1::coin::transfer"}},
+		{Version: 4, Success: true, Type: types.UserTransaction, Sender: "0xabc", Payload: types.JSONPayload{Type: types.ScriptPayload, Function: "0x1::coin::transfer"}},
+		{Version: 5, Success: false, Type: types.UserTransaction, Sender: "0xabc", Payload: types.JSONPayload{Type: types.EntryFunctionPayload, Function: "0x1::coin::transfer"}},
+	}
+	for _, result := range tailor.ProcessTransactions(ctx, txs) {
+		if result.Err() != nil {
+			t.Fatal(result.Err())
+		}
+		if result.Result().StartVersion != 0 || result.Result().EndVersion != 5 {
+			t.Fatalf("got range %d-%d from %s, want the whole batch", result.Result().StartVersion, result.Result().EndVersion, result.Result().Name)
+		}
+	}
+	for _, c := range []struct {
+		processor *recordingProcessor
+		want      []int64
+	}{{everything, []int64{0, 1, 2, 3, 4}}, {failed, []int64{0, 1, 2, 3, 4, 5}}, {tokens, []int64{1}}, {calls, []int64{3}}} {
+		if len(c.processor.versions) != len(c.want) {
+			t.Fatalf("%s got versions %v, want %v", c.processor.name, c.processor.versions, c.want)
+		}
+		for i, version := range c.want {
+			if c.processor.versions[i] != version {
+				t.Fatalf("%s got versions %v, want %v", c.processor.name, c.processor.versions, c.want)
+			}
+		}
+	}
+}
+
+func TestTailor_HandlePreviousErrorsReplaysFailedRanges(t *testing.T) {
+	ctx := context.Background()
+	db := newTestDB(t)
+	logConf := &logger.Config{Level: log.FatalLevel, FilePath: filepath.Join(t.TempDir(), "aptoscan")}
+	_logger, err := logger.New(logConf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = db.Create(&types.ProcessorCursor{Name: "replayed", ChainId: 4, MaxVersion: 99}).Error; err != nil {
+		t.Fatal(err)
+	}
+	for _, status := range []types.ProcessorStatus{
+		{Name: "replayed", StartVersion: 0, EndVersion: 49, Success: true},
+		{Name: "replayed", StartVersion: 50, EndVersion: 59, Detail: "deadlock", ErrorKind: string(ErrorKindFailed)},
+		{Name: "replayed", StartVersion: 60, EndVersion: 79, Success: true},
+		{Name: "replayed", StartVersion: 80, EndVersion: 84, Detail: "malformed", ErrorKind: string(ErrorKindFailed)},
+		{Name: "replayed", StartVersion: 85, EndVersion: 99, Success: true},
+	} {
+		if err = db.Create(&status).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+	replayed := &recordingProcessor{name: "replayed", db: db, logger: _logger, before: func(ctx context.Context, txs []types.Transaction) error {
+		if txs[0].Version >= 80 {
+			return errors.New("still malformed")
+		}
+		return nil
+	}}
+	fetcher := NewFetcher(newTestNodePool(NewNode("stub", &stubClient{ledgerVersion: 99, pageSize: 100})), 0)
+	tailor := NewTailor(fetcher, db, logConf, nil)
+	processor := &Processor{TransactionProcessor: replayed}
+	tailor.AddProcessor(processor)
+
+	if err = tailor.HandlePreviousErrors(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if len(replayed.versions) != 10 || replayed.versions[0] != 50 || replayed.versions[9] != 59 {
+		t.Fatalf("got versions %v processed, want 50 to 59", replayed.versions)
+	}
+	var status types.ProcessorStatus
+	if err = db.Where("name = ? AND start_version = ? AND end_version = ?", "replayed", 50, 59).First(&status).Error; err != nil {
+		t.Fatal(err)
+	}
+	if !status.Success || status.Detail != "" {
+		t.Fatalf("got status %+v, want 50-59 marked successful", status)
+	}
+	failed, err := processor.getFailedRanges(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(failed) != 1 || failed[0].StartVersion != 80 || failed[0].EndVersion != 84 {
+		t.Fatalf("got failed ranges %+v, want only 80-84 which failed again", failed)
+	}
+	lastError, err := processor.getLastError(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lastError == nil || lastError.StartVersion != 80 || !strings.Contains(lastError.Detail, "still malformed") {
+		t.Fatalf("got last error %+v, want 80-84 failing again", lastError)
+	}
+}
+
+func TestTailor_HandlePreviousErrorsReportsOrderedGaps(t *testing.T) {
+	ctx := context.Background()
+	db := newTestDB(t)
+	logConf := &logger.Config{Level: log.FatalLevel, FilePath: filepath.Join(t.TempDir(), "aptoscan")}
+	_logger, err := logger.New(logConf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fetcher := NewFetcher(newTestNodePool(NewNode("stub", &stubClient{ledgerVersion: 99, pageSize: 100})), 0)
+	tailor := NewTailor(fetcher, db, logConf, nil)
+	unordered := &recordingProcessor{name: "unordered", db: db, logger: _logger}
+	ordered := &orderedProcessor{recordingProcessor: &recordingProcessor{name: "ordered", db: db, logger: _logger}}
+	for _, processor := range []TransactionProcessor{unordered, ordered} {
+		tailor.AddProcessor(&Processor{TransactionProcessor: processor})
+		if err = db.Create(&types.ProcessorCursor{Name: processor.Name(), ChainId: 4, MaxVersion: 29}).Error; err != nil {
+			t.Fatal(err)
+		}
+		for _, status := range []types.ProcessorStatus{
+			{Name: processor.Name(), StartVersion: 0, EndVersion: 9, Success: true},
+			{Name: processor.Name(), StartVersion: 20, EndVersion: 29, Success: true},
+		} {
+			if err = db.Create(&status).Error; err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	if err = tailor.HandlePreviousErrors(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if len(unordered.versions) != 10 || unordered.versions[0] != 10 || unordered.versions[9] != 19 {
+		t.Fatalf("got versions %v replayed, want the gap 10 to 19", unordered.versions)
+	}
+	if len(ordered.versions) != 0 {
+		t.Fatalf("got versions %v replayed behind an ordered processor's max version, want none", ordered.versions)
+	}
+	reports, err := tailor.GapReports(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(reports) != 2 || !reports[0].Complete() || reports[0].Ordered {
+		t.Fatalf("got %+v, want the unordered processor's gap filled", reports)
+	}
+	if gaps := reports[1].Gaps; !reports[1].Ordered || len(gaps) != 1 || gaps[0] != (VersionRange{10, 19}) {
+		t.Fatalf("got %+v, want the ordered processor's gap reported", reports[1])
+	}
+}
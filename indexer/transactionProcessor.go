@@ -2,78 +2,288 @@ package indexer
 
 import (
 	"apotscan/logger"
+	"apotscan/metrics"
 	"apotscan/types"
+	"context"
+	"errors"
 	"fmt"
 	"github.com/go-redis/redis/v8"
 	log "github.com/sirupsen/logrus"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 	"math"
+	"runtime/debug"
 	"strconv"
+	"sync"
+	"time"
 )
 
+//statusWriteTimeout Status rows are written even when the batch's context is done, so an interrupted batch
+//is recorded as failed and replayed on the next start instead of being left as started
+const statusWriteTimeout = 10 * time.Second
+
 type TransactionProcessor interface {
 	Name() string
 	ChainId() uint8
-	ProcessTransactions(transactions []types.Transaction, startVersion, endVersion int64) (*types.ProcessResult, error)
+	//ProcessTransactions Every write has to go through `db`, the transaction the status row and the cursor are written in
+	ProcessTransactions(ctx context.Context, db *gorm.DB, transactions []types.Transaction, startVersion, endVersion int64) (*types.ProcessResult, error)
 	GetDB() *gorm.DB
+	//GetRedis Optional, nil when redis isn't configured
 	GetRedis() *redis.Client
 	GetLogger() *logger.Logger
 }
+
+//FilteringProcessor A `TransactionProcessor` which only wants some transactions, it is handed nothing else.
+//Versions filtered out still count as processed
+type FilteringProcessor interface {
+	Filter() *types.TransactionFilter
+}
+
+//OrderedProcessor A `TransactionProcessor` whose writes depend on transactions being applied in version order when
+//`Ordered` is true, e.g. read-modify-writes guarded by the version which last wrote a row. Its batches are never split
+//across goroutines
+type OrderedProcessor interface {
+	Ordered() bool
+}
+
+//FailedTransactionsProcessor A `TransactionProcessor` which is handed failed transactions as well when
+//`ProcessesFailed` is true, other processors only see successful ones
+type FailedTransactionsProcessor interface {
+	ProcessesFailed() bool
+}
+
 type Processor struct {
 	TransactionProcessor
+	//Timeout How long a single call of the processor, its writes included, may take. No deadline when 0.
+	//The deadline is set on the context and the transaction, a processor which ignores both is only failed once it returns
+	Timeout time.Duration
+	//DeadLetter Whether a failed batch is re-run one transaction at a time, transactions which still fail are
+	//written to `dead_letters` and skipped instead of failing the whole range.
+	//It is ignored for an `OrderedProcessor`, which skips changes older than its rows, so a transaction retried
+	//after later versions would have none of its changes applied
+	DeadLetter bool
+	//watermark Only set while tailing, replays of old versions never move the max version
+	watermark  *Watermark
+	throughput throughput
+	//nextVersion The next version this processor fetches, each processor advances on its own
+	nextVersion int64
+	mu          sync.Mutex
+}
+
+func (p *Processor) getWatermark() *Watermark {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.watermark
+}
+
+func (p *Processor) setWatermark(watermark *Watermark) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.watermark = watermark
+}
+
+//NextVersion The next version the processor is going to fetch while tailing
+func (p *Processor) NextVersion() int64 {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.nextVersion
+}
+
+func (p *Processor) setNextVersion(version int64) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.nextVersion = version
 }
 
 //getMaxVersion Gets the highest version for this `TransactionProcessor` from the DB
-//This is so we know where to resume from on restarts
-func (p *Processor) getMaxVersion() (int64, error) {
+//This is so we know where to resume from on restarts, -1 means nothing has been processed yet.
+//A processor without a cursor row picks up the max version it used to keep in redis, if there is one
+func (p *Processor) getMaxVersion(ctx context.Context) (int64, error) {
+	var cursors []types.ProcessorCursor
+	if err := p.GetDB().WithContext(ctx).
+		Where("name = ? AND chain_id = ?", p.Name(), p.ChainId()).
+		Limit(1).
+		Find(&cursors).Error; err != nil {
+		return 0, err
+	}
+	if len(cursors) > 0 {
+		return cursors[0].MaxVersion, nil
+	}
+	return p.getRedisMaxVersion(ctx)
+}
+
+//getRedisMaxVersion Gets the max version kept in redis before cursors moved into the DB, -1 if there is none
+func (p *Processor) getRedisMaxVersion(ctx context.Context) (int64, error) {
+	if p.GetRedis() == nil {
+		return -1, nil
+	}
 	key := fmt.Sprintf(types.MaxVersionKey, p.Name(), p.ChainId())
 	result, err := p.GetRedis().Get(ctx, key).Result()
-	if err != nil {
+	if err == redis.Nil {
+		return -1, nil
+	} else if err != nil {
 		return 0, err
 	}
 	latestVersion, err := strconv.ParseInt(result, 10, 64)
 	if err != nil {
-		return math.MaxUint64, err
+		return math.MaxInt64, err
 	}
 	return latestVersion, nil
 }
 
-//todo: 到时候还要重新看啥时候setMaxVersion合适
-func (p *Processor) setMaxVersion(version int64) error {
-	currentMaxVersion, err := p.getMaxVersion()
-	if err != nil {
-		return err
-	}
-	if currentMaxVersion >= version {
-		return nil
+//setMaxVersion Persists the max version through `db`, it is only called with a version the watermark reaches once every lower version has been committed.
+//The cursor never moves backwards, even if transactions commit out of order
+func (p *Processor) setMaxVersion(db *gorm.DB, version int64) error {
+	result := db.Model(&types.ProcessorCursor{}).
+		Where("name = ? AND chain_id = ? AND max_version < ?", p.Name(), p.ChainId(), version).
+		Update("max_version", version)
+	if result.Error != nil || result.RowsAffected > 0 {
+		return result.Error
 	}
-	versionStr := strconv.FormatInt(version, 64)
-	return p.GetRedis().Set(ctx, fmt.Sprintf(types.MaxVersionKey, p.Name(), p.ChainId()), versionStr, -1).Err()
+	return db.Clauses(clause.OnConflict{DoNothing: true}).Create(&types.ProcessorCursor{
+		Name:       p.Name(),
+		ChainId:    p.ChainId(),
+		MaxVersion: version,
+	}).Error
 }
 
-//processTransactionsWithStatus This is a helper method, tying together the other helper methods to allow tracking status in the DB
-func (p *Processor) processTransactionsWithStatus(txns []types.Transaction) (*types.ProcessResult, error) {
+//processTransactionsWithStatus This is a helper method, tying together the other helper methods to allow tracking status in the DB.
+//`startVersion` and `endVersion` cover the whole fetched range, so versions which were filtered out, failed or not matching
+//the processor's filter, still count as processed.
+//The processor's writes, its status row and its cursor are committed in a single transaction.
+//Nothing is written if `ctx` is already done, once started the outcome is always recorded
+func (p *Processor) processTransactionsWithStatus(ctx context.Context, txns []types.Transaction, startVersion, endVersion int64) (result *types.ProcessResult, err error) {
+	if err = ctx.Err(); err != nil {
+		return nil, err
+	}
+	metrics.ProcessorInvocations.WithLabelValues(p.Name()).Inc()
+	defer func(started time.Time) {
+		p.observe(started, err)
+	}(time.Now())
+	txns = p.filter(txns)
 	if len(txns) == 0 {
-		p.GetLogger().Warning("must provide at least one transaction to this funtion")
+		result = types.NewProcessResult(p.Name(), startVersion, endVersion)
+		if err = p.GetDB().WithContext(ctx).Transaction(func(db *gorm.DB) error {
+			return p.updateStatus(db, result, nil)
+		}); err != nil {
+			return nil, err
+		}
+		p.commitWatermark(result)
+		return result, nil
 	}
-	startVersion := txns[0].Version
-	endVersion := txns[len(txns)-1].Version
-	//todo: PROCESSOR_INVOCATIONS
-	if err := p.markVersionStarted(startVersion, endVersion); err != nil {
+	if err = p.markVersionStarted(ctx, startVersion, endVersion); err != nil {
 		return nil, err
 	}
-	result, err := p.ProcessTransactions(txns, startVersion, endVersion)
+	callCtx, cancel := p.callContext(ctx)
+	defer cancel()
+	err = p.GetDB().WithContext(callCtx).Transaction(func(db *gorm.DB) (err error) {
+		if result, err = p.invokeBatch(callCtx, db, txns, startVersion, endVersion); err != nil {
+			return err
+		}
+		return p.updateStatus(db, result, nil)
+	})
 	if err != nil {
+		err = p.processingError(ctx, callCtx, err, startVersion, endVersion)
+		statusCtx, cancel := context.WithTimeout(context.Background(), statusWriteTimeout)
+		defer cancel()
+		if statusErr := p.updateStatus(p.GetDB().WithContext(statusCtx), types.NewProcessResult(p.Name(), startVersion, endVersion), err); statusErr != nil {
+			p.GetLogger().WithFields(log.Fields{
+				"name":          p.Name(),
+				"start version": startVersion,
+				"end version":   endVersion,
+				"error":         statusErr,
+			}).Error("can not mark processing versions failed")
+		}
 		return nil, err
 	}
-	if err = p.updateStatus(result, err); err != nil {
-		return nil, err
-	}
+	p.commitWatermark(result)
 	return result, nil
 }
 
+func (p *Processor) ordered() bool {
+	ordered, ok := p.TransactionProcessor.(OrderedProcessor)
+	return ok && ordered.Ordered()
+}
+
+//filter Keeps the transactions the processor's filter matches, every transaction if it doesn't declare one.
+//Failed transactions are dropped first unless the processor wants them
+func (p *Processor) filter(txns []types.Transaction) []types.Transaction {
+	if failed, ok := p.TransactionProcessor.(FailedTransactionsProcessor); !ok || !failed.ProcessesFailed() {
+		txns = successfulTransactions(txns)
+	}
+	filtering, ok := p.TransactionProcessor.(FilteringProcessor)
+	if !ok || filtering.Filter() == nil {
+		return txns
+	}
+	return filtering.Filter().Apply(txns)
+}
+
+//callContext The context of a single call, it has the processor's deadline if there is one
+func (p *Processor) callContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	if p.Timeout > 0 {
+		return context.WithTimeout(ctx, p.Timeout)
+	}
+	return context.WithCancel(ctx)
+}
+
+//invoke Calls the processor, a panic is recovered and returned as a `ProcessingError` so it only fails this range
+func (p *Processor) invoke(ctx context.Context, db *gorm.DB, txns []types.Transaction, startVersion, endVersion int64) (result *types.ProcessResult, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			result, err = nil, &ProcessingError{
+				Processor:    p.Name(),
+				StartVersion: startVersion,
+				EndVersion:   endVersion,
+				Kind:         ErrorKindPanic,
+				Err:          fmt.Errorf("panic: %v", r),
+				Stack:        string(debug.Stack()),
+			}
+		}
+	}()
+	result, err = p.ProcessTransactions(ctx, db, txns, startVersion, endVersion)
+	if err == nil && result == nil {
+		err = errors.New("no result returned")
+	}
+	return result, err
+}
+
+//processingError Wraps `err` in a `ProcessingError` unless it already is one.
+//It is a timeout if the processor's own deadline passed while `ctx` wasn't done
+func (p *Processor) processingError(ctx, callCtx context.Context, err error, startVersion, endVersion int64) error {
+	var processingErr *ProcessingError
+	if errors.As(err, &processingErr) {
+		return err
+	}
+	kind := ErrorKindFailed
+	if ctx.Err() == nil && errors.Is(callCtx.Err(), context.DeadlineExceeded) {
+		kind = ErrorKindTimeout
+	}
+	return &ProcessingError{
+		Processor:    p.Name(),
+		StartVersion: startVersion,
+		EndVersion:   endVersion,
+		Kind:         kind,
+		Err:          err,
+	}
+}
+
+//observe Records the outcome of a range started at `started` in the metrics,
+//the committed version is only exported once the transaction moving it has been committed
+func (p *Processor) observe(started time.Time, err error) {
+	metrics.ProcessorDuration.WithLabelValues(p.Name()).Observe(time.Since(started).Seconds())
+	if err != nil {
+		metrics.ProcessorErrors.WithLabelValues(p.Name()).Inc()
+		return
+	}
+	metrics.ProcessorSuccesses.WithLabelValues(p.Name()).Inc()
+	if watermark := p.getWatermark(); watermark != nil {
+		metrics.SetProcessorVersion(p.Name(), watermark.Version())
+		p.throughput.observe(watermark.Version(), time.Now())
+	}
+}
+
 //markVersionStarted Writes that a version has been started for this `TransactionProcessor` to the DB
-func (p *Processor) markVersionStarted(startVersion, endVersion int64) error {
+func (p *Processor) markVersionStarted(ctx context.Context, startVersion, endVersion int64) error {
 	p.GetLogger().WithFields(log.Fields{
 		"name":          p.Name(),
 		"start version": startVersion,
@@ -86,18 +296,18 @@ func (p *Processor) markVersionStarted(startVersion, endVersion int64) error {
 		Success:      false,
 		Detail:       "",
 	}}
-	return p.applyProcessorStatus(psms)
+	return p.applyProcessorStatus(p.GetDB().WithContext(ctx), psms)
 }
 
 //applyProcessorStatus Actually performs the write for a `ProcessorStatusModel` change set
-func (p *Processor) applyProcessorStatus(psms []types.ProcessorStatus) error {
-	db := p.GetDB()
+func (p *Processor) applyProcessorStatus(db *gorm.DB, psms []types.ProcessorStatus) error {
 	return db.Save(&psms).Error
 }
 
-//updateStatus Writes that a version has been completed successfully for this `TransactionProcessor` to the DB
-func (p *Processor) updateStatus(result *types.ProcessResult, err error) error {
-	//todo: PROCESSOR_SUCCESSES, PROCESSOR_ERRORS
+//updateStatus Writes that a version has been completed successfully for this `TransactionProcessor` to the DB,
+//through `db` so it is part of the processor's transaction. A success moving the watermark moves the cursor as well,
+//the watermark itself only moves once the transaction has been committed
+func (p *Processor) updateStatus(db *gorm.DB, result *types.ProcessResult, err error) error {
 	p.GetLogger().WithFields(log.Fields{
 		"name":          p.Name(),
 		"start version": result.StartVersion,
@@ -113,27 +323,85 @@ func (p *Processor) updateStatus(result *types.ProcessResult, err error) error {
 			Success:      true,
 			Detail:       "",
 		}}
-		if err := p.setMaxVersion(result.EndVersion); err != nil {
-			return err
-		}
 	} else {
 		//psms = types.ProcessorStatusFromVersions(p.Name(), result.StartVersion, result.EndVersion, false, result.Error.Error())
+		detail, kind := err.Error(), ErrorKindFailed
+		var processingErr *ProcessingError
+		if errors.As(err, &processingErr) {
+			detail, kind = processingErr.Detail(), processingErr.Kind
+		}
 		psms = []types.ProcessorStatus{{
 			Name:         p.Name(),
 			StartVersion: result.StartVersion,
 			EndVersion:   result.EndVersion,
 			Success:      false,
-			Detail:       err.Error(),
+			Detail:       detail,
+			ErrorKind:    string(kind),
 		}}
 	}
-	return p.applyProcessorStatus(psms)
+	if statusErr := p.applyProcessorStatus(db, psms); statusErr != nil {
+		return statusErr
+	}
+	if watermark := p.getWatermark(); err == nil && watermark != nil {
+		if version, ok := watermark.Reach(result.StartVersion, result.EndVersion); ok {
+			return p.setMaxVersion(db, version)
+		}
+	}
+	return nil
 }
 
-func (p *Processor) getErrorVersion() ([]int64, error) {
-	db := p.GetDB()
-	var statuses []int64
-	if err := db.Select("version").Where("success = ?", false).Scan(&statuses).Error; err != nil {
+//commitWatermark Moves the watermark past a range once the transaction writing it, its status and the cursor has been committed
+func (p *Processor) commitWatermark(result *types.ProcessResult) {
+	if watermark := p.getWatermark(); watermark != nil {
+		watermark.Commit(result.StartVersion, result.EndVersion)
+	}
+}
+
+//getFailedRanges Gets every version range of this `TransactionProcessor` which has a `success=false` row
+//in the `processor_statuses` table and has never succeeded since
+func (p *Processor) getFailedRanges(ctx context.Context) ([]types.ProcessorStatus, error) {
+	db := p.GetDB().WithContext(ctx)
+	var statuses []types.ProcessorStatus
+	succeeded := db.Model(&types.ProcessorStatus{}).Select("1").
+		Where("name = failed.name AND start_version = failed.start_version AND end_version = failed.end_version AND success = ?", true)
+	if err := db.Table("processor_statuses AS failed").
+		Select("failed.name, failed.start_version, failed.end_version").
+		Where("failed.name = ? AND failed.success = ?", p.Name(), false).
+		Where("NOT EXISTS (?)", succeeded).
+		Group("failed.name, failed.start_version, failed.end_version").
+		Order("failed.start_version").
+		Find(&statuses).Error; err != nil {
 		return nil, err
 	}
 	return statuses, nil
 }
+
+//getLastError Gets the latest range of this `TransactionProcessor` which failed and hasn't succeeded since, nil if there is none.
+//A range counts as succeeded once `success=true` rows cover it, even if it was replayed under a different split.
+//Ranges which are only marked started have no detail and are left out.
+//It is a single query: a range isn't covered if its start version isn't, or if a success row ends inside it
+//and the version right after it isn't covered
+func (p *Processor) getLastError(ctx context.Context) (*types.ProcessorStatus, error) {
+	db := p.GetDB().WithContext(ctx)
+	covering := func(version string) *gorm.DB {
+		return db.Model(&types.ProcessorStatus{}).Select("1").
+			Where("name = failed.name AND success = ? AND start_version <= "+version+" AND end_version >= "+version, true)
+	}
+	uncoveredAfter := db.Table("processor_statuses AS ended").Select("1").
+		Where("ended.name = failed.name AND ended.success = ?", true).
+		Where("ended.end_version >= failed.start_version AND ended.end_version < failed.end_version").
+		Where("NOT EXISTS (?)", covering("ended.end_version + 1"))
+	var failed []types.ProcessorStatus
+	if err := db.Table("processor_statuses AS failed").
+		Where("failed.name = ? AND failed.success = ? AND failed.detail <> ?", p.Name(), false, "").
+		Where("(NOT EXISTS (?) OR EXISTS (?))", covering("failed.start_version"), uncoveredAfter).
+		Order("failed.updated_at DESC").
+		Limit(1).
+		Find(&failed).Error; err != nil {
+		return nil, err
+	}
+	if len(failed) == 0 {
+		return nil, nil
+	}
+	return &failed[0], nil
+}
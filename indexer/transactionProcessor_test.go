@@ -0,0 +1,60 @@
+package indexer
+
+import (
+	"apotscan/logger"
+	"apotscan/types"
+	"context"
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestProcessor_GetLastError(t *testing.T) {
+	ctx := context.Background()
+	db := newTestDB(t)
+	_logger, err := logger.New(&logger.Config{Level: log.FatalLevel, FilePath: filepath.Join(t.TempDir(), "aptoscan")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	status := func(start, end int64) types.ProcessorStatus {
+		return types.ProcessorStatus{StartVersion: start, EndVersion: end, Success: true}
+	}
+	failed := func(start, end int64, detail string) types.ProcessorStatus {
+		return types.ProcessorStatus{StartVersion: start, EndVersion: end, Detail: detail}
+	}
+	cases := []struct {
+		name     string
+		statuses []types.ProcessorStatus
+		want     int64
+	}{
+		{"no failures", []types.ProcessorStatus{status(0, 9)}, -1},
+		{"never replayed", []types.ProcessorStatus{status(0, 9), failed(10, 19, "boom")}, 10},
+		{"replayed in one range", []types.ProcessorStatus{failed(0, 9, "boom"), status(0, 19)}, -1},
+		{"replayed under a different split", []types.ProcessorStatus{failed(10, 19, "boom"), status(5, 14), status(15, 16), status(12, 25)}, -1},
+		{"start not replayed", []types.ProcessorStatus{failed(10, 19, "boom"), status(11, 19)}, 10},
+		{"hole in the replay", []types.ProcessorStatus{failed(10, 19, "boom"), status(0, 14), status(16, 19)}, 10},
+		{"only started", []types.ProcessorStatus{failed(10, 19, "")}, -1},
+		{"latest", []types.ProcessorStatus{failed(10, 19, "boom"), failed(30, 39, "boom")}, 30},
+		{"latest uncovered", []types.ProcessorStatus{failed(10, 19, "boom"), failed(30, 39, "boom"), status(30, 34), status(35, 39)}, 10},
+	}
+	for i, c := range cases {
+		processor := &Processor{TransactionProcessor: &recordingProcessor{name: fmt.Sprintf("errors%d", i), db: db, logger: _logger}}
+		for j, status := range c.statuses {
+			status.Name = processor.Name()
+			updatedAt := time.Unix(int64(j), 0)
+			status.UpdatedAt = &updatedAt
+			if err = db.Create(&status).Error; err != nil {
+				t.Fatal(err)
+			}
+		}
+		lastError, err := processor.getLastError(ctx)
+		if err != nil {
+			t.Fatalf("%s: %v", c.name, err)
+		}
+		if c.want < 0 && lastError != nil || c.want >= 0 && (lastError == nil || lastError.StartVersion != c.want) {
+			t.Errorf("%s: got %+v, want the range from %d", c.name, lastError, c.want)
+		}
+	}
+}
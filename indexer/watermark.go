@@ -0,0 +1,88 @@
+package indexer
+
+import "sync"
+
+//Watermark Tracks the highest version below which every range of a processor has succeeded.
+//Ranges processed in parallel may finish out of order, they are held back until the ranges before them are done
+type Watermark struct {
+	mu      sync.Mutex
+	version int64
+	pending map[int64]int64
+}
+
+//NewWatermark `version` is the last version known to be processed, -1 if nothing has been
+func NewWatermark(version int64) *Watermark {
+	return &Watermark{
+		version: version,
+		pending: make(map[int64]int64),
+	}
+}
+
+//Version The highest version below which every range has succeeded
+func (w *Watermark) Version() int64 {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.version
+}
+
+//Reach The version the watermark moves to once the versions from `startVersion` to `endVersion` are committed,
+//counting only ranges already committed. False if committing the range doesn't move it.
+//Processors persist this version in the transaction writing the range, a transaction failing to commit leaves the watermark as it was
+func (w *Watermark) Reach(startVersion, endVersion int64) (int64, bool) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	version, _, ok := w.reach(startVersion, endVersion)
+	return version, ok
+}
+
+//Commit Marks the versions from `startVersion` to `endVersion` as succeeded, it is only called once the transaction
+//writing them has been committed. A range finishing before the ranges below it is held back until they are committed
+func (w *Watermark) Commit(startVersion, endVersion int64) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	version, merged, ok := w.reach(startVersion, endVersion)
+	if !ok {
+		if startVersion > w.version+1 {
+			if end, ok := w.pending[startVersion]; !ok || end < endVersion {
+				w.pending[startVersion] = endVersion
+			}
+		}
+		return
+	}
+	for _, start := range merged {
+		delete(w.pending, start)
+	}
+	w.version = version
+}
+
+//reach Merges the range with the pending ranges it joins up with, the watermark must be locked
+func (w *Watermark) reach(startVersion, endVersion int64) (int64, []int64, bool) {
+	if startVersion > w.version+1 || endVersion <= w.version {
+		return w.version, nil, false
+	}
+	version := endVersion
+	var merged []int64
+	for advanced := true; advanced; {
+		advanced = false
+		for start, end := range w.pending {
+			if start > version+1 || containsVersion(merged, start) {
+				continue
+			}
+			merged = append(merged, start)
+			if end > version {
+				version = end
+			}
+			advanced = true
+		}
+	}
+	return version, merged, true
+}
+
+func containsVersion(versions []int64, version int64) bool {
+	for _, v := range versions {
+		if v == version {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,68 @@
+package indexer
+
+import (
+	"testing"
+)
+
+func TestWatermark_Commit(t *testing.T) {
+	var persisted []int64
+	w := NewWatermark(99)
+
+	steps := []struct {
+		start, end int64
+		version    int64
+	}{
+		{200, 299, 99},  // finished before 100-199, held back
+		{400, 499, 99},  // 300-399 still missing
+		{100, 199, 299}, // fills the hole up to 299
+		{50, 120, 299},  // already committed
+		{300, 399, 499},
+		{500, 599, 599},
+	}
+	for _, step := range steps {
+		if version, ok := w.Reach(step.start, step.end); ok {
+			persisted = append(persisted, version)
+		}
+		w.Commit(step.start, step.end)
+		if w.Version() != step.version {
+			t.Fatalf("after %d-%d got version %d, want %d", step.start, step.end, w.Version(), step.version)
+		}
+	}
+	want := []int64{299, 499, 599}
+	if len(persisted) != len(want) {
+		t.Fatalf("persisted %v, want %v", persisted, want)
+	}
+	for i := range want {
+		if persisted[i] != want[i] {
+			t.Fatalf("persisted %v, want %v", persisted, want)
+		}
+	}
+}
+
+func TestWatermark_ReachLeavesVersionUntilCommitted(t *testing.T) {
+	w := NewWatermark(-1)
+	w.Commit(10, 19)
+	if version, ok := w.Reach(0, 9); !ok || version != 19 {
+		t.Fatalf("got %d, %v, want 19 joining the committed 10-19", version, ok)
+	}
+	// 0-9 fails to commit, later ranges must not count it
+	if w.Version() != -1 {
+		t.Fatalf("got version %d, want -1 until committed", w.Version())
+	}
+	if _, ok := w.Reach(20, 29); ok {
+		t.Fatal("20-29 reached past the uncommitted 0-9")
+	}
+	w.Commit(20, 29)
+	if w.Version() != -1 {
+		t.Fatalf("got version %d after committing 20-29, want -1", w.Version())
+	}
+
+	// retried
+	if version, ok := w.Reach(0, 9); !ok || version != 29 {
+		t.Fatalf("got %d, %v, want 29", version, ok)
+	}
+	w.Commit(0, 9)
+	if w.Version() != 29 {
+		t.Fatalf("got version %d, want 29", w.Version())
+	}
+}
@@ -0,0 +1,49 @@
+package metrics
+
+import (
+	"gorm.io/gorm"
+	"time"
+)
+
+const startedKey = "metrics:started"
+
+//GormPlugin Times every create, update and delete statement into `DBWriteDuration`, install it with `db.Use`
+type GormPlugin struct{}
+
+func (GormPlugin) Name() string {
+	return "metrics"
+}
+
+func (GormPlugin) Initialize(db *gorm.DB) error {
+	callbacks := db.Callback()
+	if err := callbacks.Create().Before("gorm:create").Register("metrics:before_create", start); err != nil {
+		return err
+	}
+	if err := callbacks.Create().After("gorm:create").Register("metrics:after_create", observe("create")); err != nil {
+		return err
+	}
+	if err := callbacks.Update().Before("gorm:update").Register("metrics:before_update", start); err != nil {
+		return err
+	}
+	if err := callbacks.Update().After("gorm:update").Register("metrics:after_update", observe("update")); err != nil {
+		return err
+	}
+	if err := callbacks.Delete().Before("gorm:delete").Register("metrics:before_delete", start); err != nil {
+		return err
+	}
+	return callbacks.Delete().After("gorm:delete").Register("metrics:after_delete", observe("delete"))
+}
+
+func start(db *gorm.DB) {
+	db.InstanceSet(startedKey, time.Now())
+}
+
+func observe(operation string) func(db *gorm.DB) {
+	return func(db *gorm.DB) {
+		started, ok := db.InstanceGet(startedKey)
+		if !ok {
+			return
+		}
+		DBWriteDuration.WithLabelValues(db.Statement.Table, operation).Observe(time.Since(started.(time.Time)).Seconds())
+	}
+}
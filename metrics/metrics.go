@@ -0,0 +1,138 @@
+//Package metrics Prometheus metrics of the indexer, served on /metrics by `Handler`
+package metrics
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"net/http"
+	"sync"
+)
+
+const namespace = "aptoscan"
+
+var (
+	ProcessorInvocations = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "processor_invocations_total",
+		Help:      "Number of version ranges handed to a processor.",
+	}, []string{"processor"})
+	ProcessorSuccesses = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "processor_successes_total",
+		Help:      "Number of version ranges a processor has committed.",
+	}, []string{"processor"})
+	ProcessorErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "processor_errors_total",
+		Help:      "Number of version ranges a processor has failed.",
+	}, []string{"processor"})
+	ProcessorDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "processor_duration_seconds",
+		Help:      "How long a processor takes for a version range, writes included.",
+		Buckets:   prometheus.ExponentialBuckets(0.005, 2, 14),
+	}, []string{"processor"})
+	ProcessorVersion = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "processor_version",
+		Help:      "Highest version below which a processor has committed every version.",
+	}, []string{"processor"})
+	ProcessorLag = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "processor_lag_versions",
+		Help:      "Versions between the latest ledger version seen on the fullnode and a processor's committed version.",
+	}, []string{"processor"})
+	DeadLetters = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "dead_letters_total",
+		Help:      "Number of transactions a processor skipped into the dead-letter table.",
+	}, []string{"processor"})
+
+	DBWriteDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "db_write_duration_seconds",
+		Help:      "How long single write statements take, by table and operation.",
+		Buckets:   prometheus.ExponentialBuckets(0.001, 2, 15),
+	}, []string{"table", "operation"})
+
+	FetchDuration = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "fetch_duration_seconds",
+		Help:      "How long fetching transactions from the fullnodes takes, retries included.",
+		Buckets:   prometheus.ExponentialBuckets(0.01, 2, 12),
+	})
+	FetchErrors = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "fetch_errors_total",
+		Help:      "Number of transaction fetches which failed after every retry.",
+	})
+	BatchSize = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "batch_size",
+		Help:      "Number of transactions in a fetched batch.",
+		Buckets:   prometheus.ExponentialBuckets(1, 2, 12),
+	})
+	LedgerVersion = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "ledger_version",
+		Help:      "Latest ledger version seen on the fullnode.",
+	})
+)
+
+var registry = prometheus.NewRegistry()
+
+func init() {
+	registry.MustRegister(
+		prometheus.NewGoCollector(),
+		prometheus.NewProcessCollector(prometheus.ProcessCollectorOpts{}),
+		ProcessorInvocations, ProcessorSuccesses, ProcessorErrors, ProcessorDuration, ProcessorVersion, ProcessorLag, DeadLetters,
+		DBWriteDuration, FetchDuration, FetchErrors, BatchSize, LedgerVersion,
+	)
+}
+
+//Handler Serves every metric in the Prometheus text format
+func Handler() http.Handler {
+	return promhttp.HandlerFor(registry, promhttp.HandlerOpts{})
+}
+
+//versions The latest ledger version and every processor's version, kept to compute the lag whenever either moves
+var versions = struct {
+	sync.Mutex
+	ledger     int64
+	processors map[string]int64
+}{
+	ledger:     -1,
+	processors: make(map[string]int64),
+}
+
+//SetLedgerVersion Records the latest ledger version seen on the fullnode and updates every processor's lag
+func SetLedgerVersion(version int64) {
+	versions.Lock()
+	defer versions.Unlock()
+	versions.ledger = version
+	LedgerVersion.Set(float64(version))
+	for processor, processorVersion := range versions.processors {
+		setLag(processor, processorVersion)
+	}
+}
+
+//SetProcessorVersion Records the version a processor has committed up to and updates its lag
+func SetProcessorVersion(processor string, version int64) {
+	versions.Lock()
+	defer versions.Unlock()
+	versions.processors[processor] = version
+	ProcessorVersion.WithLabelValues(processor).Set(float64(version))
+	setLag(processor, version)
+}
+
+//setLag The lag is only known once the ledger version is, `versions` has to be locked
+func setLag(processor string, version int64) {
+	if versions.ledger < 0 {
+		return
+	}
+	lag := versions.ledger - version
+	if lag < 0 {
+		lag = 0
+	}
+	ProcessorLag.WithLabelValues(processor).Set(float64(lag))
+}
@@ -0,0 +1,65 @@
+package metrics
+
+import (
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestSetVersions_UpdatesLag(t *testing.T) {
+	SetProcessorVersion("lagging", 40)
+	if count := testutil.CollectAndCount(ProcessorLag, "aptoscan_processor_lag_versions"); count != 0 {
+		t.Fatalf("got %d lag series before the ledger version is known, want 0", count)
+	}
+	SetLedgerVersion(100)
+	if lag := testutil.ToFloat64(ProcessorLag.WithLabelValues("lagging")); lag != 60 {
+		t.Fatalf("got lag %v, want 60", lag)
+	}
+	SetProcessorVersion("lagging", 100)
+	if lag := testutil.ToFloat64(ProcessorLag.WithLabelValues("lagging")); lag != 0 {
+		t.Fatalf("got lag %v, want 0", lag)
+	}
+}
+
+type widget struct {
+	Id   int64
+	Name string
+}
+
+func TestGormPlugin_TimesWrites(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open(filepath.Join(t.TempDir(), "test.db")), &gorm.Config{
+		Logger: logger.Default.LogMode(logger.Silent),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = db.Use(GormPlugin{}); err != nil {
+		t.Fatal(err)
+	}
+	if err = db.AutoMigrate(&widget{}); err != nil {
+		t.Fatal(err)
+	}
+	if err = db.Create(&widget{Id: 1, Name: "a"}).Error; err != nil {
+		t.Fatal(err)
+	}
+	if err = db.Model(&widget{}).Where("id = ?", 1).Update("name", "b").Error; err != nil {
+		t.Fatal(err)
+	}
+
+	recorder := httptest.NewRecorder()
+	Handler().ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	for _, want := range []string{
+		`aptoscan_db_write_duration_seconds_count{operation="create",table="widgets"} 1`,
+		`aptoscan_db_write_duration_seconds_count{operation="update",table="widgets"} 1`,
+	} {
+		if !strings.Contains(recorder.Body.String(), want) {
+			t.Fatalf("/metrics is missing %s", want)
+		}
+	}
+}
@@ -0,0 +1,351 @@
+//Package migration Versioned, reversible schema changes. Every package owning tables registers its migrations
+//from `init`, the `migrate` command applies them in version order and records them in `schema_migrations`
+package migration
+
+import (
+	"context"
+	"fmt"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+//rebuildBatchSize How many rows `RebuildTables` copies at once
+const rebuildBatchSize = 1000
+
+//TableOptions Options every table is created with, by dialect. Dialects which aren't listed get none
+var TableOptions = map[string]string{
+	"mysql": "ENGINE=InnoDB DEFAULT CHARSET=utf8",
+}
+
+//Migration A single schema change. Migrations should describe tables with their own structs instead of the models,
+//so what a migration does never changes once it has been released
+type Migration struct {
+	//Version Orders the migrations, a timestamp such as 202209010000 keeps versions of different packages apart
+	Version int64
+	//Name What the migration does, shown by `migrate status`
+	Name string
+	Up   func(db *gorm.DB) error
+	Down func(db *gorm.DB) error
+}
+
+//SchemaMigration A migration which has been applied
+type SchemaMigration struct {
+	Version   int64  `gorm:"primaryKey;autoIncrement:false"`
+	Name      string `gorm:"size:255;not null"`
+	AppliedAt time.Time
+}
+
+func (SchemaMigration) TableName() string {
+	return "schema_migrations"
+}
+
+var (
+	registeredMu sync.Mutex
+	registered   = make(map[int64]Migration)
+)
+
+//Register Makes migrations known to `Registered`, it panics if a version is registered twice or a migration has no `Up`
+func Register(migrations ...Migration) {
+	registeredMu.Lock()
+	defer registeredMu.Unlock()
+	for _, m := range migrations {
+		if m.Up == nil {
+			panic(fmt.Sprintf("migration %d %s has no up step", m.Version, m.Name))
+		}
+		if existing, ok := registered[m.Version]; ok {
+			panic(fmt.Sprintf("migration version %d registered twice, by %s and %s", m.Version, existing.Name, m.Name))
+		}
+		registered[m.Version] = m
+	}
+}
+
+//Registered Every registered migration, in version order
+func Registered() []Migration {
+	registeredMu.Lock()
+	defer registeredMu.Unlock()
+	var migrations []Migration
+	for _, m := range registered {
+		migrations = append(migrations, m)
+	}
+	sortMigrations(migrations)
+	return migrations
+}
+
+func sortMigrations(migrations []Migration) {
+	sort.Slice(migrations, func(i, j int) bool {
+		return migrations[i].Version < migrations[j].Version
+	})
+}
+
+//CreateTables Creates the tables of `models` with the dialect's `TableOptions`. Tables which already exist are brought up to date,
+//so a first migration also adopts tables created before migrations existed
+func CreateTables(db *gorm.DB, models ...interface{}) error {
+	if options, ok := TableOptions[db.Dialector.Name()]; ok {
+		db = db.Set("gorm:table_options", options)
+	}
+	return db.AutoMigrate(models...)
+}
+
+//DropTables Drops the tables of `models` if they exist
+func DropTables(db *gorm.DB, models ...interface{}) error {
+	return db.Migrator().DropTable(models...)
+}
+
+//RebuildTables Recreates the table of every model with the model's definition and copies the rows over.
+//Unlike altering a table in place this works the same on every dialect, and it can add a key to a table holding
+//duplicates: rows sharing a key are merged, the one updated last wins
+func RebuildTables(db *gorm.DB, models ...interface{}) error {
+	for _, model := range models {
+		if err := rebuildTable(db, model); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func rebuildTable(db *gorm.DB, model interface{}) error {
+	stmt := &gorm.Statement{DB: db}
+	if err := stmt.Parse(model); err != nil {
+		return err
+	}
+	table := stmt.Schema.Table
+	old := table + "_rebuild"
+	if err := db.Migrator().RenameTable(table, old); err != nil {
+		return fmt.Errorf("rename %s: %w", table, err)
+	}
+	if err := releaseNames(db, stmt, old); err != nil {
+		return fmt.Errorf("rename %s: %w", table, err)
+	}
+	if err := CreateTables(db, model); err != nil {
+		return fmt.Errorf("create %s: %w", table, err)
+	}
+
+	keys := stmt.Schema.PrimaryFields
+	var order []clause.OrderByColumn
+	for _, key := range keys {
+		order = append(order, clause.OrderByColumn{Column: clause.Column{Name: key.DBName}})
+	}
+	if updatedAt := stmt.Schema.LookUpField("UpdatedAt"); updatedAt != nil {
+		order = append(order, clause.OrderByColumn{Column: clause.Column{Name: updatedAt.DBName}})
+	}
+	insert := db
+	if len(keys) > 0 {
+		insert = db.Clauses(clause.OnConflict{UpdateAll: true})
+	}
+	sliceType := reflect.SliceOf(reflect.TypeOf(model))
+	for offset := 0; ; offset += rebuildBatchSize {
+		rows := reflect.New(sliceType)
+		query := db.Table(old).Limit(rebuildBatchSize).Offset(offset)
+		if len(order) > 0 {
+			query = query.Clauses(clause.OrderBy{Columns: order})
+		}
+		if err := query.Find(rows.Interface()).Error; err != nil {
+			return fmt.Errorf("copy %s: %w", table, err)
+		}
+		count := rows.Elem().Len()
+		if count == 0 {
+			break
+		}
+		unique := uniqueRows(db, stmt, rows.Elem())
+		if err := insert.Create(unique.Interface()).Error; err != nil {
+			return fmt.Errorf("copy %s: %w", table, err)
+		}
+		if count < rebuildBatchSize {
+			break
+		}
+	}
+	return db.Migrator().DropTable(old)
+}
+
+//releaseNames Frees the names the rebuilt table needs but the renamed one still holds. Index names are unique
+//across a whole database on PostgreSQL and SQLite, and PostgreSQL doesn't rename the primary key along with its table
+func releaseNames(db *gorm.DB, stmt *gorm.Statement, old string) error {
+	for _, index := range stmt.Schema.ParseIndexes() {
+		if db.Migrator().HasIndex(old, index.Name) {
+			if err := db.Migrator().DropIndex(old, index.Name); err != nil {
+				return err
+			}
+		}
+	}
+	if db.Dialector.Name() == "postgres" {
+		return db.Exec("ALTER INDEX IF EXISTS ? RENAME TO ?",
+			clause.Table{Name: stmt.Schema.Table + "_pkey"}, clause.Table{Name: old + "_pkey"}).Error
+	}
+	return nil
+}
+
+//uniqueRows Keeps the last of the rows sharing a primary key, some dialects refuse to upsert a row twice in one statement
+func uniqueRows(db *gorm.DB, stmt *gorm.Statement, rows reflect.Value) reflect.Value {
+	keys := stmt.Schema.PrimaryFields
+	if len(keys) == 0 {
+		return rows
+	}
+	index := make(map[string]int)
+	unique := reflect.MakeSlice(rows.Type(), 0, rows.Len())
+	for i := 0; i < rows.Len(); i++ {
+		row := rows.Index(i)
+		var key []string
+		for _, field := range keys {
+			value, _ := field.ValueOf(db.Statement.Context, reflect.Indirect(row))
+			key = append(key, fmt.Sprint(value))
+		}
+		id := strings.Join(key, "\x00")
+		if j, ok := index[id]; ok {
+			unique.Index(j).Set(row)
+			continue
+		}
+		index[id] = unique.Len()
+		unique = reflect.Append(unique, row)
+	}
+	return unique
+}
+
+//Status A migration and whether it has been applied
+type Status struct {
+	Migration
+	Applied   bool
+	AppliedAt time.Time
+}
+
+type Migrator struct {
+	db         *gorm.DB
+	migrations []Migration
+}
+
+func New(db *gorm.DB, migrations ...Migration) *Migrator {
+	sorted := append([]Migration(nil), migrations...)
+	sortMigrations(sorted)
+	return &Migrator{
+		db:         db,
+		migrations: sorted,
+	}
+}
+
+//applied Every version in `schema_migrations`, creating the table if it doesn't exist yet
+func (m *Migrator) applied(ctx context.Context) (map[int64]SchemaMigration, error) {
+	db := m.db.WithContext(ctx)
+	if err := CreateTables(db, &SchemaMigration{}); err != nil {
+		return nil, err
+	}
+	var rows []SchemaMigration
+	if err := db.Order("version").Find(&rows).Error; err != nil {
+		return nil, err
+	}
+	applied := make(map[int64]SchemaMigration)
+	for _, row := range rows {
+		applied[row.Version] = row
+	}
+	return applied, nil
+}
+
+func (m *Migrator) Status(ctx context.Context) ([]Status, error) {
+	applied, err := m.applied(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var statuses []Status
+	for _, migration := range m.migrations {
+		row, ok := applied[migration.Version]
+		statuses = append(statuses, Status{
+			Migration: migration,
+			Applied:   ok,
+			AppliedAt: row.AppliedAt,
+		})
+	}
+	return statuses, nil
+}
+
+//Pending Migrations which haven't been applied yet, in the order `Up` applies them
+func (m *Migrator) Pending(ctx context.Context) ([]Migration, error) {
+	statuses, err := m.Status(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var pending []Migration
+	for _, status := range statuses {
+		if !status.Applied {
+			pending = append(pending, status.Migration)
+		}
+	}
+	return pending, nil
+}
+
+//Up Applies every pending migration in version order and returns those it applied.
+//Each migration is recorded in the same transaction as its changes, though MySQL commits DDL statements on its own
+func (m *Migrator) Up(ctx context.Context) ([]Migration, error) {
+	pending, err := m.Pending(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var done []Migration
+	for _, migration := range pending {
+		if err = m.db.WithContext(ctx).Transaction(func(db *gorm.DB) error {
+			if err := migration.Up(db); err != nil {
+				return err
+			}
+			return db.Create(&SchemaMigration{
+				Version:   migration.Version,
+				Name:      migration.Name,
+				AppliedAt: time.Now(),
+			}).Error
+		}); err != nil {
+			return done, fmt.Errorf("migration %d %s: %w", migration.Version, migration.Name, err)
+		}
+		done = append(done, migration)
+	}
+	return done, nil
+}
+
+//Down Reverts the last `steps` applied migrations, newest first, and returns those it reverted.
+//It refuses to revert a migration which isn't registered or has no down step
+func (m *Migrator) Down(ctx context.Context, steps int) ([]Migration, error) {
+	applied, err := m.applied(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var versions []int64
+	for version := range applied {
+		versions = append(versions, version)
+	}
+	sort.Slice(versions, func(i, j int) bool {
+		return versions[i] > versions[j]
+	})
+	if steps < len(versions) {
+		versions = versions[:steps]
+	}
+
+	var done []Migration
+	for _, version := range versions {
+		migration, ok := m.find(version)
+		if !ok {
+			return done, fmt.Errorf("migration %d %s is applied but not registered", version, applied[version].Name)
+		}
+		if migration.Down == nil {
+			return done, fmt.Errorf("migration %d %s can not be reverted", migration.Version, migration.Name)
+		}
+		if err = m.db.WithContext(ctx).Transaction(func(db *gorm.DB) error {
+			if err := migration.Down(db); err != nil {
+				return err
+			}
+			return db.Delete(&SchemaMigration{}, "version = ?", migration.Version).Error
+		}); err != nil {
+			return done, fmt.Errorf("migration %d %s: %w", migration.Version, migration.Name, err)
+		}
+		done = append(done, migration)
+	}
+	return done, nil
+}
+
+func (m *Migrator) find(version int64) (Migration, bool) {
+	for _, migration := range m.migrations {
+		if migration.Version == version {
+			return migration, true
+		}
+	}
+	return Migration{}, false
+}
@@ -0,0 +1,201 @@
+package migration
+
+import (
+	"context"
+	"errors"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+	"path/filepath"
+	"testing"
+)
+
+func openTestDB(t *testing.T) *gorm.DB {
+	db, err := gorm.Open(sqlite.Open(filepath.Join(t.TempDir(), "test.db")), &gorm.Config{
+		Logger: logger.Default.LogMode(logger.Silent),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+type widgetV1 struct {
+	Id   int64
+	Name string
+}
+
+func (widgetV1) TableName() string {
+	return "widgets"
+}
+
+type widgetV2 struct {
+	Id    int64
+	Name  string
+	Color string
+}
+
+func (widgetV2) TableName() string {
+	return "widgets"
+}
+
+func testMigrations() []Migration {
+	return []Migration{
+		{
+			Version: 2,
+			Name:    "add widgets.color",
+			Up: func(db *gorm.DB) error {
+				return db.Migrator().AddColumn(&widgetV2{}, "Color")
+			},
+			Down: func(db *gorm.DB) error {
+				return db.Migrator().DropColumn(&widgetV2{}, "Color")
+			},
+		},
+		{
+			Version: 1,
+			Name:    "create widgets",
+			Up: func(db *gorm.DB) error {
+				return CreateTables(db, &widgetV1{})
+			},
+			Down: func(db *gorm.DB) error {
+				return DropTables(db, &widgetV1{})
+			},
+		},
+	}
+}
+
+func TestMigrator_UpAndDown(t *testing.T) {
+	ctx := context.Background()
+	db := openTestDB(t)
+	migrator := New(db, testMigrations()...)
+
+	applied, err := migrator.Up(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(applied) != 2 || applied[0].Version != 1 || applied[1].Version != 2 {
+		t.Fatalf("applied %+v, want versions 1 and 2 in order", applied)
+	}
+	if !db.Migrator().HasColumn(&widgetV2{}, "Color") {
+		t.Fatal("widgets.color wasn't added")
+	}
+	if applied, err = migrator.Up(ctx); err != nil || len(applied) != 0 {
+		t.Fatalf("second up applied %d migrations, %v", len(applied), err)
+	}
+
+	reverted, err := migrator.Down(ctx, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(reverted) != 1 || reverted[0].Version != 2 {
+		t.Fatalf("reverted %+v, want version 2", reverted)
+	}
+	if db.Migrator().HasColumn(&widgetV2{}, "Color") || !db.Migrator().HasTable("widgets") {
+		t.Fatal("only widgets.color should have been reverted")
+	}
+	pending, err := migrator.Pending(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pending) != 1 || pending[0].Version != 2 {
+		t.Fatalf("pending %+v, want version 2", pending)
+	}
+
+	if reverted, err = migrator.Down(ctx, 5); err != nil || len(reverted) != 1 {
+		t.Fatalf("reverted %d migrations, %v", len(reverted), err)
+	}
+	if db.Migrator().HasTable("widgets") {
+		t.Fatal("widgets wasn't dropped")
+	}
+}
+
+func TestMigrator_FailedUpIsNotRecorded(t *testing.T) {
+	ctx := context.Background()
+	db := openTestDB(t)
+	migrations := append(testMigrations(), Migration{
+		Version: 3,
+		Name:    "broken",
+		Up: func(db *gorm.DB) error {
+			return errors.New("broken")
+		},
+	})
+	if _, err := New(db, migrations...).Up(ctx); err == nil {
+		t.Fatal("expected the broken migration to fail")
+	}
+	statuses, err := New(db, migrations...).Status(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, status := range statuses {
+		if status.Applied != (status.Version != 3) {
+			t.Fatalf("migration %d applied: %v", status.Version, status.Applied)
+		}
+	}
+
+	// a migration which has been applied but is no longer registered can't be reverted
+	if _, err = New(db, migrations[1]).Down(ctx, 1); err == nil {
+		t.Fatal("expected reverting an unregistered migration to fail")
+	}
+}
+
+type noteV1 struct {
+	Owner     string
+	Title     string
+	Body      string
+	UpdatedAt int64
+}
+
+func (noteV1) TableName() string {
+	return "notes"
+}
+
+type noteV2 struct {
+	Owner     string `gorm:"primaryKey;size:64"`
+	Title     string `gorm:"primaryKey;size:64"`
+	Body      string `gorm:"size:255;index"`
+	UpdatedAt int64
+}
+
+func (noteV2) TableName() string {
+	return "notes"
+}
+
+func TestRebuildTables_MergesDuplicateKeys(t *testing.T) {
+	db := openTestDB(t)
+	if err := CreateTables(db, &noteV1{}); err != nil {
+		t.Fatal(err)
+	}
+	notes := []noteV1{
+		{Owner: "alice", Title: "todo", Body: "new", UpdatedAt: 2},
+		{Owner: "alice", Title: "todo", Body: "old", UpdatedAt: 1},
+		{Owner: "bob", Title: "todo", Body: "bob", UpdatedAt: 1},
+	}
+	if err := db.Create(&notes).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	if err := RebuildTables(db, &noteV2{}); err != nil {
+		t.Fatal(err)
+	}
+	var rebuilt []noteV2
+	if err := db.Order("owner").Find(&rebuilt).Error; err != nil {
+		t.Fatal(err)
+	}
+	if len(rebuilt) != 2 || rebuilt[0].Body != "new" || rebuilt[1].Body != "bob" {
+		t.Fatalf("got %+v, want the latest alice note and bob's", rebuilt)
+	}
+	if err := db.Create(&noteV2{Owner: "bob", Title: "todo"}).Error; err == nil {
+		t.Fatal("expected the rebuilt table to refuse a duplicate key")
+	}
+	if db.Migrator().HasTable("notes_rebuild") {
+		t.Fatal("the old table wasn't dropped")
+	}
+
+	// the renamed table must not keep the names of the keys and indexes the rebuilt table needs
+	if err := RebuildTables(db, &noteV2{}); err != nil {
+		t.Fatal(err)
+	}
+	if !db.Migrator().HasIndex(&noteV2{}, "Body") {
+		t.Fatal("notes.body isn't indexed after rebuilding it again")
+	}
+}
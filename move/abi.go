@@ -0,0 +1,67 @@
+package move
+
+import (
+	"apotscan/types/module"
+)
+
+//ABI Derives the module's ABI as the fullnode would serve it, for modules published without one.
+//Functions are exposed when they are public, friend or entry
+func (m *CompiledModule) ABI() module.ABI {
+	abi := module.ABI{
+		Address:          m.Self.Address,
+		Name:             m.Self.Name,
+		Friends:          []string{},
+		ExposedFunctions: []module.Function{},
+		Structs:          []module.Struct{},
+	}
+	for _, friend := range m.Friends {
+		abi.Friends = append(abi.Friends, friend.String())
+	}
+	for _, definition := range m.Functions {
+		if definition.Visibility == Private && !definition.IsEntry {
+			continue
+		}
+		handle := m.FunctionHandles[definition.Handle]
+		function := module.Function{
+			Name:              handle.Name,
+			Visibility:        definition.Visibility.String(),
+			IsEntry:           definition.IsEntry,
+			GenericTypeParams: []module.GenericTypeParam{},
+			Params:            m.typeStrings(handle.Parameters),
+			Return:            m.typeStrings(handle.Return),
+		}
+		for _, constraints := range handle.TypeParameters {
+			function.GenericTypeParams = append(function.GenericTypeParams, module.GenericTypeParam{Constraints: constraints.Names()})
+		}
+		abi.ExposedFunctions = append(abi.ExposedFunctions, function)
+	}
+	for _, definition := range m.Structs {
+		handle := m.StructHandles[definition.Handle]
+		structure := module.Struct{
+			Name:              handle.Name,
+			IsNative:          definition.Native,
+			Abilities:         handle.Abilities.Names(),
+			GenericTypeParams: []module.GenericTypeParam{},
+			Fields:            []module.Field{},
+		}
+		for _, parameter := range handle.TypeParameters {
+			structure.GenericTypeParams = append(structure.GenericTypeParams, module.GenericTypeParam{
+				Constraints: parameter.Constraints.Names(),
+				IsPhantom:   parameter.IsPhantom,
+			})
+		}
+		for _, field := range definition.Fields {
+			structure.Fields = append(structure.Fields, module.Field{Name: field.Name, Type: m.TypeString(field.Type)})
+		}
+		abi.Structs = append(abi.Structs, structure)
+	}
+	return abi
+}
+
+func (m *CompiledModule) typeStrings(signature []SignatureToken) []string {
+	types := []string{}
+	for _, token := range signature {
+		types = append(types, m.TypeString(token))
+	}
+	return types
+}
@@ -0,0 +1,679 @@
+package move
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+	"unicode/utf8"
+)
+
+var magic = []byte{0xa1, 0x1c, 0xeb, 0x0b}
+
+const (
+	//minVersion The oldest bytecode version read, earlier versions have no phantom type parameters
+	minVersion uint32 = 3
+	maxVersion uint32 = 6
+	//addressLength Addresses are 32 bytes on Aptos
+	addressLength = 32
+	//maxSignatureDepth How deep types may nest, the VM allows far less
+	maxSignatureDepth = 256
+)
+
+type tableKind uint8
+
+const (
+	moduleHandles   tableKind = 0x1
+	structHandles   tableKind = 0x2
+	functionHandles tableKind = 0x3
+	signatures      tableKind = 0x5
+	constantPool    tableKind = 0x6
+	identifiers     tableKind = 0x7
+	addressIdents   tableKind = 0x8
+	structDefs      tableKind = 0xA
+	functionDefs    tableKind = 0xC
+	friendDecls     tableKind = 0xF
+	metadata        tableKind = 0x10
+)
+
+const (
+	nativeStruct   uint8 = 0x1
+	declaredStruct uint8 = 0x2
+	//nativeFunction, entryFunction Bits of a function definition's flags
+	nativeFunction uint8 = 0x2
+	entryFunction  uint8 = 0x4
+	//entryFlagVersion The first bytecode version with the entry flag, entry functions were script visible before
+	entryFlagVersion uint32 = 5
+)
+
+//ErrMagic The data doesn't start with the magic every module starts with
+var ErrMagic = errors.New("not a Move module, bad magic")
+
+//DeserializeHex Deserializes a module given as hex, as the fullnode serves its bytecode
+func DeserializeHex(code string) (*CompiledModule, error) {
+	data, err := hex.DecodeString(strings.TrimPrefix(code, "0x"))
+	if err != nil {
+		return nil, err
+	}
+	return Deserialize(data)
+}
+
+//Deserialize Deserializes a module's bytecode. Instantiation and field tables, which the ABI has no use for, are skipped,
+//function bodies are walked only to count their instructions
+func Deserialize(data []byte) (*CompiledModule, error) {
+	h, err := readHeader(data)
+	if err != nil {
+		return nil, err
+	}
+	if h.version < minVersion || h.version > maxVersion {
+		return nil, fmt.Errorf("unsupported bytecode version %d", h.version)
+	}
+	d := &deserializer{module: &CompiledModule{Version: h.version}}
+	//Tables are read in dependency order, e.g. module handles need identifiers and addresses
+	if err = d.read(data, h, []tableKind{
+		identifiers, addressIdents, moduleHandles, structHandles, signatures, functionHandles,
+		constantPool, metadata, structDefs, functionDefs, friendDecls,
+	}); err != nil {
+		return nil, err
+	}
+	return d.module, nil
+}
+
+//SelfHandleHex Reads only the address and name of a module given as hex, see `SelfHandle`
+func SelfHandleHex(code string) (ModuleHandle, error) {
+	data, err := hex.DecodeString(strings.TrimPrefix(code, "0x"))
+	if err != nil {
+		return ModuleHandle{}, err
+	}
+	return SelfHandle(data)
+}
+
+//SelfHandle Reads only the address and name of a module. Every bytecode version lays out these tables the same way,
+//so it reads modules `Deserialize` doesn't support, e.g. newer versions or ones with unknown instructions
+func SelfHandle(data []byte) (ModuleHandle, error) {
+	h, err := readHeader(data)
+	if err != nil {
+		return ModuleHandle{}, err
+	}
+	d := &deserializer{module: &CompiledModule{Version: h.version}}
+	if err = d.read(data, h, []tableKind{identifiers, addressIdents, moduleHandles}); err != nil {
+		return ModuleHandle{}, err
+	}
+	return d.module.Self, nil
+}
+
+//header The bytecode version and table directory of a module, table offsets start at `content`
+type header struct {
+	version uint32
+	tables  map[tableKind]table
+	content int
+	//end Where the last table ends, relative to `content`
+	end int
+}
+
+type table struct {
+	offset, length int
+}
+
+func readHeader(data []byte) (*header, error) {
+	if !bytes.HasPrefix(data, magic) {
+		return nil, ErrMagic
+	}
+	r := &reader{data: data, offset: len(magic)}
+	version, err := r.u32()
+	if err != nil {
+		return nil, err
+	}
+	count, err := r.uleb()
+	if err != nil {
+		return nil, err
+	}
+	h := &header{version: version, tables: make(map[tableKind]table)}
+	for i := 0; i < count; i++ {
+		kind, err := r.u8()
+		if err != nil {
+			return nil, err
+		}
+		offset, err := r.uleb()
+		if err != nil {
+			return nil, err
+		}
+		length, err := r.uleb()
+		if err != nil {
+			return nil, err
+		}
+		if _, ok := h.tables[tableKind(kind)]; ok {
+			return nil, fmt.Errorf("duplicate table %#x", kind)
+		}
+		h.tables[tableKind(kind)] = table{offset, length}
+		if offset+length > h.end {
+			h.end = offset + length
+		}
+	}
+	h.content = r.offset
+	if h.content+h.end > len(data) {
+		return nil, fmt.Errorf("tables end at %d, past the end of the module", h.content+h.end)
+	}
+	return h, nil
+}
+
+//read Reads the tables of `kinds` which the module has, in the order given, then the self module handle
+func (d *deserializer) read(data []byte, h *header, kinds []tableKind) error {
+	readers := map[tableKind]func(r *reader) error{
+		identifiers:     d.identifiers,
+		addressIdents:   d.addresses,
+		moduleHandles:   d.moduleHandles,
+		structHandles:   d.structHandles,
+		signatures:      d.signatures,
+		functionHandles: d.functionHandles,
+		constantPool:    d.constants,
+		metadata:        d.metadata,
+		structDefs:      d.structDefinitions,
+		functionDefs:    d.functionDefinitions,
+		friendDecls:     d.friends,
+	}
+	for _, kind := range kinds {
+		t, ok := h.tables[kind]
+		if !ok {
+			continue
+		}
+		tr := &reader{data: data[:h.content+t.offset+t.length], offset: h.content + t.offset}
+		if err := readers[kind](tr); err != nil {
+			return fmt.Errorf("table %#x: %w", uint8(kind), err)
+		}
+		if tr.offset != len(tr.data) {
+			return fmt.Errorf("table %#x: %d trailing bytes", uint8(kind), len(tr.data)-tr.offset)
+		}
+	}
+
+	r := &reader{data: data, offset: h.content + h.end}
+	self, err := r.index(len(d.module.ModuleHandles))
+	if err != nil {
+		return fmt.Errorf("self module handle: %w", err)
+	}
+	d.module.Self = d.module.ModuleHandles[self]
+	return nil
+}
+
+type deserializer struct {
+	module *CompiledModule
+}
+
+func (d *deserializer) identifiers(r *reader) error {
+	for !r.done() {
+		identifier, err := r.bytes()
+		if err != nil {
+			return err
+		}
+		if !utf8.Valid(identifier) {
+			return fmt.Errorf("identifier %d is not utf8", len(d.module.Identifiers))
+		}
+		d.module.Identifiers = append(d.module.Identifiers, string(identifier))
+	}
+	return nil
+}
+
+func (d *deserializer) addresses(r *reader) error {
+	for !r.done() {
+		address, err := r.take(addressLength)
+		if err != nil {
+			return err
+		}
+		d.module.Addresses = append(d.module.Addresses, formatAddress(address))
+	}
+	return nil
+}
+
+func (d *deserializer) moduleHandles(r *reader) error {
+	for !r.done() {
+		handle, err := d.moduleHandle(r)
+		if err != nil {
+			return err
+		}
+		d.module.ModuleHandles = append(d.module.ModuleHandles, handle)
+	}
+	return nil
+}
+
+func (d *deserializer) moduleHandle(r *reader) (ModuleHandle, error) {
+	address, err := r.index(len(d.module.Addresses))
+	if err != nil {
+		return ModuleHandle{}, err
+	}
+	name, err := r.index(len(d.module.Identifiers))
+	if err != nil {
+		return ModuleHandle{}, err
+	}
+	return ModuleHandle{Address: d.module.Addresses[address], Name: d.module.Identifiers[name]}, nil
+}
+
+func (d *deserializer) structHandles(r *reader) error {
+	for !r.done() {
+		module, err := r.index(len(d.module.ModuleHandles))
+		if err != nil {
+			return err
+		}
+		name, err := r.index(len(d.module.Identifiers))
+		if err != nil {
+			return err
+		}
+		abilities, err := r.u8()
+		if err != nil {
+			return err
+		}
+		count, err := r.uleb()
+		if err != nil {
+			return err
+		}
+		handle := StructHandle{
+			Module:    d.module.ModuleHandles[module],
+			Name:      d.module.Identifiers[name],
+			Abilities: AbilitySet(abilities),
+		}
+		for i := 0; i < count; i++ {
+			constraints, err := r.u8()
+			if err != nil {
+				return err
+			}
+			phantom, err := r.u8()
+			if err != nil {
+				return err
+			}
+			handle.TypeParameters = append(handle.TypeParameters, StructTypeParameter{
+				Constraints: AbilitySet(constraints),
+				IsPhantom:   phantom != 0,
+			})
+		}
+		d.module.StructHandles = append(d.module.StructHandles, handle)
+	}
+	return nil
+}
+
+func (d *deserializer) signatures(r *reader) error {
+	for !r.done() {
+		signature, err := d.signature(r)
+		if err != nil {
+			return err
+		}
+		d.module.Signatures = append(d.module.Signatures, signature)
+	}
+	return nil
+}
+
+func (d *deserializer) signature(r *reader) ([]SignatureToken, error) {
+	count, err := r.uleb()
+	if err != nil {
+		return nil, err
+	}
+	signature := []SignatureToken{}
+	for i := 0; i < count; i++ {
+		token, err := d.token(r, 0)
+		if err != nil {
+			return nil, err
+		}
+		signature = append(signature, token)
+	}
+	return signature, nil
+}
+
+func (d *deserializer) token(r *reader, depth int) (SignatureToken, error) {
+	if depth > maxSignatureDepth {
+		return SignatureToken{}, errors.New("signature nested too deep")
+	}
+	kind, err := r.u8()
+	if err != nil {
+		return SignatureToken{}, err
+	}
+	token := SignatureToken{Kind: TokenKind(kind)}
+	switch token.Kind {
+	case TokenBool, TokenU8, TokenU16, TokenU32, TokenU64, TokenU128, TokenU256, TokenAddress, TokenSigner:
+	case TokenReference, TokenMutableReference, TokenVector:
+		inner, err := d.token(r, depth+1)
+		if err != nil {
+			return token, err
+		}
+		token.Inner = &inner
+	case TokenTypeParameter:
+		if token.TypeParameter, err = r.uleb(); err != nil {
+			return token, err
+		}
+	case TokenStruct:
+		if token.Struct, err = r.index(len(d.module.StructHandles)); err != nil {
+			return token, err
+		}
+	case TokenStructInstantiation:
+		if token.Struct, err = r.index(len(d.module.StructHandles)); err != nil {
+			return token, err
+		}
+		count, err := r.uleb()
+		if err != nil {
+			return token, err
+		}
+		for i := 0; i < count; i++ {
+			argument, err := d.token(r, depth+1)
+			if err != nil {
+				return token, err
+			}
+			token.TypeArguments = append(token.TypeArguments, argument)
+		}
+	default:
+		return token, fmt.Errorf("unknown signature token %#x", kind)
+	}
+	return token, nil
+}
+
+func (d *deserializer) functionHandles(r *reader) error {
+	for !r.done() {
+		module, err := r.index(len(d.module.ModuleHandles))
+		if err != nil {
+			return err
+		}
+		name, err := r.index(len(d.module.Identifiers))
+		if err != nil {
+			return err
+		}
+		parameters, err := r.index(len(d.module.Signatures))
+		if err != nil {
+			return err
+		}
+		returns, err := r.index(len(d.module.Signatures))
+		if err != nil {
+			return err
+		}
+		count, err := r.uleb()
+		if err != nil {
+			return err
+		}
+		handle := FunctionHandle{
+			Module:     d.module.ModuleHandles[module],
+			Name:       d.module.Identifiers[name],
+			Parameters: d.module.Signatures[parameters],
+			Return:     d.module.Signatures[returns],
+		}
+		for i := 0; i < count; i++ {
+			constraints, err := r.u8()
+			if err != nil {
+				return err
+			}
+			handle.TypeParameters = append(handle.TypeParameters, AbilitySet(constraints))
+		}
+		d.module.FunctionHandles = append(d.module.FunctionHandles, handle)
+	}
+	return nil
+}
+
+func (d *deserializer) constants(r *reader) error {
+	for !r.done() {
+		token, err := d.token(r, 0)
+		if err != nil {
+			return err
+		}
+		data, err := r.bytes()
+		if err != nil {
+			return err
+		}
+		d.module.Constants = append(d.module.Constants, Constant{Type: token, Data: data})
+	}
+	return nil
+}
+
+func (d *deserializer) metadata(r *reader) error {
+	for !r.done() {
+		key, err := r.bytes()
+		if err != nil {
+			return err
+		}
+		value, err := r.bytes()
+		if err != nil {
+			return err
+		}
+		d.module.Metadata = append(d.module.Metadata, Metadata{Key: key, Value: value})
+	}
+	return nil
+}
+
+func (d *deserializer) structDefinitions(r *reader) error {
+	for !r.done() {
+		handle, err := r.index(len(d.module.StructHandles))
+		if err != nil {
+			return err
+		}
+		tag, err := r.u8()
+		if err != nil {
+			return err
+		}
+		definition := StructDefinition{Handle: handle}
+		switch tag {
+		case nativeStruct:
+			definition.Native = true
+		case declaredStruct:
+			count, err := r.uleb()
+			if err != nil {
+				return err
+			}
+			for i := 0; i < count; i++ {
+				name, err := r.index(len(d.module.Identifiers))
+				if err != nil {
+					return err
+				}
+				token, err := d.token(r, 0)
+				if err != nil {
+					return err
+				}
+				definition.Fields = append(definition.Fields, FieldDefinition{Name: d.module.Identifiers[name], Type: token})
+			}
+		default:
+			return fmt.Errorf("unknown struct tag %#x", tag)
+		}
+		d.module.Structs = append(d.module.Structs, definition)
+	}
+	return nil
+}
+
+func (d *deserializer) functionDefinitions(r *reader) error {
+	for !r.done() {
+		handle, err := r.index(len(d.module.FunctionHandles))
+		if err != nil {
+			return err
+		}
+		visibility, err := r.u8()
+		if err != nil {
+			return err
+		}
+		definition := FunctionDefinition{Handle: handle, Visibility: Visibility(visibility)}
+		switch definition.Visibility {
+		case Private, Public, Friend:
+		case deprecatedScript:
+			if d.module.Version >= entryFlagVersion {
+				return fmt.Errorf("script visibility in bytecode version %d", d.module.Version)
+			}
+			definition.Visibility, definition.IsEntry = Public, true
+		default:
+			return fmt.Errorf("unknown visibility %#x", visibility)
+		}
+		flags, err := r.u8()
+		if err != nil {
+			return err
+		}
+		definition.IsNative = flags&nativeFunction != 0
+		if d.module.Version >= entryFlagVersion {
+			definition.IsEntry = flags&entryFunction != 0
+		}
+		count, err := r.uleb()
+		if err != nil {
+			return err
+		}
+		for i := 0; i < count; i++ {
+			acquired, err := r.uleb()
+			if err != nil {
+				return err
+			}
+			definition.Acquires = append(definition.Acquires, acquired)
+		}
+		if !definition.IsNative {
+			locals, err := r.index(len(d.module.Signatures))
+			if err != nil {
+				return err
+			}
+			definition.Locals = d.module.Signatures[locals]
+			if definition.Instructions, err = skipCode(r); err != nil {
+				return fmt.Errorf("function %s: %w", d.module.FunctionHandles[handle].Name, err)
+			}
+		}
+		d.module.Functions = append(d.module.Functions, definition)
+	}
+	return nil
+}
+
+func (d *deserializer) friends(r *reader) error {
+	for !r.done() {
+		handle, err := d.moduleHandle(r)
+		if err != nil {
+			return err
+		}
+		d.module.Friends = append(d.module.Friends, handle)
+	}
+	return nil
+}
+
+//operandSizes The fixed size operands of instructions, in bytes, instructions not listed here take a single ULEB
+//operand or none at all
+var operandSizes = map[uint8]int{
+	0x06: 8,  //LdU64
+	0x0A: 1,  //CopyLoc
+	0x0B: 1,  //MoveLoc
+	0x0C: 1,  //StLoc
+	0x0D: 1,  //MutBorrowLoc
+	0x0E: 1,  //ImmBorrowLoc
+	0x31: 1,  //LdU8
+	0x32: 16, //LdU128
+	0x48: 2,  //LdU16
+	0x49: 4,  //LdU32
+	0x4A: 32, //LdU256
+}
+
+//lastOpcode CastU256, the last of the instructions added by bytecode version 6
+const lastOpcode = 0x4D
+
+//ulebOperand Instructions taking an index, e.g. of a constant, branch target or signature
+var ulebOperand = map[uint8]bool{
+	0x03: true, 0x04: true, 0x05: true, 0x07: true,
+	0x0F: true, 0x10: true, 0x11: true, 0x12: true, 0x13: true,
+	0x29: true, 0x2A: true, 0x2B: true, 0x2C: true, 0x2D: true,
+	0x36: true, 0x37: true, 0x38: true, 0x39: true, 0x3A: true, 0x3B: true,
+	0x3C: true, 0x3D: true, 0x3E: true, 0x3F: true,
+	0x41: true, 0x42: true, 0x43: true, 0x44: true, 0x45: true, 0x47: true,
+}
+
+//skipCode Reads past a function body, returning how many instructions it has
+func skipCode(r *reader) (int, error) {
+	count, err := r.uleb()
+	if err != nil {
+		return 0, err
+	}
+	for i := 0; i < count; i++ {
+		opcode, err := r.u8()
+		if err != nil {
+			return 0, err
+		}
+		switch {
+		case opcode == 0x40 || opcode == 0x46:
+			//VecPack and VecUnpack take a signature and a u64 length
+			if _, err = r.uleb(); err == nil {
+				_, err = r.take(8)
+			}
+		case ulebOperand[opcode]:
+			_, err = r.uleb()
+		case operandSizes[opcode] > 0:
+			_, err = r.take(operandSizes[opcode])
+		case opcode == 0 || opcode > lastOpcode:
+			err = fmt.Errorf("unknown opcode %#x", opcode)
+		}
+		if err != nil {
+			return 0, err
+		}
+	}
+	return count, nil
+}
+
+//formatAddress Formats an address the way the fullnode does in an ABI, without leading zeros
+func formatAddress(address []byte) string {
+	return "0x" + new(big.Int).SetBytes(address).Text(16)
+}
+
+type reader struct {
+	data   []byte
+	offset int
+}
+
+func (r *reader) done() bool {
+	return r.offset >= len(r.data)
+}
+
+func (r *reader) take(n int) ([]byte, error) {
+	if n < 0 || r.offset+n > len(r.data) {
+		return nil, fmt.Errorf("unexpected end of data at %d", r.offset)
+	}
+	b := r.data[r.offset : r.offset+n]
+	r.offset += n
+	return b, nil
+}
+
+func (r *reader) u8() (uint8, error) {
+	b, err := r.take(1)
+	if err != nil {
+		return 0, err
+	}
+	return b[0], nil
+}
+
+func (r *reader) u32() (uint32, error) {
+	b, err := r.take(4)
+	if err != nil {
+		return 0, err
+	}
+	return binary.LittleEndian.Uint32(b), nil
+}
+
+//uleb Reads an unsigned LEB128 value, which bytecode uses for every count and index
+func (r *reader) uleb() (int, error) {
+	var value uint64
+	for shift := uint(0); shift < 32; shift += 7 {
+		b, err := r.u8()
+		if err != nil {
+			return 0, err
+		}
+		value |= uint64(b&0x7f) << shift
+		if b&0x80 == 0 {
+			if value > 1<<31-1 {
+				break
+			}
+			return int(value), nil
+		}
+	}
+	return 0, fmt.Errorf("ULEB128 value out of range at %d", r.offset)
+}
+
+//index Reads an index into a table with `length` entries
+func (r *reader) index(length int) (int, error) {
+	i, err := r.uleb()
+	if err != nil {
+		return 0, err
+	}
+	if i >= length {
+		return 0, fmt.Errorf("index %d out of bounds of %d entries", i, length)
+	}
+	return i, nil
+}
+
+//bytes Reads a ULEB length prefixed byte string
+func (r *reader) bytes() ([]byte, error) {
+	n, err := r.uleb()
+	if err != nil {
+		return nil, err
+	}
+	return r.take(n)
+}
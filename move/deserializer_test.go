@@ -0,0 +1,218 @@
+package move
+
+import (
+	"encoding/hex"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+//hello Bytecode version 5 of
+//
+//	module 0xcafe::hello {
+//	    use std::string::String;
+//	    friend 0xcafe::other;
+//	    struct Message has key { message: String }
+//	    const SEVEN: u64 = 7;
+//	    public entry fun say(_s: &signer, _m: String) acquires Message { 1; vector<u8>[]; }
+//	    fun private_helper() {}
+//	    public native fun read<T: copy + drop>(a: address): vector<u8>;
+//	}
+var hello = strings.Join([]string{
+	"a11ceb0b05000000",
+	//table headers: identifiers, addresses, module handles, struct handles, signatures,
+	//function handles, constants, struct definitions, function definitions, friends
+	"0a", "070042", "084240", "01820106", "02880108", "0590010b", "039b0110", "06ab010a", "0ab50106", "0cbb0128", "0fe30102",
+	//identifiers
+	"0568656c6c6f", "06737472696e67", "06537472696e67", "056f74686572", "074d657373616765", "076d657373616765", "03736179",
+	"0e707269766174655f68656c706572", "0472656164",
+	//addresses
+	"000000000000000000000000000000000000000000000000000000000000cafe",
+	"0000000000000000000000000000000000000000000000000000000000000001",
+	//module handles: 0xcafe::hello, 0x1::string, 0xcafe::other
+	"0000", "0101", "0003",
+	//struct handles: Message has key, String has copy + drop + store
+	"00040800", "01020700",
+	//signatures: (), (&signer, String), (address), (vector<u8>)
+	"00", "02060c0801", "0105", "010a02",
+	//function handles: say, private_helper, read<T: copy + drop>
+	"0006010000", "0007000000", "000802030103",
+	//constants: 7u64
+	"0308", "0700000000000000",
+	//struct definitions: Message { message: String }
+	"000201050801",
+	//function definitions: LdU64 1, Pop, VecPack(vector<u8>, 0), Pop, Ret; Ret; native
+	"0001040100", "0005", "060100000000000000", "01", "40030000000000000000", "01", "02",
+	"0100000000", "0102",
+	"02010200",
+	//friends, then the self module handle
+	"0003",
+	"00",
+}, "")
+
+//casts Bytecode version 6 of
+//
+//	module 0xcafe::casts {
+//	    public fun widen(x: u8): u256 { (((x as u16) as u32) as u256) }
+//	}
+var casts = strings.Join([]string{
+	"a11ceb0b06000000",
+	//table headers: identifiers, addresses, module handles, signatures, function handles, function definitions
+	"06", "07000c", "080c20", "012c02", "052e05", "033305", "0c380c",
+	//identifiers
+	"056361737473", "05776964656e",
+	//addresses
+	"000000000000000000000000000000000000000000000000000000000000cafe",
+	//module handles: 0xcafe::casts
+	"0000",
+	//signatures: (), (u8), (u256)
+	"00", "0102", "010f",
+	//function handles: widen
+	"0001010200",
+	//function definitions: CopyLoc 0, CastU16, CastU32, CastU256, Ret
+	"0001000000", "05", "0a00", "4b", "4c", "4d", "02",
+	//self module handle
+	"00",
+}, "")
+
+func TestDeserialize(t *testing.T) {
+	m, err := DeserializeHex("0x" + hello)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.Version != 5 || m.Self != (ModuleHandle{Address: "0xcafe", Name: "hello"}) {
+		t.Fatalf("got version %d of %s", m.Version, m.Self)
+	}
+	if len(m.Friends) != 1 || m.Friends[0].String() != "0xcafe::other" {
+		t.Fatalf("got friends %v", m.Friends)
+	}
+
+	if len(m.Structs) != 1 {
+		t.Fatalf("got %d structs, want 1", len(m.Structs))
+	}
+	message := m.StructHandles[m.Structs[0].Handle]
+	if message.Name != "Message" || message.Abilities != Key || m.Structs[0].Native {
+		t.Fatalf("got struct %+v", message)
+	}
+	if fields := m.Structs[0].Fields; len(fields) != 1 || fields[0].Name != "message" || m.TypeString(fields[0].Type) != "0x1::string::String" {
+		t.Fatalf("got fields %+v", fields)
+	}
+
+	if len(m.Functions) != 3 {
+		t.Fatalf("got %d functions, want 3", len(m.Functions))
+	}
+	for i, want := range []struct {
+		name         string
+		visibility   Visibility
+		entry        bool
+		native       bool
+		acquires     int
+		instructions int
+	}{
+		{"say", Public, true, false, 1, 5},
+		{"private_helper", Private, false, false, 0, 1},
+		{"read", Public, false, true, 0, 0},
+	} {
+		function := m.Functions[i]
+		if name := m.FunctionHandles[function.Handle].Name; name != want.name || function.Visibility != want.visibility ||
+			function.IsEntry != want.entry || function.IsNative != want.native ||
+			len(function.Acquires) != want.acquires || function.Instructions != want.instructions {
+			t.Fatalf("got function %s %+v, want %+v", name, function, want)
+		}
+	}
+	if read := m.FunctionHandles[m.Functions[2].Handle]; len(read.TypeParameters) != 1 || read.TypeParameters[0] != Copy|Drop {
+		t.Fatalf("got type parameters %v for read", read.TypeParameters)
+	}
+
+	if len(m.Constants) != 1 || m.Constants[0].Type.Kind != TokenU64 || hex.EncodeToString(m.Constants[0].Data) != "0700000000000000" {
+		t.Fatalf("got constants %+v", m.Constants)
+	}
+}
+
+func TestDeserialize_Casts(t *testing.T) {
+	m, err := DeserializeHex(casts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.Version != 6 || len(m.Functions) != 1 || m.Functions[0].Instructions != 5 {
+		t.Fatalf("got version %d with functions %+v", m.Version, m.Functions)
+	}
+	widen := m.ABI().ExposedFunctions
+	if len(widen) != 1 || !reflect.DeepEqual(widen[0].Params, []string{"u8"}) || !reflect.DeepEqual(widen[0].Return, []string{"u256"}) {
+		t.Fatalf("got exposed functions %+v", widen)
+	}
+}
+
+func TestCompiledModule_ABI(t *testing.T) {
+	m, err := DeserializeHex(hello)
+	if err != nil {
+		t.Fatal(err)
+	}
+	abi := m.ABI()
+	if abi.Address != "0xcafe" || abi.Name != "hello" || !reflect.DeepEqual(abi.Friends, []string{"0xcafe::other"}) {
+		t.Fatalf("got ABI of %s::%s with friends %v", abi.Address, abi.Name, abi.Friends)
+	}
+	if len(abi.ExposedFunctions) != 2 {
+		t.Fatalf("got exposed functions %+v, want say and read", abi.ExposedFunctions)
+	}
+	say, read := abi.ExposedFunctions[0], abi.ExposedFunctions[1]
+	if say.Name != "say" || say.Visibility != "public" || !say.IsEntry ||
+		!reflect.DeepEqual(say.Params, []string{"&signer", "0x1::string::String"}) || len(say.Return) != 0 {
+		t.Fatalf("got %+v", say)
+	}
+	if read.Name != "read" || read.IsEntry || len(read.GenericTypeParams) != 1 ||
+		!reflect.DeepEqual(read.GenericTypeParams[0].Constraints, []string{"copy", "drop"}) ||
+		!reflect.DeepEqual(read.Params, []string{"address"}) || !reflect.DeepEqual(read.Return, []string{"vector<u8>"}) {
+		t.Fatalf("got %+v", read)
+	}
+	if len(abi.Structs) != 1 || abi.Structs[0].Name != "Message" || !reflect.DeepEqual(abi.Structs[0].Abilities, []string{"key"}) ||
+		len(abi.Structs[0].Fields) != 1 || abi.Structs[0].Fields[0].Type != "0x1::string::String" {
+		t.Fatalf("got structs %+v", abi.Structs)
+	}
+}
+
+func TestDeserialize_Rejects(t *testing.T) {
+	for name, code := range map[string]string{
+		"bad magic":   "deadbeef05000000",
+		"old version": "a11ceb0b02000000",
+		"truncated":   hello[:len(hello)-100],
+		"bad index":   hello[:len(hello)-2] + "05",
+		"bad opcode":  strings.Replace(casts, "4b4c4d02", "4b4c4e02", 1),
+	} {
+		if _, err := DeserializeHex(code); err == nil {
+			t.Fatalf("%s: got a module, want an error", name)
+		}
+	}
+}
+
+func TestSelfHandle(t *testing.T) {
+	for name, c := range map[string]struct {
+		code string
+		want ModuleHandle
+	}{
+		"supported":      {hello, ModuleHandle{Address: "0xcafe", Name: "hello"}},
+		"newer version":  {strings.Replace(casts, "a11ceb0b06000000", "a11ceb0b07000000", 1), ModuleHandle{Address: "0xcafe", Name: "casts"}},
+		"unknown opcode": {strings.Replace(casts, "4b4c4d02", "4b4c4e02", 1), ModuleHandle{Address: "0xcafe", Name: "casts"}},
+	} {
+		if _, err := DeserializeHex(c.code); name != "supported" && err == nil {
+			t.Fatalf("%s: got a module, want Deserialize to fail", name)
+		}
+		handle, err := SelfHandleHex(c.code)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if handle != c.want {
+			t.Fatalf("%s: got %s, want %s", name, handle, c.want)
+		}
+	}
+	for name, code := range map[string]string{
+		"bad magic":         "deadbeef05000000",
+		"truncated":         hello[:len(hello)-100],
+		"bad self handle":   hello[:len(hello)-2] + "05",
+		"truncated headers": hello[:40],
+	} {
+		if _, err := SelfHandleHex(code); err == nil {
+			t.Fatalf("%s: got a handle, want an error", name)
+		}
+	}
+}
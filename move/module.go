@@ -0,0 +1,217 @@
+//Package move Deserializes Move module bytecode into a typed representation and derives the module's ABI from it,
+//the way the fullnode does when it serves a module
+package move
+
+import (
+	"fmt"
+	"strings"
+)
+
+//CompiledModule A Move module as it is published. Handles and definitions are resolved, the indexes kept in them
+//point into the module's own slices, e.g. `StructDefinition.Handle` into `StructHandles`
+type CompiledModule struct {
+	//Version Bytecode version, from the binary's header
+	Version uint32
+	Self    ModuleHandle
+
+	ModuleHandles   []ModuleHandle
+	StructHandles   []StructHandle
+	FunctionHandles []FunctionHandle
+	Structs         []StructDefinition
+	Functions       []FunctionDefinition
+	Constants       []Constant
+	Friends         []ModuleHandle
+	Signatures      [][]SignatureToken
+	Identifiers     []string
+	Addresses       []string
+	Metadata        []Metadata
+}
+
+//ModuleHandle The id of a module, its own or one it uses
+type ModuleHandle struct {
+	Address string
+	Name    string
+}
+
+func (h ModuleHandle) String() string {
+	return fmt.Sprintf("%s::%s", h.Address, h.Name)
+}
+
+type StructHandle struct {
+	Module         ModuleHandle
+	Name           string
+	Abilities      AbilitySet
+	TypeParameters []StructTypeParameter
+}
+
+type StructTypeParameter struct {
+	Constraints AbilitySet
+	IsPhantom   bool
+}
+
+type FunctionHandle struct {
+	Module         ModuleHandle
+	Name           string
+	Parameters     []SignatureToken
+	Return         []SignatureToken
+	TypeParameters []AbilitySet
+}
+
+//StructDefinition A struct the module declares, `Fields` is empty for a native struct
+type StructDefinition struct {
+	Handle int
+	Native bool
+	Fields []FieldDefinition
+}
+
+type FieldDefinition struct {
+	Name string
+	Type SignatureToken
+}
+
+//FunctionDefinition A function the module declares. `Acquires` holds indexes into `Structs`,
+//`Instructions` is how many instructions its body has, 0 for a native function
+type FunctionDefinition struct {
+	Handle       int
+	Visibility   Visibility
+	IsEntry      bool
+	IsNative     bool
+	Acquires     []int
+	Locals       []SignatureToken
+	Instructions int
+}
+
+type Constant struct {
+	Type SignatureToken
+	//Data The BCS encoded value
+	Data []byte
+}
+
+type Metadata struct {
+	Key   []byte
+	Value []byte
+}
+
+type Visibility uint8
+
+const (
+	Private Visibility = 0x0
+	Public  Visibility = 0x1
+	//deprecatedScript The visibility of entry functions before bytecode version 5, read as public and entry
+	deprecatedScript Visibility = 0x2
+	Friend           Visibility = 0x3
+)
+
+func (v Visibility) String() string {
+	switch v {
+	case Private:
+		return "private"
+	case Public:
+		return "public"
+	case Friend:
+		return "friend"
+	default:
+		return fmt.Sprintf("visibility(%d)", uint8(v))
+	}
+}
+
+//AbilitySet The abilities of a type, or the constraints on a type parameter
+type AbilitySet uint8
+
+const (
+	Copy  AbilitySet = 0x1
+	Drop  AbilitySet = 0x2
+	Store AbilitySet = 0x4
+	Key   AbilitySet = 0x8
+)
+
+func (s AbilitySet) Has(ability AbilitySet) bool {
+	return s&ability == ability
+}
+
+//Names The abilities in the set, in the order the fullnode lists them
+func (s AbilitySet) Names() []string {
+	names := []string{}
+	for _, ability := range []struct {
+		ability AbilitySet
+		name    string
+	}{{Copy, "copy"}, {Drop, "drop"}, {Store, "store"}, {Key, "key"}} {
+		if s.Has(ability.ability) {
+			names = append(names, ability.name)
+		}
+	}
+	return names
+}
+
+//TokenKind The kind of a type in a signature, the values are the ones serialized in bytecode
+type TokenKind uint8
+
+const (
+	TokenBool                TokenKind = 0x1
+	TokenU8                  TokenKind = 0x2
+	TokenU64                 TokenKind = 0x3
+	TokenU128                TokenKind = 0x4
+	TokenAddress             TokenKind = 0x5
+	TokenReference           TokenKind = 0x6
+	TokenMutableReference    TokenKind = 0x7
+	TokenStruct              TokenKind = 0x8
+	TokenTypeParameter       TokenKind = 0x9
+	TokenVector              TokenKind = 0xA
+	TokenStructInstantiation TokenKind = 0xB
+	TokenSigner              TokenKind = 0xC
+	TokenU16                 TokenKind = 0xD
+	TokenU32                 TokenKind = 0xE
+	TokenU256                TokenKind = 0xF
+)
+
+//SignatureToken A type in a signature. `Struct` is an index into `StructHandles` for structs,
+//`TypeParameter` the index of a type parameter, `Inner` the element of a vector or the referenced type
+type SignatureToken struct {
+	Kind          TokenKind
+	Struct        int
+	TypeArguments []SignatureToken
+	TypeParameter int
+	Inner         *SignatureToken
+}
+
+var primitiveNames = map[TokenKind]string{
+	TokenBool:    "bool",
+	TokenU8:      "u8",
+	TokenU16:     "u16",
+	TokenU32:     "u32",
+	TokenU64:     "u64",
+	TokenU128:    "u128",
+	TokenU256:    "u256",
+	TokenAddress: "address",
+	TokenSigner:  "signer",
+}
+
+//TypeString Formats a type the way the fullnode does in an ABI, e.g. "&mut 0x1::coin::Coin<T0>"
+func (m *CompiledModule) TypeString(token SignatureToken) string {
+	if name, ok := primitiveNames[token.Kind]; ok {
+		return name
+	}
+	switch token.Kind {
+	case TokenReference:
+		return "&" + m.TypeString(*token.Inner)
+	case TokenMutableReference:
+		return "&mut " + m.TypeString(*token.Inner)
+	case TokenVector:
+		return fmt.Sprintf("vector<%s>", m.TypeString(*token.Inner))
+	case TokenTypeParameter:
+		return fmt.Sprintf("T%d", token.TypeParameter)
+	case TokenStruct, TokenStructInstantiation:
+		handle := m.StructHandles[token.Struct]
+		name := fmt.Sprintf("%s::%s", handle.Module, handle.Name)
+		if len(token.TypeArguments) == 0 {
+			return name
+		}
+		var arguments []string
+		for _, argument := range token.TypeArguments {
+			arguments = append(arguments, m.TypeString(argument))
+		}
+		return fmt.Sprintf("%s<%s>", name, strings.Join(arguments, ", "))
+	default:
+		return fmt.Sprintf("unknown(%d)", uint8(token.Kind))
+	}
+}
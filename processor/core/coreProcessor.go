@@ -0,0 +1,123 @@
+package core
+
+import (
+	"apotscan/logger"
+	"apotscan/types"
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/go-redis/redis/v8"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"strconv"
+)
+
+//insertBatchSize Rows per insert, keeps a large fetch size under the databases' limits on placeholders
+const insertBatchSize = 500
+
+//CoreTransactionProcessor Stores every transaction, of every type and failed ones included, into `transactions`
+type CoreTransactionProcessor struct {
+	db       *gorm.DB
+	redisCli *redis.Client
+	chainId  uint8
+	name     string
+	logger   *logger.Logger
+}
+
+func New(name string, redisCli *redis.Client, db *gorm.DB, chainId uint8, logConf *logger.Config) (*CoreTransactionProcessor, error) {
+	_logger, err := logger.New(logConf)
+	if err != nil {
+		return nil, err
+	}
+	return &CoreTransactionProcessor{
+		db:       db,
+		redisCli: redisCli,
+		chainId:  chainId,
+		name:     name,
+		logger:   _logger,
+	}, nil
+}
+
+func (cp *CoreTransactionProcessor) Name() string {
+	return cp.name
+}
+
+func (cp *CoreTransactionProcessor) ChainId() uint8 {
+	return cp.chainId
+}
+
+func (cp *CoreTransactionProcessor) GetDB() *gorm.DB {
+	return cp.db
+}
+
+func (cp *CoreTransactionProcessor) GetRedis() *redis.Client {
+	return cp.redisCli
+}
+
+func (cp *CoreTransactionProcessor) GetLogger() *logger.Logger {
+	return cp.logger
+}
+
+func (cp *CoreTransactionProcessor) ProcessesFailed() bool {
+	return true
+}
+
+func (cp *CoreTransactionProcessor) ProcessTransactions(ctx context.Context, db *gorm.DB, txs []types.Transaction, startVersion, endVersion int64) (*types.ProcessResult, error) {
+	var rows []*types.TransactionInDB
+	for _, tx := range txs {
+		row, err := getTransaction(tx)
+		if err != nil {
+			return nil, fmt.Errorf("tx %d: %w", tx.Version, err)
+		}
+		rows = append(rows, row)
+	}
+	// transactions never change, a replayed version keeps the row stored
+	if len(rows) > 0 {
+		if err := db.Clauses(clause.OnConflict{DoNothing: true}).CreateInBatches(&rows, insertBatchSize).Error; err != nil {
+			return nil, fmt.Errorf("save transactions: %w", err)
+		}
+	}
+	return types.NewProcessResult(cp.Name(), startVersion, endVersion), nil
+}
+
+func getTransaction(tx types.Transaction) (*types.TransactionInDB, error) {
+	row := &types.TransactionInDB{
+		Version:             tx.Version,
+		Hash:                tx.Hash,
+		Type:                tx.Type,
+		StateRootHash:       tx.StateRootHash,
+		EventRootHash:       tx.EventRootHash,
+		AccumulatorRootHash: tx.AccumulatorRootHash,
+		Success:             tx.Success,
+		VMStatus:            tx.VMStatus,
+	}
+	var err error
+	if row.GasUsed, err = parseInt(tx.GasUsed); err != nil {
+		return nil, fmt.Errorf("gas used: %w", err)
+	}
+	if row.Timestamp, err = parseInt(tx.Timestamp); err != nil {
+		return nil, fmt.Errorf("timestamp: %w", err)
+	}
+	if tx.Type != types.UserTransaction {
+		return row, nil
+	}
+	row.Sender = tx.Sender
+	if row.SequenceNumber, err = parseInt(tx.SequenceNumber); err != nil {
+		return nil, fmt.Errorf("sequence number: %w", err)
+	}
+	if tx.Payload.Type == types.EntryFunctionPayload {
+		row.EntryFunction = tx.Payload.Function
+	}
+	if row.Payload, err = json.Marshal(tx.Payload); err != nil {
+		return nil, err
+	}
+	return row, nil
+}
+
+//parseInt The fullnode writes u64s as strings, some transaction types leave them empty
+func parseInt(s string) (int64, error) {
+	if s == "" {
+		return 0, nil
+	}
+	return strconv.ParseInt(s, 10, 64)
+}
@@ -0,0 +1,145 @@
+package core
+
+import (
+	"apotscan/logger"
+	"apotscan/migration"
+	"apotscan/types"
+	"context"
+	"encoding/json"
+	aptos "github.com/portto/aptos-go-sdk/client"
+	log "github.com/sirupsen/logrus"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	gormlogger "gorm.io/gorm/logger"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+func TestCoreTransactionProcessor_StoresEveryTransaction(t *testing.T) {
+	ctx := context.Background()
+	db, err := gorm.Open(sqlite.Open(filepath.Join(t.TempDir(), "test.db")), &gorm.Config{
+		Logger: gormlogger.Default.LogMode(gormlogger.Silent),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = migration.New(db, migration.Registered()...).Up(ctx); err != nil {
+		t.Fatal(err)
+	}
+	data, err := ioutil.ReadFile("testdata/transactions.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var resps []aptos.TransactionResp
+	if err = json.Unmarshal(data, &resps); err != nil {
+		t.Fatal(err)
+	}
+	var txs []types.Transaction
+	for _, resp := range resps {
+		var tx types.Transaction
+		if err = tx.FromAptos(resp); err != nil {
+			t.Fatal(err)
+		}
+		txs = append(txs, tx)
+	}
+	processor, err := New("core", nil, db, 4, &logger.Config{Level: log.FatalLevel, FilePath: filepath.Join(t.TempDir(), "aptoscan")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !processor.ProcessesFailed() {
+		t.Fatal("core processor has to be handed failed transactions")
+	}
+
+	for i := 0; i < 2; i++ {
+		if _, err = processor.ProcessTransactions(ctx, db, txs, 0, 4); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var rows []types.TransactionInDB
+	if err = db.Order("version").Find(&rows).Error; err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != 5 {
+		t.Fatalf("got %d transactions, want all 5", len(rows))
+	}
+	for i, want := range []string{types.GenesisTransaction, types.BlockMetadataTransaction, types.UserTransaction, types.UserTransaction, types.StateCheckpointTransaction} {
+		if rows[i].Type != want || rows[i].Version != int64(i) || rows[i].StateRootHash == "" || rows[i].AccumulatorRootHash == "" {
+			t.Fatalf("got %+v at %d, want a %s", rows[i], i, want)
+		}
+	}
+	if rows[1].Sender != "" || rows[1].Payload != nil || rows[1].Timestamp != 1663000000000000 {
+		t.Fatalf("got block metadata %+v", rows[1])
+	}
+
+	var failed types.TransactionInDB
+	if err = db.Where("hash = ?", "0x03").First(&failed).Error; err != nil {
+		t.Fatal(err)
+	}
+	if failed.Success || failed.GasUsed != 5 || failed.VMStatus == "" || failed.EntryFunction != "0x1::coin::transfer" ||
+		failed.Sender != "0xcafe" || failed.SequenceNumber != 1 || failed.Timestamp != 1663000002000000 || failed.EventRootHash != "0xb3" {
+		t.Fatalf("got failed transaction %+v", failed)
+	}
+	var payload types.JSONPayload
+	if err = json.Unmarshal(failed.Payload, &payload); err != nil {
+		t.Fatal(err)
+	}
+	if payload.Function != "0x1::coin::transfer" || len(payload.Arguments) != 2 {
+		t.Fatalf("got payload %+v", payload)
+	}
+
+	var sent []types.TransactionInDB
+	if err = db.Where("sender = ?", "0xcafe").Order("sequence_number").Find(&sent).Error; err != nil {
+		t.Fatal(err)
+	}
+	if len(sent) != 2 || sent[0].Version != 2 || sent[1].Version != 3 {
+		t.Fatalf("got %+v sent by 0xcafe, want versions 2 and 3", sent)
+	}
+}
+
+func TestMigrations_KeepUserTransactions(t *testing.T) {
+	ctx := context.Background()
+	db, err := gorm.Open(sqlite.Open(filepath.Join(t.TempDir(), "test.db")), &gorm.Config{
+		Logger: gormlogger.Default.LogMode(gormlogger.Silent),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var legacy []migration.Migration
+	for _, m := range migration.Registered() {
+		if m.Version < 202210050300 {
+			legacy = append(legacy, m)
+		}
+	}
+	if _, err = migration.New(db, legacy...).Up(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if err = db.Create(&transactionV2{Type: types.UserTransaction, Version: 7, Hash: "0x07", GasUsed: 3, Success: true}).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	migrator := migration.New(db, migration.Registered()...)
+	if _, err = migrator.Up(ctx); err != nil {
+		t.Fatal(err)
+	}
+	var rows []types.TransactionInDB
+	if err = db.Find(&rows).Error; err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != 1 || rows[0].Version != 7 || rows[0].Hash != "0x07" || rows[0].GasUsed != 3 || !rows[0].Success {
+		t.Fatalf("got %+v after migrating, want the user transaction", rows)
+	}
+
+	if _, err = migrator.Down(ctx, 1); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = migrator.Up(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if err = db.Find(&rows).Error; err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != 1 || rows[0].Hash != "0x07" {
+		t.Fatalf("got %+v after migrating down and up again", rows)
+	}
+}
@@ -0,0 +1,65 @@
+package core
+
+import (
+	"apotscan/migration"
+	"gorm.io/gorm"
+	"time"
+)
+
+func init() {
+	migration.Register(migration.Migration{
+		Version: 202210050300,
+		Name:    "add transactions columns for every transaction type",
+		Up: func(db *gorm.DB) error {
+			return migration.RebuildTables(db, &transactionV3{})
+		},
+		Down: func(db *gorm.DB) error {
+			return migration.RebuildTables(db, &transactionV2{})
+		},
+	})
+}
+
+//transactionV2 The keyed user transactions table created by the types package
+type transactionV2 struct {
+	Type                string
+	Payload             []byte
+	Version             int64 `gorm:"primaryKey;autoIncrement:false"`
+	Hash                string
+	StateRootHash       string
+	EventRootHash       string
+	GasUsed             int64
+	Success             bool
+	VMStatus            string
+	AccumulatorRootHash string
+
+	CreatedAt *time.Time `gorm:"autoCreateTime"`
+	UpdatedAt *time.Time `gorm:"autoUpdateTime;not null"`
+}
+
+func (transactionV2) TableName() string {
+	return "transactions"
+}
+
+type transactionV3 struct {
+	Version             int64  `gorm:"primaryKey;autoIncrement:false"`
+	Hash                string `gorm:"size:66;uniqueIndex"`
+	Type                string `gorm:"size:64"`
+	Sender              string `gorm:"size:66;index:idx_transactions_sender,priority:1"`
+	SequenceNumber      int64  `gorm:"index:idx_transactions_sender,priority:2"`
+	EntryFunction       string
+	Payload             []byte
+	StateRootHash       string `gorm:"size:66"`
+	EventRootHash       string `gorm:"size:66"`
+	AccumulatorRootHash string `gorm:"size:66"`
+	GasUsed             int64
+	Success             bool
+	VMStatus            string
+	Timestamp           int64
+
+	CreatedAt *time.Time `gorm:"autoCreateTime"`
+	UpdatedAt *time.Time `gorm:"autoUpdateTime;not null"`
+}
+
+func (transactionV3) TableName() string {
+	return "transactions"
+}
@@ -0,0 +1,90 @@
+package module
+
+import (
+	"apotscan/migration"
+	"gorm.io/gorm"
+	"time"
+)
+
+func init() {
+	migration.Register(migration.Migration{
+		Version: 202209010200,
+		Name:    "create modules",
+		Up: func(db *gorm.DB) error {
+			return migration.CreateTables(db, &moduleV1{})
+		},
+		Down: func(db *gorm.DB) error {
+			return migration.DropTables(db, &moduleV1{})
+		},
+	}, migration.Migration{
+		Version: 202209250200,
+		Name:    "add modules key",
+		Up: func(db *gorm.DB) error {
+			return migration.RebuildTables(db, &moduleV2{})
+		},
+		Down: func(db *gorm.DB) error {
+			return migration.RebuildTables(db, &moduleV1{})
+		},
+	}, migration.Migration{
+		Version: 202210030200,
+		Name:    "store module publications",
+		// nothing has ever been written to modules, the table is replaced rather than rebuilt
+		Up: func(db *gorm.DB) error {
+			if err := migration.DropTables(db, &moduleV2{}); err != nil {
+				return err
+			}
+			return migration.CreateTables(db, &moduleV3{})
+		},
+		Down: func(db *gorm.DB) error {
+			if err := migration.DropTables(db, &moduleV3{}); err != nil {
+				return err
+			}
+			return migration.CreateTables(db, &moduleV2{})
+		},
+	})
+}
+
+type moduleV1 struct {
+	Creator     string
+	Module      string
+	Name        string
+	Description string
+	Version     int64
+}
+
+func (moduleV1) TableName() string {
+	return "modules"
+}
+
+type moduleV2 struct {
+	Creator     string `gorm:"primaryKey;size:66"`
+	Module      string
+	Name        string `gorm:"primaryKey;size:255"`
+	Description string
+	Version     int64 `gorm:"primaryKey;autoIncrement:false"`
+}
+
+func (moduleV2) TableName() string {
+	return "modules"
+}
+
+type moduleV3 struct {
+	Address          string `gorm:"primaryKey;size:66"`
+	Name             string `gorm:"primaryKey;size:255"`
+	Version          int64  `gorm:"primaryKey;autoIncrement:false"`
+	BytecodeHash     string `gorm:"size:64;index"`
+	Bytecode         string
+	ABI              string
+	Friends          string
+	ExposedFunctions string
+	Structs          string
+	Publisher        string `gorm:"size:66;index"`
+	Timestamp        int64
+
+	CreatedAt *time.Time `gorm:"autoCreateTime"`
+	UpdatedAt *time.Time `gorm:"autoUpdateTime;not null"`
+}
+
+func (moduleV3) TableName() string {
+	return "modules"
+}
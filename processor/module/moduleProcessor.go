@@ -2,10 +2,20 @@ package module
 
 import (
 	"apotscan/logger"
+	"apotscan/move"
 	"apotscan/types"
 	"apotscan/types/module"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
 	"github.com/go-redis/redis/v8"
+	log "github.com/sirupsen/logrus"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"strconv"
+	"strings"
 )
 
 type ModuleTransactionProcessor struct {
@@ -50,11 +60,35 @@ func (mp *ModuleTransactionProcessor) GetLogger() *logger.Logger {
 	return mp.logger
 }
 
-func (mp *ModuleTransactionProcessor) ProcessTransactions(txs []types.Transaction, startVersion, endVersion int64) (*types.ProcessResult, error) {
-	var modulse []module.Module
+//filter Transactions publishing modules, module bundles write their modules as well
+var filter = &types.TransactionFilter{
+	ChangeTypes: []string{types.WriteModuleChange},
+}
+
+func (mp *ModuleTransactionProcessor) Filter() *types.TransactionFilter {
+	return filter
+}
+
+//ProcessTransactions Stores every module published, an upgrade is stored as a new row next to the versions before it
+func (mp *ModuleTransactionProcessor) ProcessTransactions(ctx context.Context, db *gorm.DB, txs []types.Transaction, startVersion, endVersion int64) (*types.ProcessResult, error) {
+	var modules []*module.Module
 	for _, tx := range txs {
-		if tx.Type != types.UserTransaction || tx.Payload.Type != types.ModuleBundlePayload {
-			continue
+		published := make(map[string]bool)
+		for _, code := range publishedCode(tx) {
+			m, err := mp.getModule(code, tx)
+			if err != nil {
+				return nil, fmt.Errorf("tx %d module at %s: %w", tx.Version, code.address, err)
+			}
+			if key := m.Address + "::" + m.Name; !published[key] {
+				published[key] = true
+				modules = append(modules, m)
+			}
+		}
+	}
+	// a replayed publication is the same row, keep the one stored
+	if len(modules) > 0 {
+		if err := db.Clauses(clause.OnConflict{DoNothing: true}).Create(&modules).Error; err != nil {
+			return nil, fmt.Errorf("save modules: %w", err)
 		}
 	}
 	return &types.ProcessResult{
@@ -63,3 +97,103 @@ func (mp *ModuleTransactionProcessor) ProcessTransactions(txs []types.Transactio
 		EndVersion:   endVersion,
 	}, nil
 }
+
+//code Bytecode published at an address, the ABI is the one the fullnode derived, nil if it didn't
+type code struct {
+	address  string
+	bytecode string
+	abi      interface{}
+}
+
+//publishedCode Every module a transaction writes, from its write set and from a module bundle payload
+func publishedCode(tx types.Transaction) []code {
+	var codes []code
+	for _, change := range tx.Changes {
+		if m, ok := change.Data.(types.WriteModule); ok {
+			codes = append(codes, code{address: m.Address, bytecode: m.Bytecode, abi: m.ABI})
+		}
+	}
+	if tx.Payload.Type == types.ModuleBundlePayload {
+		for _, m := range tx.Payload.Modules {
+			codes = append(codes, code{address: tx.Sender, bytecode: m.Bytecode, abi: m.ABI})
+		}
+	}
+	return codes
+}
+
+//getModule The row of a publication, the ABI is derived from the bytecode when the fullnode didn't supply it.
+//A module the deserializer can't read is stored without an ABI under the name in its self module handle,
+//the transaction fails if not even that can be read
+func (mp *ModuleTransactionProcessor) getModule(code code, tx types.Transaction) (*module.Module, error) {
+	var abi module.ABI
+	var abiData []byte
+	var err error
+	if code.abi != nil {
+		if abiData, err = json.Marshal(code.abi); err != nil {
+			return nil, err
+		}
+		if err = json.Unmarshal(abiData, &abi); err != nil {
+			return nil, err
+		}
+	}
+	if abi.Name == "" {
+		if compiled, err := move.DeserializeHex(code.bytecode); err != nil {
+			self, selfErr := move.SelfHandleHex(code.bytecode)
+			if selfErr != nil {
+				return nil, fmt.Errorf("derive ABI: %v, read module name: %w", err, selfErr)
+			}
+			mp.logger.WithFields(log.Fields{
+				"version": tx.Version,
+				"address": code.address,
+				"module":  self.Name,
+				"error":   err,
+			}).Warn("can not derive module ABI")
+			abi.Address, abi.Name = self.Address, self.Name
+		} else {
+			abi = compiled.ABI()
+			if abiData, err = json.Marshal(abi); err != nil {
+				return nil, err
+			}
+		}
+	}
+	bytecode, err := hex.DecodeString(strings.TrimPrefix(code.bytecode, "0x"))
+	if err != nil {
+		return nil, err
+	}
+	hash := sha256.Sum256(bytecode)
+	friends, err := json.Marshal(abi.Friends)
+	if err != nil {
+		return nil, err
+	}
+	functions, err := json.Marshal(abi.ExposedFunctions)
+	if err != nil {
+		return nil, err
+	}
+	structs, err := json.Marshal(abi.Structs)
+	if err != nil {
+		return nil, err
+	}
+	address := abi.Address
+	if address == "" {
+		address = code.address
+	}
+	var timestamp int64
+	if tx.Timestamp != "" {
+		if timestamp, err = strconv.ParseInt(tx.Timestamp, 10, 64); err != nil {
+			return nil, err
+		}
+	}
+	return &module.Module{
+		Address:          address,
+		Name:             abi.Name,
+		Version:          tx.Version,
+		BytecodeHash:     hex.EncodeToString(hash[:]),
+		Bytecode:         code.bytecode,
+		ABI:              string(abiData),
+		Friends:          string(friends),
+		ExposedFunctions: string(functions),
+		Structs:          string(structs),
+		Publisher:        tx.Sender,
+		Timestamp:        timestamp,
+	}, nil
+}
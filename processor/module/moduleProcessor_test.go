@@ -0,0 +1,171 @@
+package module
+
+import (
+	"apotscan/logger"
+	"apotscan/migration"
+	"apotscan/types"
+	"apotscan/types/module"
+	"context"
+	"encoding/json"
+	"fmt"
+	aptos "github.com/portto/aptos-go-sdk/client"
+	log "github.com/sirupsen/logrus"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	gormlogger "gorm.io/gorm/logger"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+//newTestDB A migrated sqlite database in a temporary directory
+func newTestDB(t *testing.T) *gorm.DB {
+	db, err := gorm.Open(sqlite.Open(filepath.Join(t.TempDir(), "test.db")), &gorm.Config{
+		Logger: gormlogger.Default.LogMode(gormlogger.Silent),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = migration.New(db, migration.Registered()...).Up(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+//loadTransactions The transactions of testdata/transactions.json: 0xcafe::hello published at 10, without an ABI at 12
+//and upgraded at 20, then 0xcafe::world published through its write set only at 21
+func loadTransactions(t *testing.T) []types.Transaction {
+	data, err := ioutil.ReadFile("testdata/transactions.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var resps []aptos.TransactionResp
+	if err = json.Unmarshal(data, &resps); err != nil {
+		t.Fatal(err)
+	}
+	var txs []types.Transaction
+	for _, resp := range resps {
+		var tx types.Transaction
+		if err = tx.FromAptos(resp); err != nil {
+			t.Fatal(err)
+		}
+		txs = append(txs, tx)
+	}
+	return txs
+}
+
+func newTestProcessor(t *testing.T, db *gorm.DB) *ModuleTransactionProcessor {
+	processor, err := New("module", nil, db, 4, &logger.Config{Level: log.FatalLevel, FilePath: filepath.Join(t.TempDir(), "aptoscan")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return processor
+}
+
+func TestModuleTransactionProcessor_KeepsEveryPublication(t *testing.T) {
+	ctx := context.Background()
+	db := newTestDB(t)
+	txs := loadTransactions(t)
+	processor := newTestProcessor(t, db)
+	var err error
+	txs = processor.Filter().Apply(txs)
+	if len(txs) != 4 {
+		t.Fatalf("got %d transactions through the filter, want the 4 writing modules", len(txs))
+	}
+
+	for i := 0; i < 2; i++ {
+		if _, err = processor.ProcessTransactions(ctx, db, txs, 10, 20); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var modules []module.Module
+	if err = db.Order("version").Find(&modules).Error; err != nil {
+		t.Fatal(err)
+	}
+	if len(modules) != 4 {
+		t.Fatalf("got %d modules, want every publication of 0xcafe::hello and 0xcafe::world", len(modules))
+	}
+	first, derived, upgrade, world := modules[0], modules[1], modules[2], modules[3]
+	if world.Address != "0xcafe" || world.Name != "world" || world.Version != 21 {
+		t.Fatalf("got %+v, want the publication at version 21 found through the write set", world)
+	}
+	if first.Address != "0xcafe" || first.Name != "hello" || first.Version != 10 || first.Publisher != "0xcafe" || first.Timestamp != 1663000000000000 {
+		t.Fatalf("got first publication %+v", first)
+	}
+	if derived.Address != "0xcafe" || derived.Name != "hello" || derived.Version != 12 {
+		t.Fatalf("got %+v, want the publication without an ABI at version 12", derived)
+	}
+	var abi module.ABI
+	if err = json.Unmarshal([]byte(derived.ABI), &abi); err != nil {
+		t.Fatal(err)
+	}
+	if len(abi.ExposedFunctions) != 2 || abi.ExposedFunctions[0].Name != "say" || !abi.ExposedFunctions[0].IsEntry || len(abi.Friends) != 1 {
+		t.Fatalf("got derived ABI %+v", abi)
+	}
+	if upgrade.Version != 20 || upgrade.BytecodeHash == first.BytecodeHash {
+		t.Fatalf("got upgrade %+v, want new bytecode at version 20", upgrade)
+	}
+	var functions []module.Function
+	if err = json.Unmarshal([]byte(upgrade.ExposedFunctions), &functions); err != nil {
+		t.Fatal(err)
+	}
+	if len(functions) != 2 || functions[1].Name != "read" || functions[1].Return[0] != "0x1::string::String" {
+		t.Fatalf("got functions %+v", functions)
+	}
+	var structs []module.Struct
+	if err = json.Unmarshal([]byte(first.Structs), &structs); err != nil {
+		t.Fatal(err)
+	}
+	if len(structs) != 1 || structs[0].Abilities[0] != "key" || structs[0].Fields[0].Name != "message" {
+		t.Fatalf("got structs %+v", structs)
+	}
+}
+
+//newerModule Bytecode of an empty module `0xcafe::name` in version 7, which the deserializer doesn't support
+func newerModule(name string) string {
+	identifiers := fmt.Sprintf("%02x%x", len(name), name)
+	address := strings.Repeat("0", 60) + "cafe"
+	return "0xa11ceb0b07000000" + "03" +
+		fmt.Sprintf("0700%02x", len(identifiers)/2) + fmt.Sprintf("08%02x20", len(identifiers)/2) + fmt.Sprintf("01%02x02", len(identifiers)/2+32) +
+		identifiers + address + "0000" + "00"
+}
+
+func TestModuleTransactionProcessor_StoresUnreadableModules(t *testing.T) {
+	ctx := context.Background()
+	db := newTestDB(t)
+	processor := newTestProcessor(t, db)
+	txs := []types.Transaction{{
+		Version: 30,
+		Success: true,
+		Sender:  "0xcafe",
+		Changes: []types.Change{{
+			Type: types.WriteModuleChange,
+			Data: types.WriteModule{Address: "0xcafe", Bytecode: newerModule("first")},
+		}, {
+			Type: types.WriteModuleChange,
+			Data: types.WriteModule{Address: "0xcafe", Bytecode: newerModule("second")},
+		}},
+	}}
+	if _, err := processor.ProcessTransactions(ctx, db, txs, 30, 30); err != nil {
+		t.Fatal(err)
+	}
+	var modules []module.Module
+	if err := db.Order("name").Find(&modules).Error; err != nil {
+		t.Fatal(err)
+	}
+	if len(modules) != 2 {
+		t.Fatalf("got %+v, want both modules of the transaction", modules)
+	}
+	for i, name := range []string{"first", "second"} {
+		if m := modules[i]; m.Address != "0xcafe" || m.Name != name || m.Version != 30 || m.ABI != "" || m.BytecodeHash == "" {
+			t.Fatalf("got %+v, want 0xcafe::%s stored without an ABI", m, name)
+		}
+	}
+
+	txs[0].Version = 31
+	txs[0].Changes[1].Data = types.WriteModule{Address: "0xcafe", Bytecode: "0xa11ceb0b07000000"}
+	if _, err := processor.ProcessTransactions(ctx, db, txs, 31, 31); err == nil {
+		t.Fatal("got a module without a name stored, want the transaction to fail")
+	}
+}
@@ -0,0 +1,348 @@
+package module
+
+import (
+	"apotscan/types/module"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"gorm.io/gorm"
+	"sort"
+	"strings"
+)
+
+type DiffKind string
+
+const (
+	Added   DiffKind = "added"
+	Removed DiffKind = "removed"
+	Changed DiffKind = "changed"
+)
+
+//Change A single difference of a function or struct between two publications. A breaking change would be rejected
+//by the chain's upgrade compatibility check, or breaks the modules and transactions depending on the old version
+type Change struct {
+	Description string
+	Breaking    bool
+}
+
+//MemberDiff How a function, struct or friend differs between two publications, `Changes` is empty for added members
+//and friends
+type MemberDiff struct {
+	Name    string
+	Kind    DiffKind
+	Changes []Change
+}
+
+func (d MemberDiff) Breaking() bool {
+	for _, change := range d.Changes {
+		if change.Breaking {
+			return true
+		}
+	}
+	return false
+}
+
+//UpgradeDiff What changed between the publications of a module at versions `From` and `To`.
+//Only what the ABIs expose is compared, private functions which aren't entry functions are not part of an ABI
+type UpgradeDiff struct {
+	Address   string
+	Name      string
+	From      int64
+	To        int64
+	Functions []MemberDiff
+	Structs   []MemberDiff
+	Friends   []MemberDiff
+}
+
+//Compatible Whether the upgrade keeps the compatibility the chain enforces: public and entry functions keep their
+//signatures, structs keep their layout and abilities
+func (d *UpgradeDiff) Compatible() bool {
+	for _, members := range [][]MemberDiff{d.Functions, d.Structs, d.Friends} {
+		for _, member := range members {
+			if member.Breaking() {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+//Diff Compares two ABIs of the same module
+func Diff(old, new module.ABI) *UpgradeDiff {
+	diff := &UpgradeDiff{Address: new.Address, Name: new.Name}
+
+	var functionNames []string
+	oldFunctions, newFunctions := make(map[string]module.Function), make(map[string]module.Function)
+	for _, f := range old.ExposedFunctions {
+		oldFunctions[f.Name] = f
+		functionNames = append(functionNames, f.Name)
+	}
+	for _, f := range new.ExposedFunctions {
+		newFunctions[f.Name] = f
+		functionNames = append(functionNames, f.Name)
+	}
+	for _, name := range sorted(functionNames) {
+		o, inOld := oldFunctions[name]
+		n, inNew := newFunctions[name]
+		switch {
+		case !inOld:
+			diff.Functions = append(diff.Functions, MemberDiff{Name: name, Kind: Added})
+		case !inNew:
+			diff.Functions = append(diff.Functions, MemberDiff{Name: name, Kind: Removed, Changes: []Change{{
+				Description: fmt.Sprintf("%s function removed", describe(o)),
+				Breaking:    linked(o),
+			}}})
+		default:
+			if changes := functionChanges(o, n); len(changes) > 0 {
+				diff.Functions = append(diff.Functions, MemberDiff{Name: name, Kind: Changed, Changes: changes})
+			}
+		}
+	}
+
+	var structNames []string
+	oldStructs, newStructs := make(map[string]module.Struct), make(map[string]module.Struct)
+	for _, s := range old.Structs {
+		oldStructs[s.Name] = s
+		structNames = append(structNames, s.Name)
+	}
+	for _, s := range new.Structs {
+		newStructs[s.Name] = s
+		structNames = append(structNames, s.Name)
+	}
+	for _, name := range sorted(structNames) {
+		o, inOld := oldStructs[name]
+		n, inNew := newStructs[name]
+		switch {
+		case !inOld:
+			diff.Structs = append(diff.Structs, MemberDiff{Name: name, Kind: Added})
+		case !inNew:
+			diff.Structs = append(diff.Structs, MemberDiff{Name: name, Kind: Removed, Changes: []Change{{Description: "struct removed", Breaking: true}}})
+		default:
+			if changes := structChanges(o, n); len(changes) > 0 {
+				diff.Structs = append(diff.Structs, MemberDiff{Name: name, Kind: Changed, Changes: changes})
+			}
+		}
+	}
+
+	oldFriends, newFriends := make(map[string]bool), make(map[string]bool)
+	for _, friend := range old.Friends {
+		oldFriends[friend] = true
+	}
+	for _, friend := range new.Friends {
+		newFriends[friend] = true
+	}
+	// the chain doesn't check friends on upgrade, a removed friend fails to link on its own next upgrade
+	for _, name := range sorted(append(append([]string{}, old.Friends...), new.Friends...)) {
+		if !oldFriends[name] {
+			diff.Friends = append(diff.Friends, MemberDiff{Name: name, Kind: Added})
+		} else if !newFriends[name] {
+			diff.Friends = append(diff.Friends, MemberDiff{Name: name, Kind: Removed})
+		}
+	}
+	return diff
+}
+
+//linked Whether other modules or transactions may call the function, its signature can't change on upgrade then
+func linked(f module.Function) bool {
+	return f.Visibility == "public" || f.IsEntry
+}
+
+func describe(f module.Function) string {
+	if f.IsEntry {
+		return f.Visibility + " entry"
+	}
+	return f.Visibility
+}
+
+func functionChanges(old, new module.Function) []Change {
+	var changes []Change
+	breaking := linked(old)
+	if old.Visibility != new.Visibility {
+		changes = append(changes, Change{
+			Description: fmt.Sprintf("visibility %s -> %s", old.Visibility, new.Visibility),
+			Breaking:    old.Visibility == "public",
+		})
+	}
+	if old.IsEntry != new.IsEntry {
+		description := "entry added"
+		if old.IsEntry {
+			description = "entry removed"
+		}
+		changes = append(changes, Change{Description: description, Breaking: old.IsEntry})
+	}
+	if !equal(old.Params, new.Params) {
+		changes = append(changes, Change{
+			Description: fmt.Sprintf("params (%s) -> (%s)", strings.Join(old.Params, ", "), strings.Join(new.Params, ", ")),
+			Breaking:    breaking,
+		})
+	}
+	if !equal(old.Return, new.Return) {
+		changes = append(changes, Change{
+			Description: fmt.Sprintf("return (%s) -> (%s)", strings.Join(old.Return, ", "), strings.Join(new.Return, ", ")),
+			Breaking:    breaking,
+		})
+	}
+	if change, ok := typeParamsChange(old.GenericTypeParams, new.GenericTypeParams); ok {
+		change.Breaking = change.Breaking && breaking
+		changes = append(changes, change)
+	}
+	return changes
+}
+
+func structChanges(old, new module.Struct) []Change {
+	var changes []Change
+	if old.IsNative != new.IsNative {
+		changes = append(changes, Change{Description: fmt.Sprintf("native %t -> %t", old.IsNative, new.IsNative), Breaking: true})
+	}
+	if !equal(old.Abilities, new.Abilities) {
+		// abilities may be added, removing one breaks the code relying on it
+		changes = append(changes, Change{
+			Description: fmt.Sprintf("abilities {%s} -> {%s}", strings.Join(old.Abilities, ", "), strings.Join(new.Abilities, ", ")),
+			Breaking:    !subset(old.Abilities, new.Abilities),
+		})
+	}
+	if !equal(fields(old.Fields), fields(new.Fields)) {
+		changes = append(changes, Change{
+			Description: fmt.Sprintf("fields {%s} -> {%s}", strings.Join(fields(old.Fields), ", "), strings.Join(fields(new.Fields), ", ")),
+			Breaking:    true,
+		})
+	}
+	if change, ok := typeParamsChange(old.GenericTypeParams, new.GenericTypeParams); ok {
+		changes = append(changes, change)
+	}
+	return changes
+}
+
+//typeParamsChange Type parameters may only be relaxed: their number stays, constraints may be dropped
+//and a phantom parameter may not become a real one
+func typeParamsChange(old, new []module.GenericTypeParam) (Change, bool) {
+	if len(old) == len(new) {
+		same, breaking := true, false
+		for i := range old {
+			if !equal(old[i].Constraints, new[i].Constraints) || old[i].IsPhantom != new[i].IsPhantom {
+				same = false
+			}
+			if !subset(new[i].Constraints, old[i].Constraints) || (old[i].IsPhantom && !new[i].IsPhantom) {
+				breaking = true
+			}
+		}
+		if same {
+			return Change{}, false
+		}
+		return Change{Description: fmt.Sprintf("type parameters <%s> -> <%s>", typeParams(old), typeParams(new)), Breaking: breaking}, true
+	}
+	return Change{Description: fmt.Sprintf("type parameters <%s> -> <%s>", typeParams(old), typeParams(new)), Breaking: true}, true
+}
+
+func typeParams(params []module.GenericTypeParam) string {
+	var ps []string
+	for i, param := range params {
+		p := fmt.Sprintf("T%d", i)
+		if param.IsPhantom {
+			p = "phantom " + p
+		}
+		if len(param.Constraints) > 0 {
+			p += ": " + strings.Join(param.Constraints, " + ")
+		}
+		ps = append(ps, p)
+	}
+	return strings.Join(ps, ", ")
+}
+
+func fields(fs []module.Field) []string {
+	var strs []string
+	for _, f := range fs {
+		strs = append(strs, f.Name+": "+f.Type)
+	}
+	return strs
+}
+
+func equal(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+//subset Whether every element of `a` is in `b`
+func subset(a, b []string) bool {
+	in := make(map[string]bool)
+	for _, s := range b {
+		in[s] = true
+	}
+	for _, s := range a {
+		if !in[s] {
+			return false
+		}
+	}
+	return true
+}
+
+//sorted The distinct names, sorted
+func sorted(names []string) []string {
+	seen := make(map[string]bool)
+	var distinct []string
+	for _, name := range names {
+		if !seen[name] {
+			seen[name] = true
+			distinct = append(distinct, name)
+		}
+	}
+	sort.Strings(distinct)
+	return distinct
+}
+
+//ErrNotPublished No publication of the module was found at a version
+var ErrNotPublished = errors.New("module not published")
+
+//DiffVersions Compares the publications of `address`::`name` at versions `from` and `to`, as stored in `modules`
+func DiffVersions(ctx context.Context, db *gorm.DB, address, name string, from, to int64) (*UpgradeDiff, error) {
+	address = normalizeAddress(address)
+	abis := make([]module.ABI, 2)
+	for i, version := range []int64{from, to} {
+		var m module.Module
+		err := db.WithContext(ctx).Where("address = ? AND name = ? AND version = ?", address, name, version).First(&m).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, fmt.Errorf("%s::%s at version %d: %w", address, name, version, ErrNotPublished)
+		} else if err != nil {
+			return nil, err
+		}
+		if err = json.Unmarshal([]byte(m.ABI), &abis[i]); err != nil {
+			return nil, fmt.Errorf("%s::%s at version %d: %w", address, name, version, err)
+		}
+	}
+	diff := Diff(abis[0], abis[1])
+	diff.Address, diff.Name, diff.From, diff.To = address, name, from, to
+	return diff, nil
+}
+
+//DiffLatest Compares the last two publications of `address`::`name`, i.e. its latest upgrade
+func DiffLatest(ctx context.Context, db *gorm.DB, address, name string) (*UpgradeDiff, error) {
+	address = normalizeAddress(address)
+	var versions []int64
+	if err := db.WithContext(ctx).Model(&module.Module{}).
+		Where("address = ? AND name = ?", address, name).
+		Order("version DESC").Limit(2).
+		Pluck("version", &versions).Error; err != nil {
+		return nil, err
+	}
+	if len(versions) < 2 {
+		return nil, fmt.Errorf("%s::%s has %d publications, an upgrade needs 2: %w", address, name, len(versions), ErrNotPublished)
+	}
+	return DiffVersions(ctx, db, address, name, versions[1], versions[0])
+}
+
+//normalizeAddress The short form the fullnode writes addresses in ABIs with, e.g. "0x1" for "0x0000...0001"
+func normalizeAddress(address string) string {
+	address = strings.TrimLeft(strings.TrimPrefix(strings.ToLower(address), "0x"), "0")
+	if address == "" {
+		return "0x0"
+	}
+	return "0x" + address
+}
@@ -0,0 +1,93 @@
+package module
+
+import (
+	"apotscan/types/module"
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestDiffVersions(t *testing.T) {
+	ctx := context.Background()
+	db := newTestDB(t)
+	processor := newTestProcessor(t, db)
+	if _, err := processor.ProcessTransactions(ctx, db, processor.Filter().Apply(loadTransactions(t)), 10, 20); err != nil {
+		t.Fatal(err)
+	}
+
+	diff, err := DiffVersions(ctx, db, "0xCAFE", "hello", 10, 20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !diff.Compatible() || len(diff.Functions) != 1 || diff.Functions[0].Name != "read" || diff.Functions[0].Kind != Added ||
+		len(diff.Structs) != 0 || len(diff.Friends) != 0 {
+		t.Fatalf("got %+v, want read added", diff)
+	}
+
+	// 12 reads a vector<u8> for any T: copy + drop, 20 reads a String
+	diff, err = DiffLatest(ctx, db, "0x000cafe", "hello")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff.From != 12 || diff.To != 20 || diff.Compatible() {
+		t.Fatalf("got %d -> %d, compatible %t, want 12 -> 20 breaking", diff.From, diff.To, diff.Compatible())
+	}
+	if len(diff.Functions) != 1 || diff.Functions[0].Name != "read" || diff.Functions[0].Kind != Changed || len(diff.Functions[0].Changes) != 2 {
+		t.Fatalf("got functions %+v", diff.Functions)
+	}
+	for _, change := range diff.Functions[0].Changes {
+		if !change.Breaking {
+			t.Fatalf("got %+v, want every change of read breaking", change)
+		}
+	}
+	if len(diff.Friends) != 1 || diff.Friends[0].Kind != Removed || diff.Friends[0].Breaking() {
+		t.Fatalf("got friends %+v, want 0xcafe::other removed", diff.Friends)
+	}
+
+	if _, err = DiffVersions(ctx, db, "0xcafe", "hello", 10, 11); !errors.Is(err, ErrNotPublished) {
+		t.Fatalf("got %v, want ErrNotPublished", err)
+	}
+}
+
+func TestDiff_Compatibility(t *testing.T) {
+	old := module.ABI{
+		ExposedFunctions: []module.Function{
+			{Name: "transfer", Visibility: "public", IsEntry: true, Params: []string{"&signer", "u64"}},
+			{Name: "internal", Visibility: "friend", Params: []string{"u64"}},
+		},
+		Structs: []module.Struct{
+			{Name: "Coin", Abilities: []string{"store"}, Fields: []module.Field{{Name: "value", Type: "u64"}},
+				GenericTypeParams: []module.GenericTypeParam{{Constraints: []string{"store"}, IsPhantom: true}}},
+		},
+	}
+	for _, c := range []struct {
+		name       string
+		update     func(abi *module.ABI)
+		compatible bool
+	}{
+		{"unchanged", func(abi *module.ABI) {}, true},
+		{"friend params changed", func(abi *module.ABI) { abi.ExposedFunctions[1].Params = []string{"u128"} }, true},
+		{"friend removed", func(abi *module.ABI) { abi.ExposedFunctions = abi.ExposedFunctions[:1] }, true},
+		{"ability added", func(abi *module.ABI) { abi.Structs[0].Abilities = []string{"drop", "store"} }, true},
+		{"constraint relaxed", func(abi *module.ABI) { abi.Structs[0].GenericTypeParams[0].Constraints = nil }, true},
+		{"public params changed", func(abi *module.ABI) { abi.ExposedFunctions[0].Params = []string{"&signer", "u128"} }, false},
+		{"entry removed", func(abi *module.ABI) { abi.ExposedFunctions[0].IsEntry = false }, false},
+		{"public removed", func(abi *module.ABI) { abi.ExposedFunctions = abi.ExposedFunctions[1:] }, false},
+		{"ability removed", func(abi *module.ABI) { abi.Structs[0].Abilities = []string{} }, false},
+		{"field added", func(abi *module.ABI) {
+			abi.Structs[0].Fields = append(abi.Structs[0].Fields, module.Field{Name: "frozen", Type: "bool"})
+		}, false},
+		{"phantom dropped", func(abi *module.ABI) { abi.Structs[0].GenericTypeParams[0].IsPhantom = false }, false},
+		{"struct removed", func(abi *module.ABI) { abi.Structs = nil }, false},
+	} {
+		new := module.ABI{
+			ExposedFunctions: append([]module.Function{}, old.ExposedFunctions...),
+			Structs:          append([]module.Struct{}, old.Structs...),
+		}
+		new.Structs[0].GenericTypeParams = append([]module.GenericTypeParam{}, old.Structs[0].GenericTypeParams...)
+		c.update(&new)
+		if diff := Diff(old, new); diff.Compatible() != c.compatible {
+			t.Fatalf("%s: got compatible %t, want %t: %+v", c.name, diff.Compatible(), c.compatible, diff)
+		}
+	}
+}
@@ -0,0 +1,391 @@
+package token
+
+import (
+	"apotscan/migration"
+	"gorm.io/gorm"
+	"time"
+)
+
+func init() {
+	migration.Register(migration.Migration{
+		Version: 202209010100,
+		Name:    "create token tables",
+		Up: func(db *gorm.DB) error {
+			return migration.CreateTables(db, tablesV1()...)
+		},
+		Down: func(db *gorm.DB) error {
+			return migration.DropTables(db, tablesV1()...)
+		},
+	}, migration.Migration{
+		Version: 202209250100,
+		Name:    "add token table keys",
+		Up: func(db *gorm.DB) error {
+			return migration.RebuildTables(db, tablesV2()...)
+		},
+		Down: func(db *gorm.DB) error {
+			return migration.RebuildTables(db, tablesV1()...)
+		},
+	})
+}
+
+func tablesV1() []interface{} {
+	return []interface{}{
+		&collectionV1{}, &metadataV1{}, &ownershipV1{}, &tokenDataV1{}, &tokenPropertyV1{},
+		&eventV1{}, &tokenTransferEventV1{}, &tokenActivityV1{}, &pendingTransferV1{},
+	}
+}
+
+func tablesV2() []interface{} {
+	return []interface{}{
+		&collectionV2{}, &metadataV2{}, &ownershipV2{}, &tokenDataV2{}, &tokenPropertyV2{},
+		&eventV2{}, &tokenTransferEventV2{}, &tokenActivityV2{}, &pendingTransferV2{},
+	}
+}
+
+type collectionV1 struct {
+	CollectionId string
+	Creator      string
+	Name         string
+	Description  string
+	MaxAmount    int64
+	Uri          string
+	InsertAt     int64
+	Version      int64
+
+	CreatedAt *time.Time `gorm:"autoCreateTime"`
+	UpdatedAt *time.Time `gorm:"autoUpdateTime;not null"`
+}
+
+func (collectionV1) TableName() string {
+	return "collections"
+}
+
+type metadataV1 struct {
+	TokenId              string
+	Name                 string
+	Symbol               string
+	SellerFeeBasisPoints int64
+	Description          string
+	Image                string
+	ExternalUrl          string
+	AnimationUrl         string
+	Attributes           []byte
+	Properties           []byte
+	Version              int64
+
+	CreatedAt *time.Time `gorm:"autoCreateTime"`
+	UpdatedAt *time.Time `gorm:"autoUpdateTime;not null"`
+}
+
+func (metadataV1) TableName() string {
+	return "metadatas"
+}
+
+type ownershipV1 struct {
+	OwnershipId string
+	TokenId     string `gorm:"column:token_id"`
+	TokenDataId string `gorm:"column:token_data_id"`
+	Owner       string `gorm:"column:owner"`
+	Amount      int64
+	Version     int64
+
+	CreatedAt *time.Time `gorm:"autoCreateTime"`
+	UpdatedAt *time.Time `gorm:"autoUpdateTime;not null"`
+}
+
+func (ownershipV1) TableName() string {
+	return "ownerships"
+}
+
+type tokenDataV1 struct {
+	TokenDataId              string `gorm:"column:token_data_id"`
+	Creator                  string
+	Collection               string
+	Name                     string
+	Description              string
+	MaxAmount                int64
+	Supply                   int64
+	Uri                      string
+	RoyaltyPayeeAddress      string
+	RoyaltyPointsDenominator int64
+	RoyaltyPointsNumerator   int64
+	PropertyKey              []byte
+	PropertyValues           []byte
+	PropertyTypes            []byte
+	MintedAt                 int64
+	LastMintedAt             int64
+	Version                  int64
+
+	CreatedAt *time.Time `gorm:"autoCreateTime"`
+	UpdatedAt *time.Time `gorm:"autoUpdateTime;not null"`
+}
+
+func (tokenDataV1) TableName() string {
+	return "token_datas"
+}
+
+type tokenPropertyV1 struct {
+	TokenId         string
+	PreviousTokenId string
+	PropertyKeys    []byte
+	PropertyValues  []byte
+	PropertyTypes   []byte
+	Version         int64
+	Timestamp       int64
+
+	CreatedAt *time.Time `gorm:"autoCreateTime"`
+	UpdatedAt *time.Time `gorm:"autoUpdateTime;not null"`
+}
+
+func (tokenPropertyV1) TableName() string {
+	return "token_propertys"
+}
+
+type eventV1 struct {
+	TransactionHash string
+	Key             string
+	SequenceNumber  int64
+	Type            string
+	Data            []byte
+
+	CreatedAt *time.Time `gorm:"autoCreateTime"`
+	UpdatedAt *time.Time `gorm:"autoUpdateTime;not null"`
+}
+
+func (eventV1) TableName() string {
+	return "events"
+}
+
+type tokenTransferEventV1 struct {
+	Version        int64
+	EventKey       string
+	SequenceNumber int64
+	TokenSeller    string
+	TokenBuyer     string
+	EventType      string
+	TokenId        string
+	CoinType       string
+	TokenAmount    int64
+	CoinAmount     int64
+	Timestamp      int64
+
+	CreatedAt *time.Time `gorm:"autoCreateTime"`
+	UpdatedAt *time.Time `gorm:"autoUpdateTime;not null"`
+}
+
+func (tokenTransferEventV1) TableName() string {
+	return "token_transfer_events"
+}
+
+type tokenActivityV1 struct {
+	Version        int64
+	EventKey       string
+	SequenceNumber int64
+
+	EventType string
+	Amount    int64
+	Timestamp int64
+
+	From    string
+	To      string
+	TokenId string
+	Caller  string
+
+	CreatedAt *time.Time `gorm:"autoCreateTime"`
+	UpdatedAt *time.Time `gorm:"autoUpdateTime;not null"`
+}
+
+func (tokenActivityV1) TableName() string {
+	return "token_activitys"
+}
+
+type pendingTransferV1 struct {
+	PendingId string `gorm:"column:pending_id"`
+	TokenId   string
+	From      string
+	To        string
+	Version   int64
+	Timestamp int64
+	Amount    int64
+
+	CreatedAt *time.Time `gorm:"autoCreateTime"`
+	UpdatedAt *time.Time `gorm:"autoUpdateTime;not null"`
+}
+
+func (pendingTransferV1) TableName() string {
+	return "pending_tokens"
+}
+
+type collectionV2 struct {
+	CollectionId string `gorm:"primaryKey;size:512"`
+	Creator      string
+	Name         string
+	Description  string
+	MaxAmount    int64
+	Uri          string
+	InsertAt     int64
+	Version      int64
+
+	CreatedAt *time.Time `gorm:"autoCreateTime"`
+	UpdatedAt *time.Time `gorm:"autoUpdateTime;not null"`
+}
+
+func (collectionV2) TableName() string {
+	return "collections"
+}
+
+type metadataV2 struct {
+	TokenId              string `gorm:"primaryKey;size:512"`
+	Name                 string
+	Symbol               string
+	SellerFeeBasisPoints int64
+	Description          string
+	Image                string
+	ExternalUrl          string
+	AnimationUrl         string
+	Attributes           []byte
+	Properties           []byte
+	Version              int64
+
+	CreatedAt *time.Time `gorm:"autoCreateTime"`
+	UpdatedAt *time.Time `gorm:"autoUpdateTime;not null"`
+}
+
+func (metadataV2) TableName() string {
+	return "metadatas"
+}
+
+type ownershipV2 struct {
+	OwnershipId string `gorm:"primaryKey;size:768"`
+	TokenId     string `gorm:"column:token_id;size:512;index"`
+	TokenDataId string `gorm:"column:token_data_id;size:512"`
+	Owner       string `gorm:"column:owner;size:66;index"`
+	Amount      int64
+	Version     int64
+
+	CreatedAt *time.Time `gorm:"autoCreateTime"`
+	UpdatedAt *time.Time `gorm:"autoUpdateTime;not null"`
+}
+
+func (ownershipV2) TableName() string {
+	return "ownerships"
+}
+
+type tokenDataV2 struct {
+	TokenDataId              string `gorm:"column:token_data_id;primaryKey;size:512"`
+	Creator                  string
+	Collection               string
+	Name                     string
+	Description              string
+	MaxAmount                int64
+	Supply                   int64
+	Uri                      string
+	RoyaltyPayeeAddress      string
+	RoyaltyPointsDenominator int64
+	RoyaltyPointsNumerator   int64
+	PropertyKey              []byte
+	PropertyValues           []byte
+	PropertyTypes            []byte
+	MintedAt                 int64
+	LastMintedAt             int64
+	Version                  int64
+
+	CreatedAt *time.Time `gorm:"autoCreateTime"`
+	UpdatedAt *time.Time `gorm:"autoUpdateTime;not null"`
+}
+
+func (tokenDataV2) TableName() string {
+	return "token_datas"
+}
+
+type tokenPropertyV2 struct {
+	TokenId         string `gorm:"primaryKey;size:512"`
+	PreviousTokenId string
+	PropertyKeys    []byte
+	PropertyValues  []byte
+	PropertyTypes   []byte
+	Version         int64 `gorm:"primaryKey;autoIncrement:false"`
+	Timestamp       int64
+
+	CreatedAt *time.Time `gorm:"autoCreateTime"`
+	UpdatedAt *time.Time `gorm:"autoUpdateTime;not null"`
+}
+
+func (tokenPropertyV2) TableName() string {
+	return "token_propertys"
+}
+
+type eventV2 struct {
+	TransactionHash string
+	Key             string `gorm:"primaryKey;size:255"`
+	SequenceNumber  int64  `gorm:"primaryKey;autoIncrement:false"`
+	Type            string
+	Data            []byte
+
+	CreatedAt *time.Time `gorm:"autoCreateTime"`
+	UpdatedAt *time.Time `gorm:"autoUpdateTime;not null"`
+}
+
+func (eventV2) TableName() string {
+	return "events"
+}
+
+type tokenTransferEventV2 struct {
+	Version        int64  `gorm:"primaryKey;autoIncrement:false"`
+	EventKey       string `gorm:"primaryKey;size:255"`
+	SequenceNumber int64  `gorm:"primaryKey;autoIncrement:false"`
+	TokenSeller    string
+	TokenBuyer     string
+	EventType      string
+	TokenId        string
+	CoinType       string
+	TokenAmount    int64
+	CoinAmount     int64
+	Timestamp      int64
+
+	CreatedAt *time.Time `gorm:"autoCreateTime"`
+	UpdatedAt *time.Time `gorm:"autoUpdateTime;not null"`
+}
+
+func (tokenTransferEventV2) TableName() string {
+	return "token_transfer_events"
+}
+
+type tokenActivityV2 struct {
+	Version        int64  `gorm:"primaryKey;autoIncrement:false"`
+	EventKey       string `gorm:"primaryKey;size:255"`
+	SequenceNumber int64  `gorm:"primaryKey;autoIncrement:false"`
+
+	EventType string
+	Amount    int64
+	Timestamp int64
+
+	From    string
+	To      string
+	TokenId string
+	Caller  string
+
+	CreatedAt *time.Time `gorm:"autoCreateTime"`
+	UpdatedAt *time.Time `gorm:"autoUpdateTime;not null"`
+}
+
+func (tokenActivityV2) TableName() string {
+	return "token_activitys"
+}
+
+type pendingTransferV2 struct {
+	PendingId string `gorm:"column:pending_id;primaryKey;size:64"`
+	TokenId   string
+	From      string
+	To        string
+	Version   int64
+	Timestamp int64
+	Amount    int64
+
+	CreatedAt *time.Time `gorm:"autoCreateTime"`
+	UpdatedAt *time.Time `gorm:"autoUpdateTime;not null"`
+}
+
+func (pendingTransferV2) TableName() string {
+	return "pending_tokens"
+}
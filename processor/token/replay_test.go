@@ -0,0 +1,202 @@
+package token
+
+import (
+	"apotscan/migration"
+	"apotscan/types"
+	"apotscan/types/token"
+	"context"
+	"encoding/json"
+	aptos "github.com/portto/aptos-go-sdk/client"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	gormlogger "gorm.io/gorm/logger"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+//newTestDB A migrated sqlite database in a temporary directory
+func newTestDB(t *testing.T) *gorm.DB {
+	db, err := gorm.Open(sqlite.Open(filepath.Join(t.TempDir(), "test.db")), &gorm.Config{
+		Logger: gormlogger.Default.LogMode(gormlogger.Silent),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = migration.New(db, migration.Registered()...).Up(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+//loadTransactions The transactions of testdata/transactions.json: a collection created at 1, its token data at 2,
+//minted and deposited at 3, then moved around by transfers, offers and claims until a burn at 10
+func loadTransactions(t *testing.T) []types.Transaction {
+	data, err := ioutil.ReadFile("testdata/transactions.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var resps []aptos.TransactionResp
+	if err = json.Unmarshal(data, &resps); err != nil {
+		t.Fatal(err)
+	}
+	var txs []types.Transaction
+	for _, resp := range resps {
+		var tx types.Transaction
+		if err = tx.FromAptos(resp); err != nil {
+			t.Fatal(err)
+		}
+		txs = append(txs, tx)
+	}
+	return txs
+}
+
+func TestTokenTransactionProcessor_ReplayIsIdempotent(t *testing.T) {
+	ctx := context.Background()
+	db := newTestDB(t)
+	txs := loadTransactions(t)
+
+	// the events whose replay moves amounts and supplies have to be covered
+	seen := make(map[string]bool)
+	for _, tx := range txs {
+		for _, event := range tx.Events {
+			seen[event.Type] = true
+		}
+	}
+	for _, eventType := range []string{
+		"0x3::token::DepositEvent", "0x3::token::WithdrawEvent", "0x3::token::MintTokenEvent", "0x3::token::BurnTokenEvent",
+		"0x3::token_transfers::TokenOfferEvent", "0x3::token_transfers::TokenClaimEvent", "0x3::token_transfers::TokenCancelOfferEvent",
+	} {
+		if !seen[eventType] {
+			t.Fatalf("testdata has no %s", eventType)
+		}
+	}
+
+	processor := &TokenTransactionProcessor{db: db, name: "token"}
+	counts := func() map[string]int64 {
+		counts := make(map[string]int64)
+		for _, model := range []interface{}{
+			&token.CollectionInDB{}, &token.TokenDataInDB{}, &token.OwnershipInDB{},
+			&token.TokenActivityInDB{}, &token.TokenTransferEventInDB{}, &token.PendingTransfer{},
+		} {
+			stmt := &gorm.Statement{DB: db}
+			if err := stmt.Parse(model); err != nil {
+				t.Fatal(err)
+			}
+			var count int64
+			if err := db.Model(model).Count(&count).Error; err != nil {
+				t.Fatal(err)
+			}
+			counts[stmt.Schema.Table] = count
+		}
+		return counts
+	}
+
+	if _, err := processor.ProcessTransactions(ctx, db, txs, txs[0].Version, txs[len(txs)-1].Version); err != nil {
+		t.Fatal(err)
+	}
+	first := counts()
+	for _, table := range []string{"collections", "token_datas", "ownerships"} {
+		if first[table] == 0 {
+			t.Fatalf("nothing was written to %s", table)
+		}
+	}
+	amounts := func() map[string]int64 {
+		var ownerships []token.OwnershipInDB
+		if err := db.Find(&ownerships).Error; err != nil {
+			t.Fatal(err)
+		}
+		amounts := make(map[string]int64)
+		for _, ownership := range ownerships {
+			amounts[ownership.OwnershipId] = ownership.Amount
+		}
+		var tokenDatas []token.TokenDataInDB
+		if err := db.Find(&tokenDatas).Error; err != nil {
+			t.Fatal(err)
+		}
+		for _, tokenData := range tokenDatas {
+			amounts["supply of "+tokenData.TokenDataId] = tokenData.Supply
+		}
+		return amounts
+	}
+	firstAmounts := amounts()
+
+	if _, err := processor.ProcessTransactions(ctx, db, txs, txs[0].Version, txs[len(txs)-1].Version); err != nil {
+		t.Fatal(err)
+	}
+	for id, amount := range amounts() {
+		if amount != firstAmounts[id] {
+			t.Fatalf("replay changed the amount of %s from %d to %d", id, firstAmounts[id], amount)
+		}
+	}
+	for table, count := range counts() {
+		if count != first[table] {
+			t.Fatalf("replay changed %s from %d to %d rows", table, first[table], count)
+		}
+	}
+}
+
+func TestTokenTransactionProcessor_AppliesChangesSharingAVersion(t *testing.T) {
+	ctx := context.Background()
+	db := newTestDB(t)
+	txs := loadTransactions(t)
+	// the token data is created in the transaction minting it, and 3 deposits into the account 4 withdraws from and deposits to
+	created := txs[1].Events
+	txs = []types.Transaction{txs[0], txs[2], txs[3]}
+	txs[1].Events = append(created, txs[1].Events...)
+
+	processor := &TokenTransactionProcessor{db: db, name: "token"}
+	for i := 0; i < 2; i++ {
+		if _, err := processor.ProcessTransactions(ctx, db, txs, 1, 4); err != nil {
+			t.Fatal(err)
+		}
+		var tokenDatas []token.TokenDataInDB
+		if err := db.Find(&tokenDatas).Error; err != nil {
+			t.Fatal(err)
+		}
+		if len(tokenDatas) != 1 || tokenDatas[0].Supply != 5 || tokenDatas[0].Version != 3 {
+			t.Fatalf("got token data %+v after run %d, want the supply of 5 minted at its creation", tokenDatas, i+1)
+		}
+		var ownerships []token.OwnershipInDB
+		if err := db.Find(&ownerships).Error; err != nil {
+			t.Fatal(err)
+		}
+		if len(ownerships) != 1 || ownerships[0].Amount != 5 || ownerships[0].Version != 4 {
+			t.Fatalf("got ownerships %+v after run %d, want 5 after withdrawing and depositing 1 at 4", ownerships, i+1)
+		}
+	}
+}
+
+func TestTokenTransactionProcessor_RollsBackOnWriteError(t *testing.T) {
+	ctx := context.Background()
+	db := newTestDB(t)
+	txs := loadTransactions(t)
+	// burning a token which was never created fails the supply update, after every other table was written
+	burn := txs[9]
+	burn.Events = []types.Event{txs[9].Events[0]}
+	burn.Events[0].Data = map[string]interface{}{
+		"amount": float64(1),
+		"id": map[string]interface{}{
+			"token_data_id":    map[string]interface{}{"creator": "0xcafe", "collection": "Aptos Monkeys", "name": "Monkey #404"},
+			"property_version": float64(0),
+		},
+	}
+	txs = append(txs[:4], burn)
+
+	processor := &TokenTransactionProcessor{db: db, name: "token"}
+	if _, err := processor.ProcessTransactions(ctx, db, txs, 1, 10); err == nil || !strings.Contains(err.Error(), "update token supplies") {
+		t.Fatalf("got %v, want burning an unknown token to fail the supply update", err)
+	}
+	for _, model := range []interface{}{
+		&token.CollectionInDB{}, &token.TokenDataInDB{}, &token.OwnershipInDB{}, &token.TokenActivityInDB{},
+	} {
+		var count int64
+		if err := db.Model(model).Count(&count).Error; err != nil {
+			t.Fatal(err)
+		}
+		if count != 0 {
+			t.Fatalf("got %d rows of %T left by the failed batch, want none", count, model)
+		}
+	}
+}
@@ -4,11 +4,13 @@ import (
 	"apotscan/logger"
 	"apotscan/types"
 	"apotscan/types/token"
+	"context"
 	"fmt"
 	mapset "github.com/deckarep/golang-set"
 	"github.com/go-redis/redis/v8"
 	log "github.com/sirupsen/logrus"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 	"net/http"
 	"strconv"
 )
@@ -57,13 +59,29 @@ func (tp *TokenTransactionProcessor) GetLogger() *logger.Logger {
 	return tp.logger
 }
 
-func (tp *TokenTransactionProcessor) ProcessTransactions(txs []types.Transaction, startVersion, endVersion int64) (*types.ProcessResult, error) {
+//filter User transactions with an event of the token modules
+var filter = &types.TransactionFilter{
+	TransactionTypes:  []string{types.UserTransaction},
+	EventTypePrefixes: []string{"0x3::token::", "0x3::token_coin_swap::", "0x3::token_transfers::"},
+}
+
+func (tp *TokenTransactionProcessor) Filter() *types.TransactionFilter {
+	return filter
+}
+
+//Ordered Ownerships, token datas and pending transfers are read, modified and written back, skipping changes older
+//than the version which last wrote the row, so versions have to be applied in order
+func (tp *TokenTransactionProcessor) Ordered() bool {
+	return true
+}
+
+func (tp *TokenTransactionProcessor) ProcessTransactions(ctx context.Context, db *gorm.DB, txs []types.Transaction, startVersion, endVersion int64) (*types.ProcessResult, error) {
 	var tokenUris = make(map[string]string)
 	txsWithTokenEvent, err := token.GetTransactionsWithTokenEvent(txs)
 	if err != nil {
 		return nil, err
 	}
-	if err = processTokenOnChainData(tp.db, txsWithTokenEvent, &tokenUris); err != nil {
+	if err = processTokenOnChainData(db, txsWithTokenEvent, &tokenUris); err != nil {
 		return nil, err
 	}
 	if tp.indexTokenUri {
@@ -77,8 +95,15 @@ func (tp *TokenTransactionProcessor) ProcessTransactions(txs []types.Transaction
 	}, nil
 }
 
-//processTokenOnChainData todo: add logic
+//processTokenOnChainData Writes the whole batch in a single transaction, any error rolls every write of the batch back,
+//so ownerships and supplies never get out of step
 func processTokenOnChainData(db *gorm.DB, txsWithEvents []*token.TransactionWithTokenEvents, uris *map[string]string) error {
+	return db.Transaction(func(db *gorm.DB) error {
+		return applyTokenOnChainData(db, txsWithEvents, uris)
+	})
+}
+
+func applyTokenOnChainData(db *gorm.DB, txsWithEvents []*token.TransactionWithTokenEvents, uris *map[string]string) error {
 	var collections []*token.CollectionInDB
 	var tokensData []*token.TokenDataInDB
 	var tokenTransferEvents []*token.TokenTransferEventInDB
@@ -110,6 +135,7 @@ func processTokenOnChainData(db *gorm.DB, txsWithEvents []*token.TransactionWith
 				}
 
 				ownershipChanges = append(ownershipChanges, &token.OwnershipInDB{
+					OwnershipId: ownershipId,
 					TokenId:     tokenId,
 					TokenDataId: tokenDataId,
 					Owner:       tx.Tx.Sender,
@@ -118,18 +144,19 @@ func processTokenOnChainData(db *gorm.DB, txsWithEvents []*token.TransactionWith
 				})
 
 			case token.TypeDepositEvent:
-				tokenId := event.TokenEventData.(token.WithdrawEvent).Id.ToString()
-				tokenDataId := event.TokenEventData.(token.WithdrawEvent).Id.TokenDataId.ToString()
+				tokenId := event.TokenEventData.(token.DepositEvent).Id.ToString()
+				tokenDataId := event.TokenEventData.(token.DepositEvent).Id.TokenDataId.ToString()
 				ownershipId := fmt.Sprintf("%s::%s,", tokenId, tx.Tx.Sender)
 				if !ownershipSet.Contains(ownershipId) {
 					ownershipSet.Add(ownershipId)
 					ownershipIds = append(ownershipIds, ownershipId)
 				}
 				ownershipChanges = append(ownershipChanges, &token.OwnershipInDB{
+					OwnershipId: ownershipId,
 					TokenId:     tokenId,
 					TokenDataId: tokenDataId,
 					Owner:       tx.Tx.Sender,
-					Amount:      event.TokenEventData.(token.WithdrawEvent).Amount,
+					Amount:      int64(event.TokenEventData.(token.DepositEvent).Amount),
 					Version:     tx.Tx.Version,
 				})
 
@@ -156,7 +183,7 @@ func processTokenOnChainData(db *gorm.DB, txsWithEvents []*token.TransactionWith
 				}
 				timestamp, err := strconv.ParseInt(tx.Tx.Timestamp, 10, 64)
 				if err != nil {
-					return nil
+					return err
 				}
 				tokenId := event.TokenEventData.(token.BurnTokenEvent).Id.ToString()
 				tokenDataId := event.TokenEventData.(token.BurnTokenEvent).Id.TokenDataId.ToString()
@@ -199,10 +226,10 @@ func processTokenOnChainData(db *gorm.DB, txsWithEvents []*token.TransactionWith
 				if err != nil {
 					return err
 				}
-				tokenId := event.TokenEventData.(token.BurnTokenEvent).Id.ToString()
-				tokenDataId := event.TokenEventData.(token.BurnTokenEvent).Id.TokenDataId.ToString()
+				tokenId := event.TokenEventData.(token.MintTokenEvent).Id.ToString()
+				tokenDataId := event.TokenEventData.(token.MintTokenEvent).Id.TokenDataId.ToString()
 
-				amount := int64(event.TokenEventData.(token.BurnTokenEvent).Amount)
+				amount := int64(event.TokenEventData.(token.MintTokenEvent).Amount)
 				tokenActivities = append(tokenActivities, &token.TokenActivityInDB{
 					EventKey:       event.Key,
 					SequenceNumber: sequenceNum,
@@ -239,7 +266,7 @@ func processTokenOnChainData(db *gorm.DB, txsWithEvents []*token.TransactionWith
 				}
 				timestamp, err := strconv.ParseInt(tx.Tx.Timestamp, 10, 64)
 				if err != nil {
-					return nil
+					return err
 				}
 
 				coinType := event.TokenEventData.(token.TokenSwapEvent).CoinTypeInfo
@@ -265,7 +292,7 @@ func processTokenOnChainData(db *gorm.DB, txsWithEvents []*token.TransactionWith
 				}
 				timestamp, err := strconv.ParseInt(tx.Tx.Timestamp, 10, 64)
 				if err != nil {
-					return nil
+					return err
 				}
 				tokenId := event.TokenEventData.(token.TokenOfferEvent).TokenId.ToString()
 				amount := int64(event.TokenEventData.(token.TokenOfferEvent).Amount)
@@ -306,11 +333,11 @@ func processTokenOnChainData(db *gorm.DB, txsWithEvents []*token.TransactionWith
 				}
 				timestamp, err := strconv.ParseInt(tx.Tx.Timestamp, 10, 64)
 				if err != nil {
-					return nil
+					return err
 				}
 				tokenId := event.TokenEventData.(token.TokenClaimEvent).TokenId.ToString()
 				amount := int64(event.TokenEventData.(token.TokenClaimEvent).Amount)
-				from := event.TokenEventData.(token.TokenOfferEvent).ToAddress
+				from := event.TokenEventData.(token.TokenClaimEvent).ToAddress
 				tokenActivities = append(tokenActivities, &token.TokenActivityInDB{
 					EventKey:       event.Key,
 					SequenceNumber: sequenceNum,
@@ -347,11 +374,11 @@ func processTokenOnChainData(db *gorm.DB, txsWithEvents []*token.TransactionWith
 				}
 				timestamp, err := strconv.ParseInt(tx.Tx.Timestamp, 10, 64)
 				if err != nil {
-					return nil
+					return err
 				}
 				tokenId := event.TokenEventData.(token.TokenCancelOfferEvent).TokenId.ToString()
 				amount := int64(event.TokenEventData.(token.TokenCancelOfferEvent).Amount)
-				to := event.TokenEventData.(token.TokenOfferEvent).ToAddress
+				to := event.TokenEventData.(token.TokenCancelOfferEvent).ToAddress
 				tokenActivities = append(tokenActivities, &token.TokenActivityInDB{
 					EventKey:       event.Key,
 					SequenceNumber: sequenceNum,
@@ -387,32 +414,46 @@ func processTokenOnChainData(db *gorm.DB, txsWithEvents []*token.TransactionWith
 		}
 	}
 
-	if err := db.Save(&collections).Error; err != nil {
-		return nil
+	// creations are replayed with the rest of a batch, keep the rows later events have updated since
+	if len(collections) > 0 {
+		if err := db.Clauses(clause.OnConflict{DoNothing: true}).Create(&collections).Error; err != nil {
+			return fmt.Errorf("save collections: %w", err)
+		}
 	}
 
-	if err := db.Save(&tokensData).Error; err != nil {
-		return nil
+	// read before the creations are written, a token minted in the transaction creating it has to have its supply applied
+	storedSupplies, err := storedTokenDataVersions(db, tokenDataChangeIds)
+	if err != nil {
+		return fmt.Errorf("read token supplies: %w", err)
+	}
+	if len(tokensData) > 0 {
+		if err := db.Clauses(clause.OnConflict{DoNothing: true}).Create(&tokensData).Error; err != nil {
+			return fmt.Errorf("save token data: %w", err)
+		}
 	}
 
-	if err := db.Save(&tokenTransferEvents).Error; err != nil {
-		return nil
+	if len(tokenTransferEvents) > 0 {
+		if err := db.Save(&tokenTransferEvents).Error; err != nil {
+			return fmt.Errorf("save token transfer events: %w", err)
+		}
 	}
 
-	if err := db.Save(&tokenActivities).Error; err != nil {
-		return nil
+	if len(tokenActivities) > 0 {
+		if err := db.Save(&tokenActivities).Error; err != nil {
+			return fmt.Errorf("save token activities: %w", err)
+		}
 	}
-	//todo: 并发
+
 	if err := dealWithOwnerShips(db, ownershipChanges, ownershipIds); err != nil {
-		return nil
+		return fmt.Errorf("update ownerships: %w", err)
 	}
 
-	if err := dealWithTokenDataChanges(db, tokenDataChanges, tokenDataChangeIds); err != nil {
-		return nil
+	if err := dealWithTokenDataChanges(db, storedSupplies, tokenDataChanges, tokenDataChangeIds); err != nil {
+		return fmt.Errorf("update token supplies: %w", err)
 	}
 
 	if err := dealWithPendingTransfers(db, pendingTransfers, pendingTransferIds); err != nil {
-		return nil
+		return fmt.Errorf("update pending transfers: %w", err)
 	}
 	return nil
 }
@@ -436,6 +477,14 @@ func getCollection(event token.CollectionCreationEvent, tx *types.Transaction) (
 	return collection, err
 }
 
+//marshalValue Marshals `value`, nil when the event left it out
+func marshalValue(value types.Value) ([]byte, error) {
+	if value == nil {
+		return nil, nil
+	}
+	return value.Marshal()
+}
+
 func getTokenData(event token.CreateTokenDataEvent, tx *types.Transaction) (*token.TokenDataInDB, error) {
 	timestamp, err := strconv.ParseInt(tx.Timestamp, 10, 64)
 	if err != nil {
@@ -447,15 +496,15 @@ func getTokenData(event token.CreateTokenDataEvent, tx *types.Transaction) (*tok
 		return nil, err
 	}
 
-	propertyKeys, err := event.PropertyKeys.Marshal()
+	propertyKeys, err := marshalValue(event.PropertyKeys)
 	if err != nil {
 		return nil, err
 	}
-	propertyValues, err := event.PropertyValues.Marshal()
+	propertyValues, err := marshalValue(event.PropertyValues)
 	if err != nil {
 		return nil, err
 	}
-	propertyTypes, err := event.PropertyTypes.Marshal()
+	propertyTypes, err := marshalValue(event.PropertyTypes)
 	if err != nil {
 		return nil, err
 	}
@@ -496,11 +545,13 @@ func insertTokenProperties(db *gorm.DB, event token.MutateTokenPropertyMapEvent,
 	}
 
 	_types, err := event.Types.Marshal()
+	if err != nil {
+		return err
+	}
 
 	timestamp, err := strconv.ParseInt(tx.Timestamp, 10, 64)
 	if err != nil {
-		return nil
-
+		return err
 	}
 	oldTokenId := event.OldId.ToString()
 	newTokenId := event.NewID.ToString()
@@ -524,12 +575,13 @@ func insertTokenProperties(db *gorm.DB, event token.MutateTokenPropertyMapEvent,
 			oldOwnership.Amount = 0
 			newOwnerships = append(newOwnerships, newOwnership)
 		}
-		if err := db.Save(&oldOwnerships).Error; err != nil {
-			return err
-		}
-
-		if err := db.Save(&newOwnerships).Error; err != nil {
-			return err
+		if len(oldOwnerships) > 0 {
+			if err := db.Save(&oldOwnerships).Error; err != nil {
+				return err
+			}
+			if err := db.Save(&newOwnerships).Error; err != nil {
+				return err
+			}
 		}
 	}
 
@@ -546,21 +598,28 @@ func insertTokenProperties(db *gorm.DB, event token.MutateTokenPropertyMapEvent,
 	return db.Save(&tokenProperty).Error
 }
 
+//dealWithOwnerShips Applies the batch's ownership changes in event order. A change is skipped if its row was stored at its
+//version or later before this batch, an earlier run already applied it, changes within the batch may share a version
 func dealWithOwnerShips(db *gorm.DB, ownershipChanges []*token.OwnershipInDB, ownershipIds []string) error {
+	if len(ownershipChanges) == 0 {
+		return nil
+	}
 	var ownerShipsInDb []*token.OwnershipInDB
-	if err := db.Where("OwnershipId IN (?)", ownershipIds).Find(&ownerShipsInDb).Error; err != nil {
+	if err := db.Where("ownership_id IN (?)", ownershipIds).Find(&ownerShipsInDb).Error; err != nil {
 		return err
 	}
 	ownerShipInDbMap := make(map[string]*token.OwnershipInDB)
+	storedVersions := make(map[string]int64)
 	for _, ownership := range ownerShipsInDb {
 		ownerShipInDbMap[ownership.OwnershipId] = ownership
+		storedVersions[ownership.OwnershipId] = ownership.Version
 	}
 
 	for _, ownership := range ownershipChanges {
+		if version, ok := storedVersions[ownership.OwnershipId]; ok && version >= ownership.Version {
+			continue
+		}
 		if ownershipInDb, ok := ownerShipInDbMap[ownership.OwnershipId]; ok {
-			if ownershipInDb.Version >= ownership.Version {
-				continue
-			}
 			ownershipInDb.Amount += ownership.Amount
 			ownershipInDb.Version = ownership.Version
 		} else {
@@ -569,14 +628,35 @@ func dealWithOwnerShips(db *gorm.DB, ownershipChanges []*token.OwnershipInDB, ow
 	}
 
 	var newOwnerships []*token.OwnershipInDB
-	for _, ownership := range newOwnerships {
+	for _, ownership := range ownerShipInDbMap {
 		newOwnerships = append(newOwnerships, ownership)
 	}
 
 	return db.Save(&newOwnerships).Error
 }
 
-func dealWithTokenDataChanges(db *gorm.DB, tokenDataChanges []*TokenDataAmountChange, tokenDataChangeIds []string) error {
+//storedTokenDataVersions The versions token data was stored at, by id
+func storedTokenDataVersions(db *gorm.DB, tokenDataIds []string) (map[string]int64, error) {
+	versions := make(map[string]int64)
+	if len(tokenDataIds) == 0 {
+		return versions, nil
+	}
+	var tokenDatasInDb []*token.TokenDataInDB
+	if err := db.Select("token_data_id, version").Where("token_data_id IN (?)", tokenDataIds).Find(&tokenDatasInDb).Error; err != nil {
+		return nil, err
+	}
+	for _, tokenDataInDb := range tokenDatasInDb {
+		versions[tokenDataInDb.TokenDataId] = tokenDataInDb.Version
+	}
+	return versions, nil
+}
+
+//dealWithTokenDataChanges Applies the batch's supply changes in event order, skipped like in `dealWithOwnerShips`
+//against `storedVersions`, the versions stored before the batch wrote its creations
+func dealWithTokenDataChanges(db *gorm.DB, storedVersions map[string]int64, tokenDataChanges []*TokenDataAmountChange, tokenDataChangeIds []string) error {
+	if len(tokenDataChanges) == 0 {
+		return nil
+	}
 	var tokenDatasInDb []*token.TokenDataInDB
 	if err := db.Where("token_data_id IN (?)", tokenDataChangeIds).Find(&tokenDatasInDb).Error; err != nil {
 		return err
@@ -588,10 +668,10 @@ func dealWithTokenDataChanges(db *gorm.DB, tokenDataChanges []*TokenDataAmountCh
 	}
 
 	for _, tokenDataAmountChange := range tokenDataChanges {
+		if version, ok := storedVersions[tokenDataAmountChange.TokenDataId]; ok && version >= tokenDataAmountChange.Version {
+			continue
+		}
 		if tokenDataInDb, ok := tokenDataInDbMap[tokenDataAmountChange.TokenDataId]; ok {
-			if tokenDataInDb.Version >= tokenDataAmountChange.Version {
-				continue
-			}
 			tokenDataInDb.Supply += tokenDataAmountChange.Amount
 			tokenDataInDb.Version = tokenDataAmountChange.Version
 			if tokenDataInDb.Supply < 0 {
@@ -599,7 +679,7 @@ func dealWithTokenDataChanges(db *gorm.DB, tokenDataChanges []*TokenDataAmountCh
 			}
 
 		} else {
-			return fmt.Errorf("version:%d,tokenId:%s:creator:%s,collection:%s,token:%s; mint or burn un-created token", tokenDataAmountChange.Version, tokenDataInDb.TokenDataId, tokenDataInDb.Creator, tokenDataInDb.Collection, tokenDataInDb.Name)
+			return fmt.Errorf("version:%d,tokenId:%s; mint or burn un-created token", tokenDataAmountChange.Version, tokenDataAmountChange.TokenDataId)
 
 		}
 	}
@@ -612,14 +692,20 @@ func dealWithTokenDataChanges(db *gorm.DB, tokenDataChanges []*TokenDataAmountCh
 	return db.Save(&newTokensData).Error
 }
 
+//dealWithPendingTransfers Applies the batch's offers and claims in event order, skipped like in `dealWithOwnerShips`
 func dealWithPendingTransfers(db *gorm.DB, pendingTransfers []*TokenTransferEvent, pendingTransferIds []string) error {
+	if len(pendingTransfers) == 0 {
+		return nil
+	}
 	var pendingTransfersInDb []*token.PendingTransfer
 	if err := db.Where("pending_id IN (?)", pendingTransferIds).Find(&pendingTransfersInDb).Error; err != nil {
 		return err
 	}
 	pendingTransferInDbMap := make(map[string]*token.PendingTransfer)
+	storedVersions := make(map[string]int64)
 	for _, pendingTransferInDb := range pendingTransfersInDb {
 		pendingId := pendingTransferInDb.PendingId
+		storedVersions[pendingId] = pendingTransferInDb.Version
 		pendingTransferInDbMap[pendingId] = &token.PendingTransfer{
 			PendingId: pendingId,
 			TokenId:   pendingTransferInDb.TokenId,
@@ -632,10 +718,10 @@ func dealWithPendingTransfers(db *gorm.DB, pendingTransfers []*TokenTransferEven
 	}
 
 	for _, pendingTransfer := range pendingTransfers {
+		if version, ok := storedVersions[pendingTransfer.Id]; ok && version >= pendingTransfer.Version {
+			continue
+		}
 		if pendingTransferInDb, ok := pendingTransferInDbMap[pendingTransfer.Id]; ok {
-			if pendingTransferInDb.Version >= pendingTransfer.Version {
-				continue
-			}
 
 			pendingTransferInDb.Amount += pendingTransfer.Amount
 			pendingTransferInDb.Version = pendingTransfer.Version
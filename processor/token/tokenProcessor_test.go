@@ -1,11 +1,13 @@
 package token
 
 import (
+	"apotscan/fakenode"
 	"apotscan/types/token"
 	"encoding/json"
 	mapset "github.com/deckarep/golang-set"
 	aptos "github.com/portto/aptos-go-sdk/client"
 	"io/ioutil"
+	"path/filepath"
 	"testing"
 )
 
@@ -25,15 +27,30 @@ func TestTokenTransactionProcessor_GetTransactions(t *testing.T) {
 	var createTokenDataEvent token.CreateTokenDataEvent
 	var transactions []aptos.TransactionResp
 
-	cli := aptos.New("https://fullnode.devnet.aptoslabs.com")
-	offset := 1000
+	server, err := fakenode.NewServer(4, "testdata/transactions.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+	cli := aptos.New(server.URL)
+	ledgerVersion := int(server.LedgerVersion())
+	getTransactions := func(start, offset int) []aptos.TransactionResp {
+		txs, err := cli.GetTransactions(start, offset)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return txs
+	}
+
+	offset := 100
 	start := 0
-	//start := 202000
-	//start := 43000
 getCollection:
 	for {
+		if start > ledgerVersion {
+			t.Fatal("no collection creation event found")
+		}
 		t.Logf("start:%d", start)
-		txs, _ := cli.GetTransactions(start, offset)
+		txs := getTransactions(start, offset)
 		for _, tx := range txs {
 			if tx.Success && tx.Type == "user_transaction" {
 				for _, event := range tx.Events {
@@ -56,8 +73,11 @@ getCollection:
 	}
 getToken:
 	for {
+		if start > ledgerVersion {
+			t.Fatal("no create token data event found")
+		}
 		t.Logf("start:%d", start)
-		txs, _ := cli.GetTransactions(start, offset)
+		txs := getTransactions(start, offset)
 		for _, tx := range txs {
 			if tx.Success && tx.Type == "user_transaction" {
 				for _, event := range tx.Events {
@@ -79,8 +99,11 @@ getToken:
 	tokenDataId := createTokenDataEvent.Id.ToString()
 
 	for transactionTypes.Cardinality() > 0 {
+		if start > ledgerVersion {
+			t.Fatalf("no events found for %v", transactionTypes)
+		}
 		t.Logf("start:%d", start)
-		txs, _ := cli.GetTransactions(start, offset)
+		txs := getTransactions(start, offset)
 		for _, tx := range txs {
 			if tx.Success && tx.Type == "user_transaction" {
 				for _, event := range tx.Events {
@@ -189,5 +212,5 @@ getToken:
 		start += offset
 	}
 	data, _ := json.Marshal(&transactions)
-	t.Log(ioutil.WriteFile("test_transactions.json", data, 0777))
+	t.Log(ioutil.WriteFile(filepath.Join(t.TempDir(), "test_transactions.json"), data, 0777))
 }
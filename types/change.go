@@ -0,0 +1,146 @@
+package types
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/portto/aptos-go-sdk/models"
+)
+
+//Change A write set change of a transaction, `Data` holds the variant named by `Type`, e.g. a `WriteResource`
+//for `WriteResourceChange`. `Data` is nil for a change type this version doesn't know
+type Change struct {
+	Type         string     `json:"type"`
+	StateKeyHash string     `json:"state_key_hash"`
+	Data         ChangeData `json:"data"`
+}
+
+type ChangeData interface {
+	ChangeType() string
+}
+
+type WriteResource struct {
+	Address string `json:"address"`
+	//Type The resource's struct tag, e.g. "0x1::coin::CoinStore<0x1::aptos_coin::AptosCoin>"
+	Type string                 `json:"type"`
+	Data map[string]interface{} `json:"data"`
+}
+
+func (WriteResource) ChangeType() string {
+	return WriteResourceChange
+}
+
+type DeleteResource struct {
+	Address  string `json:"address"`
+	Resource string `json:"resource"`
+}
+
+func (DeleteResource) ChangeType() string {
+	return DeleteResourceChange
+}
+
+type WriteModule struct {
+	Address  string `json:"address"`
+	Bytecode string `json:"bytecode"`
+	//ABI The ABI the fullnode derived, nil if it didn't
+	ABI interface{} `json:"abi,omitempty"`
+}
+
+func (WriteModule) ChangeType() string {
+	return WriteModuleChange
+}
+
+type DeleteModule struct {
+	Address string `json:"address"`
+	Module  string `json:"module"`
+}
+
+func (DeleteModule) ChangeType() string {
+	return DeleteModuleChange
+}
+
+//WriteTableItem A table item written, `Key` and `Value` are hex encoded BCS
+type WriteTableItem struct {
+	Handle string `json:"handle"`
+	Key    string `json:"key"`
+	Value  string `json:"value"`
+}
+
+func (WriteTableItem) ChangeType() string {
+	return WriteTableItemChange
+}
+
+type DeleteTableItem struct {
+	Handle string `json:"handle"`
+	Key    string `json:"key"`
+}
+
+func (DeleteTableItem) ChangeType() string {
+	return DeleteTableItemChange
+}
+
+//changeFromAptos Picks the fields of the change's variant out of the SDK's change, which has every variant's fields
+func changeFromAptos(change models.Change) Change {
+	c := Change{Type: change.Type, StateKeyHash: change.StateKeyHash}
+	switch change.Type {
+	case WriteResourceChange:
+		c.Data = WriteResource{Address: change.Address, Type: change.Data.Type, Data: change.Data.Data}
+	case DeleteResourceChange:
+		c.Data = DeleteResource{Address: change.Address, Resource: change.Resource}
+	case WriteModuleChange:
+		c.Data = WriteModule{Address: change.Address, Bytecode: change.Data.Bytecode, ABI: change.Data.ABI}
+	case DeleteModuleChange:
+		c.Data = DeleteModule{Address: change.Address, Module: change.Module}
+	case WriteTableItemChange:
+		c.Data = WriteTableItem{Handle: change.Data.Handle, Key: change.Data.Key, Value: change.Data.Value}
+	case DeleteTableItemChange:
+		c.Data = DeleteTableItem{Handle: change.Data.Handle, Key: change.Data.Key}
+	}
+	return c
+}
+
+//UnmarshalJSON Decodes `Data` into the variant named by `Type`, transactions are read back this way from `dead_letters`
+func (c *Change) UnmarshalJSON(data []byte) error {
+	var raw struct {
+		Type         string          `json:"type"`
+		StateKeyHash string          `json:"state_key_hash"`
+		Data         json.RawMessage `json:"data"`
+	}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+	*c = Change{Type: raw.Type, StateKeyHash: raw.StateKeyHash}
+	if len(raw.Data) == 0 || string(raw.Data) == "null" {
+		return nil
+	}
+	var err error
+	switch raw.Type {
+	case WriteResourceChange:
+		var d WriteResource
+		err = json.Unmarshal(raw.Data, &d)
+		c.Data = d
+	case DeleteResourceChange:
+		var d DeleteResource
+		err = json.Unmarshal(raw.Data, &d)
+		c.Data = d
+	case WriteModuleChange:
+		var d WriteModule
+		err = json.Unmarshal(raw.Data, &d)
+		c.Data = d
+	case DeleteModuleChange:
+		var d DeleteModule
+		err = json.Unmarshal(raw.Data, &d)
+		c.Data = d
+	case WriteTableItemChange:
+		var d WriteTableItem
+		err = json.Unmarshal(raw.Data, &d)
+		c.Data = d
+	case DeleteTableItemChange:
+		var d DeleteTableItem
+		err = json.Unmarshal(raw.Data, &d)
+		c.Data = d
+	}
+	if err != nil {
+		return fmt.Errorf("%s change: %w", raw.Type, err)
+	}
+	return nil
+}
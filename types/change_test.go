@@ -0,0 +1,63 @@
+package types
+
+import (
+	"encoding/json"
+	aptos "github.com/portto/aptos-go-sdk/client"
+	"reflect"
+	"testing"
+)
+
+func TestTransaction_FromAptosTypesChanges(t *testing.T) {
+	var resp aptos.TransactionResp
+	if err := json.Unmarshal([]byte(`{
+		"type": "user_transaction", "version": "7", "success": true,
+		"changes": [
+			{"type": "write_resource", "address": "0xcafe", "state_key_hash": "0x1", "data": {"type": "0x1::account::Account", "data": {"sequence_number": "3"}}},
+			{"type": "delete_resource", "address": "0xcafe", "state_key_hash": "0x2", "resource": "0xcafe::hello::Message"},
+			{"type": "write_module", "address": "0xcafe", "state_key_hash": "0x3", "data": {"bytecode": "0xa11ceb0b"}},
+			{"type": "delete_module", "address": "0xcafe", "state_key_hash": "0x4", "module": "0xcafe::hello"},
+			{"type": "write_table_item", "state_key_hash": "0x5", "data": {"handle": "0xabc", "key": "0x01", "value": "0x02"}},
+			{"type": "delete_table_item", "state_key_hash": "0x6", "data": {"handle": "0xabc", "key": "0x01"}},
+			{"type": "write_something_new", "state_key_hash": "0x7"}
+		]
+	}`), &resp); err != nil {
+		t.Fatal(err)
+	}
+	var tx Transaction
+	if err := tx.FromAptos(resp); err != nil {
+		t.Fatal(err)
+	}
+	want := []ChangeData{
+		WriteResource{Address: "0xcafe", Type: "0x1::account::Account", Data: map[string]interface{}{"sequence_number": "3"}},
+		DeleteResource{Address: "0xcafe", Resource: "0xcafe::hello::Message"},
+		WriteModule{Address: "0xcafe", Bytecode: "0xa11ceb0b"},
+		DeleteModule{Address: "0xcafe", Module: "0xcafe::hello"},
+		WriteTableItem{Handle: "0xabc", Key: "0x01", Value: "0x02"},
+		DeleteTableItem{Handle: "0xabc", Key: "0x01"},
+		nil,
+	}
+	if len(tx.Changes) != len(want) {
+		t.Fatalf("got %d changes, want %d", len(tx.Changes), len(want))
+	}
+	for i, change := range tx.Changes {
+		if !reflect.DeepEqual(change.Data, want[i]) {
+			t.Fatalf("got change %d %+v, want %+v", i, change.Data, want[i])
+		}
+		if want[i] != nil && change.Type != want[i].ChangeType() {
+			t.Fatalf("got change %d of type %s holding a %s", i, change.Type, want[i].ChangeType())
+		}
+	}
+
+	// dead letters and archives keep transactions as JSON
+	data, err := json.Marshal(tx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var decoded Transaction
+	if err = json.Unmarshal(data, &decoded); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(decoded.Changes, tx.Changes) {
+		t.Fatalf("got %+v after a round trip, want %+v", decoded.Changes, tx.Changes)
+	}
+}
@@ -0,0 +1,22 @@
+package types
+
+import "time"
+
+//DeadLetter A transaction a processor couldn't decode or process, it was skipped so the rest of its batch could go on.
+//`Transaction` holds the transaction as JSON, so it can be retried without the fullnode once the processor is fixed
+type DeadLetter struct {
+	Processor string `gorm:"primaryKey;size:64"`
+	Version   int64  `gorm:"primaryKey;autoIncrement:false"`
+	//EventIndex The event which failed, -1 when the transaction as a whole failed
+	EventIndex  int
+	Transaction string
+	Error       string
+	Attempts    int
+
+	CreatedAt *time.Time `gorm:"autoCreateTime"`
+	UpdatedAt *time.Time `gorm:"autoUpdateTime;not null"`
+}
+
+func (DeadLetter) TableName() string {
+	return "dead_letters"
+}
@@ -0,0 +1,20 @@
+package types
+
+import "fmt"
+
+//EventError A single event of a transaction which couldn't be decoded or processed
+type EventError struct {
+	Version int64
+	//Index Position of the event in the transaction's events
+	Index int
+	Key   string
+	Err   error
+}
+
+func (e *EventError) Error() string {
+	return fmt.Sprintf("tx %d event %s: %v", e.Version, e.Key, e.Err)
+}
+
+func (e *EventError) Unwrap() error {
+	return e.Err
+}
@@ -0,0 +1,72 @@
+package types
+
+import (
+	"apotscan/migration"
+	"gorm.io/gorm"
+	"time"
+)
+
+func init() {
+	migration.Register(
+		migration.Migration{
+			Version: 202209010300,
+			Name:    "create transactions",
+			Up: func(db *gorm.DB) error {
+				return migration.CreateTables(db, &transactionV1{})
+			},
+			Down: func(db *gorm.DB) error {
+				return migration.DropTables(db, &transactionV1{})
+			},
+		},
+		migration.Migration{
+			Version: 202209250300,
+			Name:    "add transactions key",
+			Up: func(db *gorm.DB) error {
+				return migration.RebuildTables(db, &transactionV2{})
+			},
+			Down: func(db *gorm.DB) error {
+				return migration.RebuildTables(db, &transactionV1{})
+			},
+		},
+	)
+}
+
+type transactionV1 struct {
+	Type                string
+	Payload             []byte
+	Version             int64
+	Hash                string
+	StateRootHash       string
+	EventRootHash       string
+	GasUsed             int64
+	Success             bool
+	VMStatus            string
+	AccumulatorRootHash string
+
+	CreatedAt *time.Time `gorm:"autoCreateTime"`
+	UpdatedAt *time.Time `gorm:"autoUpdateTime;not null"`
+}
+
+func (transactionV1) TableName() string {
+	return "transactions"
+}
+
+type transactionV2 struct {
+	Type                string
+	Payload             []byte
+	Version             int64 `gorm:"primaryKey;autoIncrement:false"`
+	Hash                string
+	StateRootHash       string
+	EventRootHash       string
+	GasUsed             int64
+	Success             bool
+	VMStatus            string
+	AccumulatorRootHash string
+
+	CreatedAt *time.Time `gorm:"autoCreateTime"`
+	UpdatedAt *time.Time `gorm:"autoUpdateTime;not null"`
+}
+
+func (transactionV2) TableName() string {
+	return "transactions"
+}
@@ -0,0 +1,37 @@
+package module
+
+//ABI The interface of a Move module as the fullnode describes it
+type ABI struct {
+	Address          string     `json:"address"`
+	Name             string     `json:"name"`
+	Friends          []string   `json:"friends"`
+	ExposedFunctions []Function `json:"exposed_functions"`
+	Structs          []Struct   `json:"structs"`
+}
+
+type Function struct {
+	Name              string             `json:"name"`
+	Visibility        string             `json:"visibility"`
+	IsEntry           bool               `json:"is_entry"`
+	GenericTypeParams []GenericTypeParam `json:"generic_type_params"`
+	Params            []string           `json:"params"`
+	Return            []string           `json:"return"`
+}
+
+type GenericTypeParam struct {
+	Constraints []string `json:"constraints"`
+	IsPhantom   bool     `json:"is_phantom,omitempty"`
+}
+
+type Struct struct {
+	Name              string             `json:"name"`
+	IsNative          bool               `json:"is_native"`
+	Abilities         []string           `json:"abilities"`
+	GenericTypeParams []GenericTypeParam `json:"generic_type_params"`
+	Fields            []Field            `json:"fields"`
+}
+
+type Field struct {
+	Name string `json:"name"`
+	Type string `json:"type"`
+}
@@ -1,23 +1,26 @@
 package module
 
-import "gorm.io/gorm"
+import "time"
 
+//Module A single publication of a Move module, every upgrade is kept as a new row keyed by the version publishing it.
+//`Friends`, `ExposedFunctions` and `Structs` hold the JSON of the matching `ABI` fields
 type Module struct {
-	Creator     string
-	Module      string
-	Name        string
-	Description string
-	Version     int64
+	Address          string `gorm:"primaryKey;size:66"`
+	Name             string `gorm:"primaryKey;size:255"`
+	Version          int64  `gorm:"primaryKey;autoIncrement:false"`
+	BytecodeHash     string `gorm:"size:64;index"`
+	Bytecode         string
+	ABI              string
+	Friends          string
+	ExposedFunctions string
+	Structs          string
+	Publisher        string `gorm:"size:66;index"`
+	Timestamp        int64
+
+	CreatedAt *time.Time `gorm:"autoCreateTime"`
+	UpdatedAt *time.Time `gorm:"autoUpdateTime;not null"`
 }
 
 func (Module) TableName() string {
 	return "modules"
 }
-
-func AutoCreateTokensTable(db *gorm.DB) error {
-	err := db.Set("gorm:table_options", "ENGINE=InnoDB DEFAULT CHARSET=utf8").AutoMigrate(&Module{})
-	if err != nil {
-		return err
-	}
-	return nil
-}
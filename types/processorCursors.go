@@ -0,0 +1,17 @@
+package types
+
+import "time"
+
+//ProcessorCursor The highest version below which every version has been processed by a processor,
+//it is written in the same transaction as the processor's data, so both always agree on where to resume from
+type ProcessorCursor struct {
+	Name       string `gorm:"primaryKey;size:64"`
+	ChainId    uint8  `gorm:"primaryKey;autoIncrement:false"`
+	MaxVersion int64  `gorm:"not null"`
+
+	UpdatedAt *time.Time `gorm:"autoUpdateTime;not null"`
+}
+
+func (ProcessorCursor) TableName() string {
+	return "processor_cursors"
+}
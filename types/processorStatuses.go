@@ -1,16 +1,16 @@
 package types
 
-import (
-	"gorm.io/gorm"
-	"time"
-)
+import "time"
 
+//ProcessorStatus The latest outcome of a processor on a version range, a range is started, then succeeds or fails
 type ProcessorStatus struct {
-	Name         string
-	StartVersion int64
-	EndVersion   int64
+	Name         string `gorm:"primaryKey;size:64"`
+	StartVersion int64  `gorm:"primaryKey;autoIncrement:false"`
+	EndVersion   int64  `gorm:"primaryKey;autoIncrement:false"`
 	Success      bool
 	Detail       string
+	//ErrorKind How the range failed, `error`, `panic` or `timeout`, empty unless it failed
+	ErrorKind string `gorm:"size:16"`
 
 	CreatedAt *time.Time `gorm:"autoCreateTime"`
 	UpdatedAt *time.Time `gorm:"autoUpdateTime;not null"`
@@ -29,11 +29,3 @@ func (ProcessorStatus) TableName() string {
 //	}
 //	return status
 //}
-
-func AutoCreateProcessorStatusTable(db *gorm.DB) error {
-	err := db.Set("gorm:table_options", "ENGINE=InnoDB DEFAULT CHARSET=utf8").AutoMigrate(&ProcessorStatus{})
-	if err != nil {
-		return err
-	}
-	return nil
-}
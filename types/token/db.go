@@ -1,12 +1,9 @@
 package token
 
-import (
-	"gorm.io/gorm"
-	"time"
-)
+import "time"
 
 type CollectionInDB struct {
-	CollectionId string
+	CollectionId string `gorm:"primaryKey;size:512"`
 	Creator      string
 	Name         string
 	Description  string
@@ -24,7 +21,7 @@ func (CollectionInDB) TableName() string {
 }
 
 type MetaDataInDB struct {
-	TokenId              string
+	TokenId              string `gorm:"primaryKey;size:512"`
 	Name                 string
 	Symbol               string
 	SellerFeeBasisPoints int64
@@ -45,10 +42,10 @@ func (MetaDataInDB) TableName() string {
 }
 
 type OwnershipInDB struct {
-	OwnershipId string
-	TokenId     string `gorm:"column:token_id"`
-	TokenDataId string `gorm:"column:token_data_id"`
-	Owner       string `gorm:"column:owner"`
+	OwnershipId string `gorm:"primaryKey;size:768"`
+	TokenId     string `gorm:"column:token_id;size:512;index"`
+	TokenDataId string `gorm:"column:token_data_id;size:512"`
+	Owner       string `gorm:"column:owner;size:66;index"`
 	Amount      int64
 	Version     int64
 
@@ -61,7 +58,7 @@ func (OwnershipInDB) TableName() string {
 }
 
 type TokenDataInDB struct {
-	TokenDataId              string `gorm:"column:token_data_id"`
+	TokenDataId              string `gorm:"column:token_data_id;primaryKey;size:512"`
 	Creator                  string
 	Collection               string
 	Name                     string
@@ -88,12 +85,12 @@ func (TokenDataInDB) TableName() string {
 }
 
 type TokenPropertyInDB struct {
-	TokenId         string
+	TokenId         string `gorm:"primaryKey;size:512"`
 	PreviousTokenId string
 	PropertyKeys    []byte
 	PropertyValues  []byte
 	PropertyTypes   []byte
-	Version         int64
+	Version         int64 `gorm:"primaryKey;autoIncrement:false"`
 	Timestamp       int64
 
 	CreatedAt *time.Time `gorm:"autoCreateTime"`
@@ -106,8 +103,8 @@ func (TokenPropertyInDB) TableName() string {
 
 type EventInDB struct {
 	TransactionHash string
-	Key             string
-	SequenceNumber  int64
+	Key             string `gorm:"primaryKey;size:255"`
+	SequenceNumber  int64  `gorm:"primaryKey;autoIncrement:false"`
 	Type            string
 	Data            []byte
 
@@ -120,9 +117,9 @@ func (EventInDB) TableName() string {
 }
 
 type TokenTransferEventInDB struct {
-	Version        int64
-	EventKey       string
-	SequenceNumber int64
+	Version        int64  `gorm:"primaryKey;autoIncrement:false"`
+	EventKey       string `gorm:"primaryKey;size:255"`
+	SequenceNumber int64  `gorm:"primaryKey;autoIncrement:false"`
 	TokenSeller    string
 	TokenBuyer     string
 	EventType      string
@@ -141,9 +138,9 @@ func (TokenTransferEventInDB) TableName() string {
 }
 
 type TokenActivityInDB struct {
-	Version        int64
-	EventKey       string
-	SequenceNumber int64
+	Version        int64  `gorm:"primaryKey;autoIncrement:false"`
+	EventKey       string `gorm:"primaryKey;size:255"`
+	SequenceNumber int64  `gorm:"primaryKey;autoIncrement:false"`
 
 	EventType string
 	Amount    int64
@@ -163,7 +160,7 @@ func (TokenActivityInDB) TableName() string {
 }
 
 type PendingTransfer struct {
-	PendingId string `gorm:"column:pending_id"`
+	PendingId string `gorm:"column:pending_id;primaryKey;size:64"`
 	TokenId   string
 	From      string
 	To        string
@@ -178,43 +175,3 @@ type PendingTransfer struct {
 func (PendingTransfer) TableName() string {
 	return "pending_tokens"
 }
-
-func AutoCreateTokensTable(db *gorm.DB) error {
-	err := db.Set("gorm:table_options", "ENGINE=InnoDB DEFAULT CHARSET=utf8").AutoMigrate(&CollectionInDB{})
-	if err != nil {
-		return err
-	}
-	err = db.Set("gorm:table_options", "ENGINE=InnoDB DEFAULT CHARSET=utf8").AutoMigrate(&MetaDataInDB{})
-	if err != nil {
-		return err
-	}
-	err = db.Set("gorm:table_options", "ENGINE=InnoDB DEFAULT CHARSET=utf8").AutoMigrate(&OwnershipInDB{})
-	if err != nil {
-		return err
-	}
-	err = db.Set("gorm:table_options", "ENGINE=InnoDB DEFAULT CHARSET=utf8").AutoMigrate(&TokenDataInDB{})
-	if err != nil {
-		return err
-	}
-	err = db.Set("gorm:table_options", "ENGINE=InnoDB DEFAULT CHARSET=utf8").AutoMigrate(&TokenPropertyInDB{})
-	if err != nil {
-		return err
-	}
-	err = db.Set("gorm:table_options", "ENGINE=InnoDB DEFAULT CHARSET=utf8").AutoMigrate(&EventInDB{})
-	if err != nil {
-		return err
-	}
-	err = db.Set("gorm:table_options", "ENGINE=InnoDB DEFAULT CHARSET=utf8").AutoMigrate(&TokenActivityInDB{})
-	if err != nil {
-		return err
-	}
-	err = db.Set("gorm:table_options", "ENGINE=InnoDB DEFAULT CHARSET=utf8").AutoMigrate(&TokenTransferEventInDB{})
-	if err != nil {
-		return err
-	}
-	err = db.Set("gorm:table_options", "ENGINE=InnoDB DEFAULT CHARSET=utf8").AutoMigrate(&PendingTransfer{})
-	if err != nil {
-		return err
-	}
-	return nil
-}
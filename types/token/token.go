@@ -25,19 +25,19 @@ func GetTransactionsWithTokenEvent(txs []types.Transaction) ([]*TransactionWithT
 
 func getTransactionWithTokenEvent(tx types.Transaction) ([]TokenEvent, error) {
 	var events []TokenEvent
-	for _, event := range tx.Events {
+	for i, event := range tx.Events {
 		if tx.Type != types.UserTransaction {
 			continue
 		}
 		data, err := json.Marshal(event.Data)
 		if err != nil {
-			return nil, fmt.Errorf("tx %d event %s can not be marshal with error %v", tx.Version, event.Key, err)
+			return nil, &types.EventError{Version: tx.Version, Index: i, Key: event.Key, Err: fmt.Errorf("can not be marshal with error %v", err)}
 		}
 		switch event.Type {
 		case TypeWithdrawEvent:
 			var e WithdrawEvent
 			if err = json.Unmarshal(data, &e); err != nil {
-				return nil, fmt.Errorf("tx %d event %s can not be unmarshal with error %v", tx.Version, event.Key, err)
+				return nil, &types.EventError{Version: tx.Version, Index: i, Key: event.Key, Err: fmt.Errorf("can not be unmarshal with error %v", err)}
 			}
 			events = append(events, TokenEvent{
 				Key:            event.Key,
@@ -48,7 +48,7 @@ func getTransactionWithTokenEvent(tx types.Transaction) ([]TokenEvent, error) {
 		case TypeDepositEvent:
 			var e DepositEvent
 			if err = json.Unmarshal(data, &e); err != nil {
-				return nil, fmt.Errorf("tx %d event %s can not be unmarshal with error %v", tx.Version, event.Key, err)
+				return nil, &types.EventError{Version: tx.Version, Index: i, Key: event.Key, Err: fmt.Errorf("can not be unmarshal with error %v", err)}
 			}
 			events = append(events, TokenEvent{
 				Key:            event.Key,
@@ -59,7 +59,7 @@ func getTransactionWithTokenEvent(tx types.Transaction) ([]TokenEvent, error) {
 		case TypeCreateTokenDataEvent:
 			var e CreateTokenDataEvent
 			if err = json.Unmarshal(data, &e); err != nil {
-				return nil, fmt.Errorf("tx %d event %s can not be unmarshal with error %v", tx.Version, event.Key, err)
+				return nil, &types.EventError{Version: tx.Version, Index: i, Key: event.Key, Err: fmt.Errorf("can not be unmarshal with error %v", err)}
 			}
 			events = append(events, TokenEvent{
 				Key:            event.Key,
@@ -70,7 +70,7 @@ func getTransactionWithTokenEvent(tx types.Transaction) ([]TokenEvent, error) {
 		case TypeCollectionCreationEvent:
 			var e CollectionCreationEventRaw
 			if err = json.Unmarshal(data, &e); err != nil {
-				return nil, fmt.Errorf("tx %d event %s can not be unmarshal with error %v", tx.Version, event.Key, err)
+				return nil, &types.EventError{Version: tx.Version, Index: i, Key: event.Key, Err: fmt.Errorf("can not be unmarshal with error %v", err)}
 			}
 			events = append(events, TokenEvent{
 				Key:            event.Key,
@@ -81,7 +81,7 @@ func getTransactionWithTokenEvent(tx types.Transaction) ([]TokenEvent, error) {
 		case TypeBurnTokenEvent:
 			var e BurnTokenEvent
 			if err = json.Unmarshal(data, &e); err != nil {
-				return nil, fmt.Errorf("tx %d event %s can not be unmarshal with error %v", tx.Version, event.Key, err)
+				return nil, &types.EventError{Version: tx.Version, Index: i, Key: event.Key, Err: fmt.Errorf("can not be unmarshal with error %v", err)}
 			}
 			events = append(events, TokenEvent{
 				Key:            event.Key,
@@ -92,7 +92,7 @@ func getTransactionWithTokenEvent(tx types.Transaction) ([]TokenEvent, error) {
 		case TypeMutateTokenPropertyMapEvent:
 			var e MutateTokenPropertyMapEvent
 			if err = json.Unmarshal(data, &e); err != nil {
-				return nil, fmt.Errorf("tx %d event %s can not be unmarshal with error %v", tx.Version, event.Key, err)
+				return nil, &types.EventError{Version: tx.Version, Index: i, Key: event.Key, Err: fmt.Errorf("can not be unmarshal with error %v", err)}
 			}
 			events = append(events, TokenEvent{
 				Key:            event.Key,
@@ -103,7 +103,7 @@ func getTransactionWithTokenEvent(tx types.Transaction) ([]TokenEvent, error) {
 		case TypeMintTokenEvent:
 			var e MintTokenEvent
 			if err = json.Unmarshal(data, &e); err != nil {
-				return nil, fmt.Errorf("tx %d event %s can not be unmarshal with error %v", tx.Version, event.Key, err)
+				return nil, &types.EventError{Version: tx.Version, Index: i, Key: event.Key, Err: fmt.Errorf("can not be unmarshal with error %v", err)}
 			}
 			events = append(events, TokenEvent{
 				Key:            event.Key,
@@ -114,7 +114,7 @@ func getTransactionWithTokenEvent(tx types.Transaction) ([]TokenEvent, error) {
 		case TypeTokenListingEvent:
 			var e TokenListingEvent
 			if err = json.Unmarshal(data, &e); err != nil {
-				return nil, fmt.Errorf("tx %d event %s can not be unmarshal with error %v", tx.Version, event.Key, err)
+				return nil, &types.EventError{Version: tx.Version, Index: i, Key: event.Key, Err: fmt.Errorf("can not be unmarshal with error %v", err)}
 			}
 			events = append(events, TokenEvent{
 				Key:            event.Key,
@@ -125,7 +125,7 @@ func getTransactionWithTokenEvent(tx types.Transaction) ([]TokenEvent, error) {
 		case TypeTokenSwapEvent:
 			var e TokenSwapEvent
 			if err = json.Unmarshal(data, &e); err != nil {
-				return nil, fmt.Errorf("tx %d event %s can not be unmarshal with error %v", tx.Version, event.Key, err)
+				return nil, &types.EventError{Version: tx.Version, Index: i, Key: event.Key, Err: fmt.Errorf("can not be unmarshal with error %v", err)}
 			}
 			events = append(events, TokenEvent{
 				Key:            event.Key,
@@ -2,7 +2,6 @@ package types
 
 import (
 	aptos "github.com/portto/aptos-go-sdk/client"
-	"gorm.io/gorm"
 	"strconv"
 	"time"
 )
@@ -13,13 +12,18 @@ type Transaction struct {
 	SequenceNumber string      `json:"sequence_number"`
 	Payload        JSONPayload `json:"payload"`
 
-	Type      string   `json:"type"`
-	Timestamp string   `json:"timestamp"`
-	Events    []Event  `json:"events"`
-	Version   int64    `json:"version"`
-	Hash      string   `json:"hash"`
-	Success   bool     `json:"success"`
-	Changes   []Change `json:"changes"`
+	Type                string   `json:"type"`
+	Timestamp           string   `json:"timestamp"`
+	Events              []Event  `json:"events"`
+	Version             int64    `json:"version"`
+	Hash                string   `json:"hash"`
+	StateRootHash       string   `json:"state_root_hash"`
+	EventRootHash       string   `json:"event_root_hash"`
+	AccumulatorRootHash string   `json:"accumulator_root_hash"`
+	GasUsed             string   `json:"gas_used"`
+	Success             bool     `json:"success"`
+	VMStatus            string   `json:"vm_status"`
+	Changes             []Change `json:"changes"`
 }
 
 func (transaction *Transaction) FromAptos(tx aptos.TransactionResp) error {
@@ -56,47 +60,29 @@ func (transaction *Transaction) FromAptos(tx aptos.TransactionResp) error {
 	}
 
 	var changes []Change
-	for _, change := range changes {
-		changes = append(changes, Change{
-			Type:         change.Type,
-			StateKeyHash: change.StateKeyHash,
-			Address:      change.Address,
-			Module:       change.Module,
-			Resource:     change.Resource,
-			Data: struct {
-				Handle   string                 `json:"handle"`
-				Key      string                 `json:"key"`
-				Value    string                 `json:"value"`
-				Bytecode string                 `json:"bytecode"`
-				ABI      interface{}            `json:"abi"`
-				Type     string                 `json:"type"`
-				Data     map[string]interface{} `json:"data"`
-			}{
-				Handle:   change.Data.Handle,
-				Key:      change.Data.Key,
-				Value:    change.Data.Value,
-				Bytecode: change.Data.Bytecode,
-				ABI:      change.Data.ABI,
-				Type:     change.Data.Type,
-				Data:     change.Data.Data,
-			},
-		})
+	for _, change := range tx.Changes {
+		changes = append(changes, changeFromAptos(change))
 	}
 	version, err := strconv.ParseInt(tx.Version, 10, 64)
 	if err != nil {
 		return err
 	}
 	*transaction = Transaction{
-		Sender:         tx.Sender,
-		SequenceNumber: tx.SequenceNumber,
-		Payload:        payload,
-		Type:           tx.Type,
-		Timestamp:      tx.Timestamp,
-		Events:         events,
-		Version:        version,
-		Hash:           tx.Hash,
-		Success:        tx.Success,
-		Changes:        changes,
+		Sender:              tx.Sender,
+		SequenceNumber:      tx.SequenceNumber,
+		Payload:             payload,
+		Type:                tx.Type,
+		Timestamp:           tx.Timestamp,
+		Events:              events,
+		Version:             version,
+		Hash:                tx.Hash,
+		StateRootHash:       tx.StateRootHash,
+		EventRootHash:       tx.EventRootHash,
+		AccumulatorRootHash: tx.AccumulatorRootHash,
+		GasUsed:             tx.GasUsed,
+		Success:             tx.Success,
+		VMStatus:            tx.VmStatus,
+		Changes:             changes,
 	}
 	return nil
 }
@@ -119,23 +105,6 @@ type Code struct {
 	ABI      interface{} `json:"abi,omitempty"`
 }
 
-type Change struct {
-	Type         string `json:"type"`
-	StateKeyHash string `json:"state_key_hash"`
-	Address      string `json:"address"`
-	Module       string `json:"module"`
-	Resource     string `json:"resource"`
-	Data         struct {
-		Handle   string                 `json:"handle"`
-		Key      string                 `json:"key"`
-		Value    string                 `json:"value"`
-		Bytecode string                 `json:"bytecode"`
-		ABI      interface{}            `json:"abi"`
-		Type     string                 `json:"type"`
-		Data     map[string]interface{} `json:"data"`
-	} `json:"data"`
-}
-
 type Event struct {
 	Key            string                 `json:"key"`
 	SequenceNumber string                 `json:"sequence_number"`
@@ -143,17 +112,23 @@ type Event struct {
 	Data           map[string]interface{} `json:"data"`
 }
 
+//TransactionInDB A transaction of any type, failed ones included. `Sender`, `SequenceNumber`, `EntryFunction`
+//and `Payload`, the JSON of the payload, are only set for user transactions
 type TransactionInDB struct {
-	Type                string
+	Version             int64  `gorm:"primaryKey;autoIncrement:false"`
+	Hash                string `gorm:"size:66;uniqueIndex"`
+	Type                string `gorm:"size:64"`
+	Sender              string `gorm:"size:66;index:idx_transactions_sender,priority:1"`
+	SequenceNumber      int64  `gorm:"index:idx_transactions_sender,priority:2"`
+	EntryFunction       string
 	Payload             []byte
-	Version             int64
-	Hash                string
-	StateRootHash       string
-	EventRootHash       string
+	StateRootHash       string `gorm:"size:66"`
+	EventRootHash       string `gorm:"size:66"`
+	AccumulatorRootHash string `gorm:"size:66"`
 	GasUsed             int64
 	Success             bool
 	VMStatus            string
-	AccumulatorRootHash string
+	Timestamp           int64
 
 	CreatedAt *time.Time `gorm:"autoCreateTime"`
 	UpdatedAt *time.Time `gorm:"autoUpdateTime;not null"`
@@ -162,11 +137,3 @@ type TransactionInDB struct {
 func (TransactionInDB) TableName() string {
 	return "transactions"
 }
-
-func AutoCreateTransactionTable(db *gorm.DB) error {
-	err := db.Set("gorm:table_options", "ENGINE=InnoDB DEFAULT CHARSET=utf8").AutoMigrate(&TransactionInDB{})
-	if err != nil {
-		return err
-	}
-	return nil
-}
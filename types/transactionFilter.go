@@ -0,0 +1,92 @@
+package types
+
+import "strings"
+
+//TransactionFilter Which transactions a processor wants. Every criterion which is set has to match,
+//a criterion matches if any of its values does. The zero filter matches every transaction
+type TransactionFilter struct {
+	//TransactionTypes e.g. `UserTransaction`
+	TransactionTypes []string
+	//PayloadTypes e.g. `ModuleBundlePayload`
+	PayloadTypes []string
+	//EventTypePrefixes Matches a transaction with any event whose type starts with one of them, e.g. "0x3::token::"
+	EventTypePrefixes []string
+	//EntryFunctions Entry function ids, e.g. "0x3::token::create_collection_script"
+	EntryFunctions []string
+	//Senders Sender addresses, compared case-insensitively
+	Senders []string
+	//ChangeTypes Matches a transaction with any write set change of one of these types, e.g. `WriteModuleChange`
+	ChangeTypes []string
+}
+
+//Match Whether `tx` passes every criterion which is set
+func (f *TransactionFilter) Match(tx Transaction) bool {
+	if len(f.TransactionTypes) > 0 && !contains(f.TransactionTypes, tx.Type) {
+		return false
+	}
+	if len(f.PayloadTypes) > 0 && !contains(f.PayloadTypes, tx.Payload.Type) {
+		return false
+	}
+	if len(f.EntryFunctions) > 0 && (tx.Payload.Type != EntryFunctionPayload || !contains(f.EntryFunctions, tx.Payload.Function)) {
+		return false
+	}
+	if len(f.Senders) > 0 && !containsFold(f.Senders, tx.Sender) {
+		return false
+	}
+	if len(f.EventTypePrefixes) > 0 && !hasEventWithPrefix(tx.Events, f.EventTypePrefixes) {
+		return false
+	}
+	if len(f.ChangeTypes) > 0 && !hasChangeOfType(tx.Changes, f.ChangeTypes) {
+		return false
+	}
+	return true
+}
+
+//Apply Keeps the transactions which match, in order
+func (f *TransactionFilter) Apply(txs []Transaction) []Transaction {
+	var matching []Transaction
+	for _, tx := range txs {
+		if f.Match(tx) {
+			matching = append(matching, tx)
+		}
+	}
+	return matching
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+func containsFold(values []string, value string) bool {
+	for _, v := range values {
+		if strings.EqualFold(v, value) {
+			return true
+		}
+	}
+	return false
+}
+
+func hasEventWithPrefix(events []Event, prefixes []string) bool {
+	for _, event := range events {
+		for _, prefix := range prefixes {
+			if strings.HasPrefix(event.Type, prefix) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func hasChangeOfType(changes []Change, changeTypes []string) bool {
+	for _, change := range changes {
+		if contains(changeTypes, change.Type) {
+			return true
+		}
+	}
+	return false
+}
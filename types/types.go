@@ -72,3 +72,12 @@ const (
 	ScriptPayload        = "script_payload"
 	ModuleBundlePayload  = "module_bundle_payload"
 )
+
+const (
+	WriteResourceChange   = "write_resource"
+	DeleteResourceChange  = "delete_resource"
+	WriteModuleChange     = "write_module"
+	DeleteModuleChange    = "delete_module"
+	WriteTableItemChange  = "write_table_item"
+	DeleteTableItemChange = "delete_table_item"
+)